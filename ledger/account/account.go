@@ -2,13 +2,18 @@ package account
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"encoding/asn1"
 	"encoding/hex"
+	"io"
 	"math/big"
 
 	"github.com/google/btree"
+	"github.com/pkg/errors"
 
 	"github.com/lnsp/txledger/ledger/hash"
 )
@@ -16,32 +21,91 @@ import (
 // PrivateKeyCurve is the elliptic curve in use for private key creation.
 var PrivateKeyCurve = elliptic.P256()
 
+// ErrDestroyed is returned when signing with a private key that has been destroyed.
+var ErrDestroyed = errors.New("Private key has been destroyed")
+
+const (
+	// ScalarSize is the fixed width of a serialized curve scalar or coordinate.
+	ScalarSize = 32
+	// SignatureSize is the fixed width of a serialized (r, s) signature.
+	SignatureSize = 2 * ScalarSize
+	// PublicKeySize is the fixed width of a serialized (X, Y) public key.
+	PublicKeySize = 2 * ScalarSize
+	// PrivateKeySize is the fixed width of a serialized (X, Y, D) private key.
+	PrivateKeySize = 3 * ScalarSize
+	// ChainCodeSize is the width of the chain code appended to serialized keys created by
+	// NewMaster or Derive.
+	ChainCodeSize = ScalarSize
+)
+
 // NewPublic instantiates a new public account (key) from the given byte slice.
+// It panics if the key is malformed, see NewPublicE.
 func NewPublic(key []byte) *Public {
-	X := new(big.Int).SetBytes(key[:32])
-	Y := new(big.Int).SetBytes(key[32:])
-	return &Public{&ecdsa.PublicKey{
+	pub, err := NewPublicE(key)
+	if err != nil {
+		panic(err)
+	}
+	return pub
+}
+
+// NewPublicE is like NewPublic but returns an error if the key is malformed. Keys tagged with
+// KeyTypeEd25519 are Ed25519 keys, compressed P-256 keys are tagged with the parity of Y.
+// All other keys must be PublicKeySize bytes long P-256 points.
+func NewPublicE(key []byte) (*Public, error) {
+	if KeyTypeOf(key) == KeyTypeEd25519 {
+		return &Public{ed: append(ed25519.PublicKey{}, key[1:]...)}, nil
+	}
+	if isCompressed(key) {
+		uncompressed, err := decompress(key)
+		if err != nil {
+			return nil, err
+		}
+		key = uncompressed
+	}
+	if len(key) != PublicKeySize {
+		return nil, errors.Errorf("Public key must be %d bytes, got %d", PublicKeySize, len(key))
+	}
+	X := new(big.Int).SetBytes(key[:ScalarSize])
+	Y := new(big.Int).SetBytes(key[ScalarSize:])
+	if !PrivateKeyCurve.IsOnCurve(X, Y) {
+		return nil, errors.New("Public key is not on the curve")
+	}
+	return &Public{key: &ecdsa.PublicKey{
 		Curve: PrivateKeyCurve,
 		X:     X,
 		Y:     Y,
-	}}
+	}}, nil
 }
 
 // NewPrivate generates a new private-public key pair bound to an account.
+// It panics if no key can be generated.
 func NewPrivate() *Private {
-	key, err := ecdsa.GenerateKey(PrivateKeyCurve, rand.Reader)
+	priv, err := NewPrivateE()
 	if err != nil {
 		panic(err)
 	}
-	return &Private{key}
+	return priv
 }
 
-// NewPrivateFromBytes restores the private key from a slice of bytes.
-func NewPrivateFromBytes(key []byte) *Private {
-	X := new(big.Int).SetBytes(key[:32])
-	Y := new(big.Int).SetBytes(key[32:64])
-	D := new(big.Int).SetBytes(key[64:])
-	return &Private{&ecdsa.PrivateKey{
+// NewPrivateE is like NewPrivate but returns key generation errors.
+func NewPrivateE() (*Private, error) {
+	key, err := ecdsa.GenerateKey(PrivateKeyCurve, rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not generate key")
+	}
+	return &Private{key: key}, nil
+}
+
+// NewPrivateFromSeed deterministically derives a private key from the given seed.
+func NewPrivateFromSeed(seed []byte) *Private {
+	hasher := hash.New()
+	hasher.Write(seed)
+	N := PrivateKeyCurve.Params().N
+	D := new(big.Int).SetBytes(hasher.Sum(nil))
+	D.Mod(D, new(big.Int).Sub(N, big.NewInt(1)))
+	D.Add(D, big.NewInt(1))
+	X, Y := PrivateKeyCurve.ScalarBaseMult(D.FillBytes(make([]byte, ScalarSize)))
+	return &Private{key: &ecdsa.PrivateKey{
 		PublicKey: ecdsa.PublicKey{
 			Curve: PrivateKeyCurve,
 			X:     X,
@@ -51,6 +115,42 @@ func NewPrivateFromBytes(key []byte) *Private {
 	}}
 }
 
+// NewPrivateFromBytes restores the private key and its chain code, if any, from a slice of bytes.
+// It panics if the key is not PrivateKeySize bytes long, optionally followed by the chain code.
+func NewPrivateFromBytes(key []byte) *Private {
+	priv, err := NewPrivateFromBytesE(key)
+	if err != nil {
+		panic(err)
+	}
+	return priv
+}
+
+// NewPrivateFromBytesE is like NewPrivateFromBytes but returns an error for keys
+// of the wrong length. Ed25519 keys are restored from their tagged seed.
+func NewPrivateFromBytesE(key []byte) (*Private, error) {
+	var chainCode []byte
+	if n := len(key); n == Ed25519PrivateKeySize+ChainCodeSize || n == PrivateKeySize+ChainCodeSize {
+		key, chainCode = key[:n-ChainCodeSize], append([]byte{}, key[n-ChainCodeSize:]...)
+	}
+	if len(key) == Ed25519PrivateKeySize && key[0] == KeyTypeEd25519 {
+		return &Private{ed: ed25519.NewKeyFromSeed(key[1:]), chainCode: chainCode}, nil
+	}
+	if len(key) != PrivateKeySize {
+		return nil, errors.Errorf("Private key must be %d bytes, got %d", PrivateKeySize, len(key))
+	}
+	X := new(big.Int).SetBytes(key[:ScalarSize])
+	Y := new(big.Int).SetBytes(key[ScalarSize:PublicKeySize])
+	D := new(big.Int).SetBytes(key[PublicKeySize:])
+	return &Private{key: &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: PrivateKeyCurve,
+			X:     X,
+			Y:     Y,
+		},
+		D: D,
+	}, chainCode: chainCode}, nil
+}
+
 // Account is a generic interface to an account that can send and receive funding.
 type Account interface {
 	PublicKeyBytes() []byte
@@ -58,94 +158,234 @@ type Account interface {
 	Verify(data, signature []byte) bool
 }
 
+// Equal reports whether both accounts share the same address.
+func Equal(a, b Account) bool {
+	return bytes.Equal(a.Address(), b.Address())
+}
+
+// Fingerprint returns the first 8 hex characters of the account address, suited for logging.
+func Fingerprint(a Account) string {
+	return hex.EncodeToString(a.Address()[:4])
+}
+
 // Public is a public account. Public accounts can only verify transactions.
 type Public struct {
 	key *ecdsa.PublicKey
+	ed  ed25519.PublicKey
 }
 
 // PublicKeyBytes retrieves the public key in a binary format.
 func (a *Public) PublicKeyBytes() []byte {
-	buffer := bytes.NewBuffer([]byte{})
-	buffer.Write(a.key.X.Bytes())
-	buffer.Write(a.key.Y.Bytes())
-	return buffer.Bytes()
+	if a.ed != nil {
+		return append([]byte{KeyTypeEd25519}, a.ed...)
+	}
+	return publicKeyBytes(a.key)
+}
+
+// KeyType returns the signature scheme of the key.
+func (a *Public) KeyType() byte {
+	if a.ed != nil {
+		return KeyTypeEd25519
+	}
+	return KeyTypeP256
 }
 
 // Address gets the accounts verifiable address.
 func (a *Public) Address() []byte {
 	hasher := hash.New()
 	hasher.Write(a.PublicKeyBytes())
-	return hasher.Sum()
+	return hasher.Sum(nil)
 }
 
 // Verify checks the validity of the signature on the given hash.
 func (a *Public) Verify(hash, signature []byte) bool {
-	r := new(big.Int).SetBytes(signature[:32])
-	s := new(big.Int).SetBytes(signature[32:])
-	return ecdsa.Verify(a.key, hash, r, s)
+	if a.ed != nil {
+		return len(signature) == SignatureSize && ed25519.Verify(a.ed, hash, signature)
+	}
+	return verifySignature(a.key, hash, signature)
 }
 
-// String generates a human-readable address.
+// String generates a human-readable checksummed address.
 func (a *Public) String() string {
-	return "0x" + hex.EncodeToString(a.Address())
+	return ChecksumAddress(a.Address())
 }
 
 // Private is a private account. A private account can verify and sign transactions.
 type Private struct {
 	key *ecdsa.PrivateKey
+	ed  ed25519.PrivateKey
+	// chainCode is set for keys created by NewMaster or Derive.
+	chainCode []byte
+	destroyed bool
+}
+
+// Destroy zeros the private key material. The account keeps its public key and address,
+// but signing, serialization, derivation and decryption fail with ErrDestroyed afterwards.
+func (a *Private) Destroy() {
+	if a.ed != nil {
+		zero(a.ed[:ed25519.SeedSize])
+	}
+	if a.key != nil {
+		words := a.key.D.Bits()
+		for i := range words {
+			words[i] = 0
+		}
+		a.key.D.SetInt64(0)
+	}
+	zero(a.chainCode)
+	a.destroyed = true
+}
+
+// zero overwrites the buffer with zeros.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
 }
 
-// Bytes generates a byte-representation of the private key.
+// Bytes generates a byte-representation of the private key, followed by the chain code
+// for keys created by NewMaster or Derive. It panics if the key has been destroyed, see BytesE.
 func (a *Private) Bytes() []byte {
-	buffer := bytes.NewBuffer([]byte{})
-	buffer.Write(a.PublicKeyBytes())
-	buffer.Write(a.key.D.Bytes())
-	return buffer.Bytes()
+	key, err := a.BytesE()
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// BytesE is like Bytes but returns ErrDestroyed if the key has been destroyed.
+func (a *Private) BytesE() ([]byte, error) {
+	if a.destroyed {
+		return nil, ErrDestroyed
+	}
+	if a.ed != nil {
+		return append(append([]byte{KeyTypeEd25519}, a.ed.Seed()...), a.chainCode...), nil
+	}
+	key := make([]byte, PrivateKeySize, PrivateKeySize+len(a.chainCode))
+	copy(key, a.PublicKeyBytes())
+	a.key.D.FillBytes(key[PublicKeySize:])
+	return append(key, a.chainCode...), nil
 }
 
 // PublicKeyBytes retrieves the private keys public pair in a binary format.
 func (a *Private) PublicKeyBytes() []byte {
-	buffer := bytes.NewBuffer([]byte{})
-	buffer.Write(a.key.PublicKey.X.Bytes())
-	buffer.Write(a.key.PublicKey.Y.Bytes())
-	return buffer.Bytes()
+	if a.ed != nil {
+		return append([]byte{KeyTypeEd25519}, a.ed.Public().(ed25519.PublicKey)...)
+	}
+	return publicKeyBytes(&a.key.PublicKey)
+}
+
+// KeyType returns the signature scheme of the key.
+func (a *Private) KeyType() byte {
+	if a.ed != nil {
+		return KeyTypeEd25519
+	}
+	return KeyTypeP256
+}
+
+// secret returns the private scalar or Ed25519 seed other keys are derived from.
+func (a *Private) secret() []byte {
+	if a.ed != nil {
+		return a.ed.Seed()
+	}
+	return a.key.D.FillBytes(make([]byte, ScalarSize))
 }
 
 // Address returns the hashed public-key address.
 func (a *Private) Address() []byte {
 	hasher := hash.New()
 	hasher.Write(a.PublicKeyBytes())
-	return hasher.Sum()
+	return hasher.Sum(nil)
 }
 
-// String generates a human-readable address for this private key.
+// String generates a human-readable checksummed address for this private key.
 func (a *Private) String() string {
-	return "0x" + hex.EncodeToString(a.Address())
+	return ChecksumAddress(a.Address())
 }
 
-// Sign generates a signature for the given hash.
+// Sign generates a deterministic signature for the given hash, ECDSA nonces are derived from
+// the key and the hash as described in RFC 6979. It panics if signing fails.
 func (a *Private) Sign(hash []byte) []byte {
-	r, s, err := ecdsa.Sign(rand.Reader, a.key, hash)
+	return a.SignWithRand(nil, hash)
+}
+
+// SignE is like Sign but returns signing errors, e.g. if the key has been destroyed.
+func (a *Private) SignE(hash []byte) ([]byte, error) {
+	return a.signWithRand(nil, hash)
+}
+
+// SignWithRand generates a signature for the given hash, passing random to ecdsa as
+// an additional source of entropy. A nil reader yields the deterministic signature of Sign.
+// Ed25519 signatures are deterministic and ignore the reader. It panics if signing fails.
+func (a *Private) SignWithRand(random io.Reader, hash []byte) []byte {
+	signature, err := a.signWithRand(random, hash)
 	if err != nil {
 		panic(err)
 	}
-	buffer := bytes.NewBuffer([]byte{})
-	buffer.Write(r.Bytes())
-	buffer.Write(s.Bytes())
-	return buffer.Bytes()
+	return signature
+}
+
+func (a *Private) signWithRand(random io.Reader, hash []byte) ([]byte, error) {
+	if a.destroyed {
+		return nil, ErrDestroyed
+	}
+	if a.ed != nil {
+		return ed25519.Sign(a.ed, hash), nil
+	}
+	// ECDSA only uses the leading ScalarSize bytes of the hash as an integer, so padding
+	// and truncating keeps the signature valid for the hash while fixing the digest size.
+	digest := make([]byte, ScalarSize)
+	if len(hash) < ScalarSize {
+		copy(digest[ScalarSize-len(hash):], hash)
+	} else {
+		copy(digest, hash)
+	}
+	der, err := a.key.Sign(random, digest, crypto.SHA256)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not sign hash")
+	}
+	var rs struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &rs); err != nil {
+		return nil, errors.Wrap(err, "Could not decode signature")
+	}
+	signature := make([]byte, SignatureSize)
+	rs.R.FillBytes(signature[:ScalarSize])
+	rs.S.FillBytes(signature[ScalarSize:])
+	return signature, nil
 }
 
 // Verify checks the validity of the signature on the hash.
 func (a *Private) Verify(hash, signature []byte) bool {
-	r := new(big.Int).SetBytes(signature[:32])
-	s := new(big.Int).SetBytes(signature[32:])
-	return ecdsa.Verify(&a.key.PublicKey, hash, r, s)
+	if a.ed != nil {
+		return len(signature) == SignatureSize && ed25519.Verify(a.ed.Public().(ed25519.PublicKey), hash, signature)
+	}
+	return verifySignature(&a.key.PublicKey, hash, signature)
+}
+
+// publicKeyBytes serializes the public key with both coordinates left-padded to ScalarSize.
+func publicKeyBytes(key *ecdsa.PublicKey) []byte {
+	buffer := make([]byte, PublicKeySize)
+	key.X.FillBytes(buffer[:ScalarSize])
+	key.Y.FillBytes(buffer[ScalarSize:])
+	return buffer
+}
+
+// verifySignature checks a fixed-width (r, s) signature against the public key.
+func verifySignature(key *ecdsa.PublicKey, hash, signature []byte) bool {
+	if len(signature) != SignatureSize {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:ScalarSize])
+	s := new(big.Int).SetBytes(signature[ScalarSize:])
+	return ecdsa.Verify(key, hash, r, s)
 }
 
 type AddressTreeItem struct {
 	Address []byte
 	Account Account
 	Funds   uint64
+	// Nonce is the nonce of the last transfer sent from this address.
+	Nonce uint64
 }
 
 func (item AddressTreeItem) Less(than btree.Item) bool {
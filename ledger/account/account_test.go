@@ -1,7 +1,12 @@
 package account
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -36,3 +41,538 @@ func TestPublicPrivate(t *testing.T) {
 		t.Error("Public key cannot verify own signature")
 	}
 }
+
+func TestEqual(t *testing.T) {
+	priv := NewPrivate()
+	pub := NewPublic(priv.PublicKeyBytes())
+	if !Equal(priv, pub) || !Equal(pub, priv) {
+		t.Error("Private account should equal its public account")
+	}
+	if Equal(priv, NewPrivate()) {
+		t.Error("Distinct accounts should not be equal")
+	}
+	if fp := Fingerprint(pub); fp != Fingerprint(priv) || fp != hex.EncodeToString(priv.Address())[:8] {
+		t.Errorf("Fingerprint should be the first 8 hex characters of the address, got %q", fp)
+	}
+}
+
+func TestDestroy(t *testing.T) {
+	for _, priv := range []*Private{NewPrivate(), NewPrivateEd25519()} {
+		address := priv.Address()
+		priv.Destroy()
+		if _, err := priv.SignE([]byte("example")); !errors.Is(err, ErrDestroyed) {
+			t.Error("Signing with a destroyed key should fail with ErrDestroyed, got", err)
+		}
+		if !bytes.Equal(priv.secret(), make([]byte, len(priv.secret()))) {
+			t.Error("Destroy should zero the private key material")
+		}
+		if !bytes.Equal(priv.Address(), address) {
+			t.Error("Destroy should keep the address")
+		}
+		if _, err := priv.DeriveE(0); !errors.Is(err, ErrDestroyed) {
+			t.Error("Deriving from a destroyed key should fail with ErrDestroyed, got", err)
+		}
+		if _, err := priv.BytesE(); !errors.Is(err, ErrDestroyed) {
+			t.Error("Serializing a destroyed key should fail with ErrDestroyed, got", err)
+		}
+		if _, err := priv.Decrypt(make([]byte, 128)); !errors.Is(err, ErrDestroyed) {
+			t.Error("Decrypting with a destroyed key should fail with ErrDestroyed, got", err)
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Derive should panic on a destroyed key")
+				}
+			}()
+			priv.Derive(0)
+		}()
+	}
+}
+
+func TestKeyPadding(t *testing.T) {
+	padded := 0
+	for i := 0; i < 4096 && padded < 4; i++ {
+		acc := NewPrivate()
+		key := acc.key
+		if len(key.X.Bytes()) == ScalarSize && len(key.Y.Bytes()) == ScalarSize && len(key.D.Bytes()) == ScalarSize {
+			continue
+		}
+		padded++
+		if size := len(acc.PublicKeyBytes()); size != PublicKeySize {
+			t.Fatalf("Public key should be %d bytes, got %d", PublicKeySize, size)
+		}
+		if size := len(acc.Bytes()); size != PrivateKeySize {
+			t.Fatalf("Private key should be %d bytes, got %d", PrivateKeySize, size)
+		}
+		restored := NewPrivateFromBytes(acc.Bytes())
+		if !reflect.DeepEqual(acc.Address(), restored.Address()) || !reflect.DeepEqual(acc.Address(), NewPublic(acc.PublicKeyBytes()).Address()) {
+			t.Fatal("Restored key with leading zero byte should keep its address")
+		}
+		data := []byte("example")
+		if !NewPublic(acc.PublicKeyBytes()).Verify(data, restored.Sign(data)) {
+			t.Fatal("Restored key with leading zero byte should sign verifiable data")
+		}
+	}
+	if padded == 0 {
+		t.Fatal("Should have generated a key with a leading zero byte")
+	}
+	for _, key := range [][]byte{make([]byte, PublicKeySize-1), make([]byte, PublicKeySize+1)} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewPublic should reject %d byte keys", len(key))
+				}
+			}()
+			NewPublic(key)
+		}()
+	}
+}
+
+func TestSignWithRand(t *testing.T) {
+	acc := NewPrivateFromSeed([]byte("seed"))
+	if !reflect.DeepEqual(acc.Bytes(), NewPrivateFromSeed([]byte("seed")).Bytes()) {
+		t.Error("NewPrivateFromSeed should be deterministic")
+	}
+	data := make([]byte, 32)
+	first, second := acc.Sign(data), acc.Sign(data)
+	if !reflect.DeepEqual(first, second) || !reflect.DeepEqual(first, acc.SignWithRand(nil, data)) {
+		t.Error("Signatures without a reader should be deterministic")
+	}
+	if !acc.Verify(data, first) {
+		t.Error("Deterministic signature should verify")
+	}
+	if reflect.DeepEqual(first, acc.Sign(bytes.Repeat([]byte{1}, 32))) {
+		t.Error("Signatures of different hashes should differ")
+	}
+	randomized := acc.SignWithRand(rand.Reader, data)
+	if !acc.Verify(data, randomized) || reflect.DeepEqual(first, randomized) {
+		t.Error("Signature with a reader should be randomized and verify")
+	}
+}
+
+func TestBech32(t *testing.T) {
+	acc := NewPrivate()
+	encoded, err := EncodeBech32(7, acc.Address())
+	if err != nil {
+		t.Fatal("EncodeBech32 should not fail:", err)
+	}
+	if !strings.HasPrefix(encoded, Bech32Prefix(7)) {
+		t.Error("EncodeBech32 should prepend the chain prefix")
+	}
+	decoded, err := DecodeBech32(7, encoded)
+	if err != nil {
+		t.Fatal("DecodeBech32 should not fail:", err)
+	}
+	if !reflect.DeepEqual(decoded, acc.Address()) {
+		t.Error("DecodeBech32 should match Private.Address")
+	}
+	decoded, err = DecodeBech32(7, strings.ToUpper(encoded))
+	if err != nil || !reflect.DeepEqual(decoded, acc.Address()) {
+		t.Error("DecodeBech32 should accept upper case addresses")
+	}
+	if _, err := DecodeBech32(8, encoded); err == nil {
+		t.Error("DecodeBech32 should reject address with wrong chain prefix")
+	}
+	tampered := []byte(encoded)
+	if tampered[len(tampered)-1] == 'q' {
+		tampered[len(tampered)-1] = 'p'
+	} else {
+		tampered[len(tampered)-1] = 'q'
+	}
+	if _, err := DecodeBech32(7, string(tampered)); err == nil {
+		t.Error("DecodeBech32 should reject address with invalid checksum")
+	}
+}
+
+func TestSignatureSize(t *testing.T) {
+	acc := NewPrivate()
+	for i := 0; i < 512; i++ {
+		data := []byte{byte(i), byte(i >> 8)}
+		sign := acc.Sign(data)
+		if len(sign) != SignatureSize {
+			t.Fatalf("Signature should be %d bytes, got %d", SignatureSize, len(sign))
+		}
+		if !acc.Verify(data, sign) {
+			t.Fatal("Private key cannot verify own signature")
+		}
+	}
+	if acc.Verify([]byte("example"), []byte{1, 2, 3}) {
+		t.Error("Verify should reject truncated signature")
+	}
+}
+
+func BenchmarkSign(b *testing.B) {
+	acc := NewPrivate()
+	data := []byte("example")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		acc.Sign(data)
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	acc := NewPrivate()
+	pub := NewPublic(acc.PublicKeyBytes())
+	data := []byte("example")
+	sign := acc.Sign(data)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pub.Verify(data, sign)
+	}
+}
+
+func TestPartialTransfer(t *testing.T) {
+	keys := []*Private{NewPrivate(), NewPrivate(), NewPrivate()}
+	signers := []Account{keys[0], keys[1], keys[2]}
+	partial, err := NewPartialTransfer([]byte("transfer"), 2, signers)
+	if err != nil {
+		t.Fatal("NewPartialTransfer should not fail:", err)
+	}
+	if err := partial.Sign(NewPrivate()); err == nil {
+		t.Error("Sign should reject unknown signers")
+	}
+	if err := partial.AddSignature(keys[1].Address(), keys[0].Sign(partial.Hash)); err == nil {
+		t.Error("AddSignature should reject signatures of another signer")
+	}
+	for i, key := range keys {
+		if partial.Ready() != (i >= 2) {
+			t.Errorf("Ready should be %v with %d signatures", i >= 2, i)
+		}
+		if len(partial.Missing()) != len(keys)-i {
+			t.Errorf("Missing should list %d signers, got %d", len(keys)-i, len(partial.Missing()))
+		}
+		if err := partial.Sign(key); err != nil {
+			t.Fatal("Sign should not fail:", err)
+		}
+	}
+	if partial.Signed() != 3 || !partial.Ready() {
+		t.Error("All signers should have signed")
+	}
+	if _, err := NewPartialTransfer([]byte("transfer"), 4, signers); err == nil {
+		t.Error("NewPartialTransfer should reject more required signatures than signers")
+	}
+}
+
+func TestEncryptFor(t *testing.T) {
+	alice, bob := NewPrivate(), NewPrivate()
+	key, err := alice.EncryptionPublicKey()
+	if err != nil {
+		t.Fatal("EncryptionPublicKey should not fail:", err)
+	}
+	if reflect.DeepEqual(key[1:], alice.PublicKeyBytes()) {
+		t.Error("Encryption key should differ from signing key")
+	}
+	if again, _ := alice.EncryptionPublicKey(); !reflect.DeepEqual(again, key) {
+		t.Error("Encryption key should be deterministic")
+	}
+	if public, _ := NewPrivateFromBytes(alice.Bytes()).EncryptionPublicKey(); !reflect.DeepEqual(public, key) {
+		t.Error("Restored key should derive the same encryption key")
+	}
+
+	memo := []byte("thanks for lunch")
+	bobPublic, err := bob.EncryptionPublicKey()
+	if err != nil {
+		t.Fatal("EncryptionPublicKey should not fail:", err)
+	}
+	ciphertext, err := EncryptFor(bobPublic, memo)
+	if err != nil {
+		t.Fatal("EncryptFor should not fail:", err)
+	}
+	plaintext, err := bob.Decrypt(ciphertext)
+	if err != nil || !reflect.DeepEqual(plaintext, memo) {
+		t.Error("Recipient should decrypt the memo:", err)
+	}
+	if _, err := alice.Decrypt(ciphertext); err == nil {
+		t.Error("Third party should not decrypt the memo")
+	}
+	ciphertext, err = EncryptFor(append([]byte{4}, bob.PublicKeyBytes()...), memo)
+	if err != nil {
+		t.Fatal("EncryptFor should accept any P-256 point:", err)
+	}
+	if _, err := bob.Decrypt(ciphertext); err == nil {
+		t.Error("Memos encrypted to the signing key should not decrypt")
+	}
+	if _, err := NewPrivateEd25519().EncryptionPublicKey(); !errors.Is(err, ErrEncryptionUnsupported) {
+		t.Error("Ed25519 accounts should not have an encryption key, got", err)
+	}
+}
+
+func TestMnemonicPassphrase(t *testing.T) {
+	mnemonic := strings.Repeat("abandon ", 11) + "about"
+	seed := hex.EncodeToString(SeedFromMnemonic(mnemonic, "TREZOR"))
+	if seed != "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04" {
+		t.Error("SeedFromMnemonic should match the BIP39 test vector, got", seed)
+	}
+	plain := NewPrivateFromMnemonic(mnemonic, "")
+	protected := NewPrivateFromMnemonic(mnemonic, "correct horse")
+	if reflect.DeepEqual(plain.Address(), protected.Address()) {
+		t.Error("Different passphrases should yield different accounts")
+	}
+	if !reflect.DeepEqual(protected.Address(), NewPrivateFromMnemonic("  "+mnemonic+"\n", "correct horse").Address()) {
+		t.Error("Mnemonic whitespace should not change the account")
+	}
+}
+
+func TestNewMnemonic(t *testing.T) {
+	for entropy, expected := range map[string]string{
+		"00000000000000000000000000000000":                                 strings.Repeat("abandon ", 11) + "about",
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f":                                 "legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"9e885d952ad362caeb4efe34a8e91bd2":                                 "ozone drill grab fiber curtain grace pudding thank cruise elder eight picnic",
+		"8080808080808080808080808080808080808080808080808080808080808080": "letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic avoid letter advice cage absurd amount doctor acoustic bless",
+	} {
+		raw, _ := hex.DecodeString(entropy)
+		mnemonic, err := NewMnemonic(len(raw)*8, bytes.NewReader(raw))
+		if err != nil || mnemonic != expected {
+			t.Errorf("NewMnemonic should match the BIP39 test vector for %s, got %q (%v)", entropy, mnemonic, err)
+		}
+	}
+	if len(mnemonicWords) != 2048 {
+		t.Errorf("Word list should contain 2048 words, got %d", len(mnemonicWords))
+	}
+	if mnemonic, err := NewMnemonic(MnemonicEntropyBits, nil); err != nil || len(strings.Fields(mnemonic)) != 12 {
+		t.Errorf("NewMnemonic should generate 12 words, got %q (%v)", mnemonic, err)
+	}
+	if _, err := NewMnemonic(100, nil); err == nil {
+		t.Error("NewMnemonic should reject entropy that is not a multiple of 32 bits")
+	}
+}
+
+func TestMnemonicChallenge(t *testing.T) {
+	words := strings.Fields("legal winner thank year wave sausage worth useful legal winner thank yellow")
+	challenge, err := NewMnemonicChallenge(strings.Join(words, " "), 3, nil)
+	if err != nil {
+		t.Fatal("Could not create challenge:", err)
+	}
+	if len(challenge.Positions) != 3 || challenge.Positions[0] >= challenge.Positions[1] || challenge.Positions[1] >= challenge.Positions[2] {
+		t.Fatalf("Challenge should request 3 distinct words in order, got %v", challenge.Positions)
+	}
+	var asked int
+	err = challenge.Confirm(func(position int) (string, error) {
+		asked++
+		if asked <= len(challenge.Positions) {
+			return "wrong", nil
+		}
+		return " " + strings.ToUpper(words[position]) + "\n", nil
+	}, 3)
+	if err != nil {
+		t.Error("Correct answers after a mismatch should confirm the mnemonic, got", err)
+	}
+	if asked != 2*len(challenge.Positions) {
+		t.Errorf("Mismatched answers should re-prompt all words once, asked %d times", asked)
+	}
+	if err := challenge.Confirm(func(int) (string, error) { return "wrong", nil }, 2); err == nil {
+		t.Error("Wrong answers should not confirm the mnemonic")
+	}
+	if _, err := NewMnemonicChallenge("only two", 3, nil); err == nil {
+		t.Error("Challenge should not request more words than the mnemonic has")
+	}
+}
+
+func TestSignE(t *testing.T) {
+	acc, err := NewPrivateE()
+	if err != nil {
+		t.Fatal("Could not generate key:", err)
+	}
+	hash := make([]byte, 32)
+	signature, err := acc.SignE(hash)
+	if err != nil || !acc.Verify(hash, signature) {
+		t.Fatal("SignE should produce a valid signature:", err)
+	}
+	acc.Destroy()
+	if _, err := acc.SignE(hash); err == nil {
+		t.Error("SignE should return an error for destroyed keys")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Sign should panic for destroyed keys")
+		}
+	}()
+	acc.Sign(hash)
+}
+
+func TestMalformedKeys(t *testing.T) {
+	acc := NewPrivate()
+	pub, priv := acc.PublicKeyBytes(), acc.Bytes()
+	for _, key := range [][]byte{nil, {}, pub[:ScalarSize], pub[:PublicKeySize-1], append(pub, 0)} {
+		if _, err := NewPublicE(key); err == nil {
+			t.Errorf("NewPublicE should reject a %d byte key", len(key))
+		}
+	}
+	if _, err := NewPublicE(make([]byte, PublicKeySize)); err == nil {
+		t.Error("NewPublicE should reject points off the curve")
+	}
+	for _, key := range [][]byte{nil, {}, priv[:PublicKeySize], priv[:PrivateKeySize-1]} {
+		if _, err := NewPrivateFromBytesE(key); err == nil {
+			t.Errorf("NewPrivateFromBytesE should reject a %d byte key", len(key))
+		}
+	}
+	if restored, err := NewPrivateFromBytesE(priv); err != nil || !bytes.Equal(restored.Address(), acc.Address()) {
+		t.Error("NewPrivateFromBytesE should restore a well-formed key:", err)
+	}
+	if parsed, err := NewPublicE(pub); err != nil || !bytes.Equal(parsed.Address(), acc.Address()) {
+		t.Error("NewPublicE should parse a well-formed key:", err)
+	}
+}
+
+func TestChecksumAddress(t *testing.T) {
+	address, _ := hex.DecodeString("f54cc6a1aa37d8614395d7cd30496706ccf7a27c9c3255bfa2f8c44a310958c7")
+	encoded := ChecksumAddress(address)
+	if encoded != "0xF54CC6a1aA37d8614395D7Cd30496706ccf7A27c9C3255bFa2f8c44A310958C7" {
+		t.Fatalf("Unexpected checksummed address %s", encoded)
+	}
+	for _, s := range []string{encoded, strings.ToLower(encoded), "0x" + strings.ToUpper(encoded[2:]), encoded[2:]} {
+		parsed, err := ParseAddress(s)
+		if err != nil || !bytes.Equal(parsed, address) {
+			t.Errorf("ParseAddress(%s) should succeed, got %v", s, err)
+		}
+	}
+	mutated := []byte(encoded)
+	mutated[4] = '5'
+	if _, err := ParseAddress(string(mutated)); err == nil {
+		t.Error("ParseAddress should reject an address with a mistyped digit")
+	}
+	flipped := []byte(encoded)
+	flipped[2] = 'f'
+	if _, err := ParseAddress(string(flipped)); err == nil {
+		t.Error("ParseAddress should reject an address with a flipped case")
+	}
+	for _, s := range []string{"0x1234", "0xzz", ""} {
+		if _, err := ParseAddress(s); err == nil {
+			t.Errorf("ParseAddress(%q) should fail", s)
+		}
+	}
+	acc := NewPrivate()
+	if acc.String() != ChecksumAddress(acc.Address()) || NewPublic(acc.PublicKeyBytes()).String() != acc.String() {
+		t.Error("Account strings should be checksummed addresses")
+	}
+}
+
+func TestDerive(t *testing.T) {
+	seed := SeedFromMnemonic("abandon ability able about above absent", "")
+	derive := func() []string {
+		master := NewMaster(seed)
+		addresses := make([]string, 0, 6)
+		for i := uint32(0); i < 6; i++ {
+			addresses = append(addresses, master.Derive(i).String())
+		}
+		return addresses
+	}
+	first, second := derive(), derive()
+	if !reflect.DeepEqual(first, second) {
+		t.Fatal("Derivation should be reproducible")
+	}
+	seen := make(map[string]bool)
+	for _, address := range first {
+		if seen[address] {
+			t.Fatal("Derived addresses should be distinct")
+		}
+		seen[address] = true
+	}
+	if NewMaster(seed).String() == NewMaster(append(seed, 0)).String() {
+		t.Error("Different seeds should yield different masters")
+	}
+	grandchild := NewMaster(seed).Derive(1).Derive(2)
+	if grandchild.String() != NewMaster(seed).Derive(1).Derive(2).String() || seen[grandchild.String()] {
+		t.Error("Nested derivation should be reproducible and distinct from children")
+	}
+	hash := make([]byte, 32)
+	if child := NewMaster(seed).Derive(3); !NewPublic(child.PublicKeyBytes()).Verify(hash, child.Sign(hash)) {
+		t.Error("Derived keys should sign verifiable signatures")
+	}
+	master := NewMaster(seed)
+	if len(master.Bytes()) != PrivateKeySize+ChainCodeSize {
+		t.Errorf("Master key should be serialized with its chain code, got %d bytes", len(master.Bytes()))
+	}
+	restored, err := NewPrivateFromBytesE(master.Bytes())
+	if err != nil || restored.Derive(4).String() != master.Derive(4).String() {
+		t.Error("Restored master should derive the same children:", err)
+	}
+	child := master.Derive(4)
+	if restored := NewPrivateFromBytes(child.Bytes()); restored.Derive(0).String() != child.Derive(0).String() {
+		t.Error("Restored child should derive the same grandchildren")
+	}
+}
+
+func TestEd25519(t *testing.T) {
+	priv := NewPrivateEd25519()
+	if priv.KeyType() != KeyTypeEd25519 || len(priv.PublicKeyBytes()) != Ed25519PublicKeySize {
+		t.Fatalf("Ed25519 key should be tagged, got %x", priv.PublicKeyBytes())
+	}
+	restored, err := NewPrivateFromBytesE(priv.Bytes())
+	if err != nil || !bytes.Equal(restored.PublicKeyBytes(), priv.PublicKeyBytes()) {
+		t.Fatal("Ed25519 key should survive a bytes round trip:", err)
+	}
+	pub, err := NewPublicE(priv.PublicKeyBytes())
+	if err != nil || !bytes.Equal(pub.Address(), priv.Address()) {
+		t.Fatal("Ed25519 public key should decode to the same address:", err)
+	}
+	hash := []byte("ed25519 message hash")
+	signature := priv.Sign(hash)
+	if !pub.Verify(hash, signature) || !priv.Verify(hash, signature) {
+		t.Error("Ed25519 signature should verify")
+	}
+	if pub.Verify([]byte("other hash"), signature) || pub.Verify(hash, signature[1:]) {
+		t.Error("Ed25519 signature should not verify other hashes or truncated signatures")
+	}
+	if NewPrivate().Verify(hash, signature) {
+		t.Error("P-256 key should reject an Ed25519 signature")
+	}
+	child := priv.Derive(0)
+	if child.KeyType() != KeyTypeEd25519 || bytes.Equal(child.Bytes(), priv.Bytes()) {
+		t.Error("Derived Ed25519 keys should be distinct Ed25519 keys")
+	}
+	if restored := NewPrivateFromBytes(child.Bytes()); restored.Derive(1).String() != child.Derive(1).String() {
+		t.Error("Restored Ed25519 child should derive the same keys")
+	}
+}
+
+func TestCompressedPublicKey(t *testing.T) {
+	for i := 0; i < 16; i++ {
+		priv := NewPrivate()
+		compressed := priv.CompressedPublicKeyBytes()
+		if len(compressed) != CompressedPublicKeySize || len(compressed) >= len(priv.PublicKeyBytes()) {
+			t.Fatalf("Compressed key should be %d bytes instead of %d, got %d", CompressedPublicKeySize, len(priv.PublicKeyBytes()), len(compressed))
+		}
+		if KeySize(compressed) != CompressedPublicKeySize {
+			t.Errorf("Compressed key should be sized by its tag, got %d", KeySize(compressed))
+		}
+		pub, err := NewPublicE(compressed)
+		if err != nil {
+			t.Fatal("Compressed key should decode:", err)
+		}
+		if !bytes.Equal(pub.PublicKeyBytes(), priv.PublicKeyBytes()) || !bytes.Equal(pub.Address(), priv.Address()) {
+			t.Error("Compressed and uncompressed encodings should share the key and address")
+		}
+		if !bytes.Equal(pub.CompressedPublicKeyBytes(), compressed) {
+			t.Error("Compressed key should survive a round trip")
+		}
+		hash := []byte("compressed key hash")
+		if !pub.Verify(hash, priv.Sign(hash)) {
+			t.Error("Decompressed key should verify signatures")
+		}
+	}
+	invalid := append([]byte{tagCompressedEven}, bytes.Repeat([]byte{0xff}, ScalarSize)...)
+	if _, err := NewPublicE(invalid); err == nil {
+		t.Error("Compressed key off the curve should be rejected")
+	}
+}
+
+func TestSignMessage(t *testing.T) {
+	for _, priv := range []*Private{NewPrivate(), NewPrivateEd25519()} {
+		pub := NewPublic(priv.PublicKeyBytes())
+		msg := []byte("login nonce 42")
+		sig := SignMessage(priv, msg)
+		if !VerifyMessage(priv.Address(), msg, sig, pub) {
+			t.Error("Message signature should verify")
+		}
+		if VerifyMessage(priv.Address(), []byte("login nonce 43"), sig, pub) {
+			t.Error("Message signature should not verify another message")
+		}
+		if VerifyMessage(NewPrivate().Address(), msg, sig, pub) {
+			t.Error("Message signature should not verify for another address")
+		}
+		if pub.Verify(msg, sig) {
+			t.Error("Message signature should not verify the raw message")
+		}
+	}
+}
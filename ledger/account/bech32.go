@@ -0,0 +1,145 @@
+package account
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Bech32Charset is the alphabet used for the base32 address encoding.
+	Bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+	// Bech32Separator splits the human-readable prefix from the data part.
+	Bech32Separator = '1'
+	// Bech32ChecksumSize is the number of base32 characters used by the checksum.
+	Bech32ChecksumSize = 6
+	// Bech32MaxLength is the maximum length of an encoded address.
+	Bech32MaxLength = 90
+)
+
+var bech32Generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// Bech32Prefix returns the human-readable prefix of addresses on the given chain.
+func Bech32Prefix(chain uint64) string {
+	return "tx" + strconv.FormatUint(chain, 10)
+}
+
+// EncodeBech32 encodes the address as a checksummed base32 string bound to the given chain.
+func EncodeBech32(chain uint64, address []byte) (string, error) {
+	hrp := Bech32Prefix(chain)
+	data, err := convertBits(address, 8, 5, true)
+	if err != nil {
+		return "", errors.Wrap(err, "Could not convert address")
+	}
+	if len(hrp)+1+len(data)+Bech32ChecksumSize > Bech32MaxLength {
+		return "", errors.New("Address too long")
+	}
+	var builder strings.Builder
+	builder.WriteString(hrp)
+	builder.WriteByte(Bech32Separator)
+	for _, c := range append(data, bech32Checksum(hrp, data)...) {
+		builder.WriteByte(Bech32Charset[c])
+	}
+	return builder.String(), nil
+}
+
+// DecodeBech32 decodes a base32 address and verifies its checksum and chain prefix.
+func DecodeBech32(chain uint64, encoded string) ([]byte, error) {
+	if len(encoded) > Bech32MaxLength {
+		return nil, errors.New("Address too long")
+	}
+	if strings.ToLower(encoded) != encoded && strings.ToUpper(encoded) != encoded {
+		return nil, errors.New("Address has mixed case")
+	}
+	encoded = strings.ToLower(encoded)
+	sep := strings.LastIndexByte(encoded, Bech32Separator)
+	if sep < 1 || sep+Bech32ChecksumSize+1 > len(encoded) {
+		return nil, errors.New("Address separator misplaced")
+	}
+	hrp := encoded[:sep]
+	if hrp != Bech32Prefix(chain) {
+		return nil, errors.Errorf("Address prefix %s does not match chain %d", hrp, chain)
+	}
+	data := make([]byte, 0, len(encoded)-sep-1)
+	for _, c := range encoded[sep+1:] {
+		value := strings.IndexRune(Bech32Charset, c)
+		if value < 0 {
+			return nil, errors.Errorf("Invalid address character %q", c)
+		}
+		data = append(data, byte(value))
+	}
+	if bech32Polymod(append(bech32ExpandPrefix(hrp), data...)) != 1 {
+		return nil, errors.New("Invalid address checksum")
+	}
+	address, err := convertBits(data[:len(data)-Bech32ChecksumSize], 5, 8, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not convert address")
+	}
+	return address, nil
+}
+
+func bech32Polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := range bech32Generator {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= bech32Generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32ExpandPrefix(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := range hrp {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := range hrp {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+func bech32Checksum(hrp string, data []byte) []byte {
+	values := append(bech32ExpandPrefix(hrp), data...)
+	values = append(values, make([]byte, Bech32ChecksumSize)...)
+	mod := bech32Polymod(values) ^ 1
+	checksum := make([]byte, Bech32ChecksumSize)
+	for i := range checksum {
+		checksum[i] = byte(mod>>uint(5*(5-i))) & 31
+	}
+	return checksum
+}
+
+func convertBits(data []byte, from, to uint, pad bool) ([]byte, error) {
+	var (
+		acc    uint32
+		bits   uint
+		result []byte
+	)
+	maxv := uint32(1)<<to - 1
+	for _, value := range data {
+		if uint32(value)>>from != 0 {
+			return nil, errors.New("Invalid data range")
+		}
+		acc = acc<<from | uint32(value)
+		bits += from
+		for bits >= to {
+			bits -= to
+			result = append(result, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(to-bits)&maxv))
+		}
+	} else if bits >= from || acc<<(to-bits)&maxv != 0 {
+		return nil, errors.New("Invalid padding")
+	}
+	return result, nil
+}
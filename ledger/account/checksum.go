@@ -0,0 +1,52 @@
+package account
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger/hash"
+)
+
+// ChecksumAddress encodes the address as 0x-prefixed hex whose letter case carries a checksum.
+// A letter is upper case if the corresponding nibble of the hash of the lower case hex is at least 8.
+func ChecksumAddress(address []byte) string {
+	lower := hex.EncodeToString(address)
+	hasher := hash.New()
+	hasher.Write([]byte(lower))
+	digest := hasher.Sum(nil)
+	encoded := []byte(lower)
+	for i, c := range encoded {
+		if c < 'a' || i/2 >= len(digest) {
+			continue
+		}
+		nibble := digest[i/2] >> 4
+		if i%2 == 1 {
+			nibble = digest[i/2] & 0xf
+		}
+		if nibble >= 8 {
+			encoded[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(encoded)
+}
+
+// ParseAddress decodes an address in hex with an optional 0x prefix. Mixed case addresses
+// must carry a valid checksum, all lower or all upper case addresses are not checked.
+func ParseAddress(s string) ([]byte, error) {
+	encoded := strings.TrimPrefix(s, "0x")
+	address, err := hex.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid address format")
+	}
+	if len(address) != hash.New().Size() {
+		return nil, errors.Errorf("Address must be %d bytes, got %d", hash.New().Size(), len(address))
+	}
+	if encoded != strings.ToLower(encoded) && encoded != strings.ToUpper(encoded) {
+		if ChecksumAddress(address) != "0x"+encoded {
+			return nil, errors.New("Address checksum does not match")
+		}
+	}
+	return address, nil
+}
@@ -0,0 +1,61 @@
+package account
+
+import (
+	"crypto/elliptic"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// tagCompressedEven and tagCompressedOdd mark compressed P-256 keys by the parity of Y.
+	tagCompressedEven byte = 2
+	tagCompressedOdd  byte = 3
+	// CompressedPublicKeySize is the width of a compressed (parity, X) public key.
+	CompressedPublicKeySize = 1 + ScalarSize
+)
+
+// KeySize returns the length an encoded public key starting with the given tag byte must have.
+// Keys without a known tag are expected to be uncompressed P-256 keys.
+func KeySize(key []byte) int {
+	if len(key) == CompressedPublicKeySize {
+		switch key[0] {
+		case KeyTypeEd25519, tagCompressedEven, tagCompressedOdd:
+			return CompressedPublicKeySize
+		}
+	}
+	return PublicKeySize
+}
+
+// isCompressed checks if the key is a tagged compressed P-256 key.
+func isCompressed(key []byte) bool {
+	return len(key) == CompressedPublicKeySize && (key[0] == tagCompressedEven || key[0] == tagCompressedOdd)
+}
+
+// decompress restores the uncompressed (X, Y) encoding of a compressed key.
+func decompress(key []byte) ([]byte, error) {
+	X, Y := elliptic.UnmarshalCompressed(PrivateKeyCurve, key)
+	if X == nil {
+		return nil, errors.New("Compressed public key is not on the curve")
+	}
+	uncompressed := make([]byte, PublicKeySize)
+	X.FillBytes(uncompressed[:ScalarSize])
+	Y.FillBytes(uncompressed[ScalarSize:])
+	return uncompressed, nil
+}
+
+// CompressedPublicKeyBytes retrieves the public key in the compressed binary format.
+// Ed25519 keys are already compact and returned as by PublicKeyBytes.
+func (a *Public) CompressedPublicKeyBytes() []byte {
+	if a.ed != nil {
+		return a.PublicKeyBytes()
+	}
+	return elliptic.MarshalCompressed(PrivateKeyCurve, a.key.X, a.key.Y)
+}
+
+// CompressedPublicKeyBytes retrieves the private keys public pair in the compressed binary format.
+func (a *Private) CompressedPublicKeyBytes() []byte {
+	if a.ed != nil {
+		return a.PublicKeyBytes()
+	}
+	return elliptic.MarshalCompressed(PrivateKeyCurve, a.key.X, a.key.Y)
+}
@@ -3,26 +3,78 @@ package container
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"io"
-	"os"
 
 	"github.com/lnsp/txledger/ledger/account"
 	"github.com/lnsp/txledger/ledger/hash"
+	"github.com/lnsp/txledger/ledger/storage"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
 )
 
-// Container is a serializable wrapper for encrypted private keys.
+const (
+	// VersionLegacy containers derive the key by a single hash of the passphrase.
+	VersionLegacy = 0
+	// VersionScrypt containers derive the key with scrypt using the stored parameters.
+	VersionScrypt = 1
+	// CurrentVersion is the version of newly created containers.
+	CurrentVersion = VersionScrypt
+
+	// SaltSize is the length of the random scrypt salt in bytes.
+	SaltSize = 32
+	// MaxScryptMemory bounds the memory scrypt may use for stored parameters, 128 * N * r bytes.
+	MaxScryptMemory = 1 << 30
+	keySize         = 32
+)
+
+var (
+	// ErrWrongPassphrase is returned by Unlock if the passphrase does not match the container's check.
+	ErrWrongPassphrase = errors.New("Wrong passphrase")
+	// ErrWatchOnly is returned by Unlock for containers without a private key.
+	ErrWatchOnly = errors.New("Watch-only account can not be unlocked")
+	// ErrCorrupt is returned by Unlock if the passphrase matches but the key can not be decrypted.
+	ErrCorrupt = errors.New("Container is corrupt")
+)
+
+// checkLabel is authenticated with the derived key to detect wrong passphrases before decryption.
+var checkLabel = []byte("txledger container check")
+
+// DefaultParams are the scrypt parameters used for new containers.
+var DefaultParams = KDFParams{N: 1 << 15, R: 8, P: 1}
+
+// KDFParams are the scrypt parameters and hex-encoded salt of a container.
+type KDFParams struct {
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	Salt string `json:"salt,omitempty"`
+}
+
+// Container is a serializable wrapper for encrypted private keys. Watch-only containers
+// hold only the public key.
 type Container struct {
-	PublicKey           string `json:"public"`
-	EncryptedPrivateKey string `json:"private"`
+	Version             int        `json:"version"`
+	PublicKey           string     `json:"public"`
+	EncryptedPrivateKey string     `json:"private,omitempty"`
+	KDF                 *KDFParams `json:"kdf,omitempty"`
+	// Check is a MAC of a fixed label under the derived key. Legacy containers have none,
+	// so a wrong passphrase and a corrupt key can not be told apart.
+	Check string `json:"check,omitempty"`
 }
 
 // ReadFromFile decodes an account container from file.
 func ReadFromFile(path string) (Container, error) {
-	file, err := os.Open(path)
+	return ReadFromStorage(storage.Dir(""), path)
+}
+
+// ReadFromStorage decodes the account container stored under the given name.
+func ReadFromStorage(s storage.Storage, name string) (Container, error) {
+	file, err := s.Read(name)
 	if err != nil {
 		return Container{}, errors.Wrap(err, "Could not create container")
 	}
@@ -37,68 +89,189 @@ func ReadFromFile(path string) (Container, error) {
 
 // WriteToFile encodes an account container to a file.
 func WriteToFile(c Container, path string) error {
-	file, err := os.Create(path)
+	return WriteToStorage(c, storage.Dir(""), path)
+}
+
+// WriteToStorage encodes an account container and stores it under the given name.
+func WriteToStorage(c Container, s storage.Storage, name string) error {
+	file, err := s.Write(name)
 	if err != nil {
 		return errors.Wrap(err, "Could not create file")
 	}
-	defer file.Close()
 	encoder := json.NewEncoder(file)
 	if err := encoder.Encode(c); err != nil {
+		file.Close()
 		return errors.Wrap(err, "Could not encode container")
 	}
-	return nil
+	return errors.Wrap(file.Close(), "Could not close file")
 }
 
-// Unlock decrypts the contained private key and returns the account.
+// Unlock decrypts the contained private key and returns the account. Containers carrying
+// a check return ErrWrongPassphrase or ErrCorrupt, wrapped, to tell the failures apart.
 func (c Container) Unlock(passphrase []byte) (*account.Private, error) {
-	hasher := hash.New()
-	hasher.Write(passphrase)
-	hashedPassphrase := hasher.Sum()
-	ciph, err := aes.NewCipher(hashedPassphrase)
+	if c.IsWatchOnly() {
+		return nil, ErrWatchOnly
+	}
+	key, err := c.key(passphrase)
 	if err != nil {
-		return nil, errors.Wrap(err, "Could not create ciphersuite")
+		return nil, err
 	}
-	gcm, err := cipher.NewGCM(ciph)
+	if c.Check != "" {
+		expected, err := hex.DecodeString(c.Check)
+		if err != nil {
+			return nil, errors.Wrap(ErrCorrupt, "Invalid check format")
+		}
+		if !hmac.Equal(check(key), expected) {
+			return nil, ErrWrongPassphrase
+		}
+	}
+	gcm, err := newGCM(key)
 	if err != nil {
-		return nil, errors.Wrap(err, "Could not create GCM")
+		return nil, err
 	}
 	ciphertext, err := hex.DecodeString(c.EncryptedPrivateKey)
 	if err != nil {
-		return nil, errors.Wrap(err, "Invalid encrypted private key format")
+		return nil, errors.Wrap(ErrCorrupt, "Invalid encrypted private key format")
 	}
 	nonceSize := gcm.NonceSize()
 	if len(ciphertext) < nonceSize {
-		return nil, errors.New("Encrypted private key too short")
+		return nil, errors.Wrap(ErrCorrupt, "Encrypted private key too short")
 	}
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
 	bytes, err := gcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
+		if c.Check != "" {
+			return nil, errors.Wrap(ErrCorrupt, "Could not unseal container")
+		}
 		return nil, errors.New("Could not unseal container")
 	}
-	acc := account.NewPrivateFromBytes(bytes)
+	acc, err := account.NewPrivateFromBytesE(bytes)
+	for i := range bytes {
+		bytes[i] = 0
+	}
+	if err != nil {
+		return nil, errors.Wrap(ErrCorrupt, err.Error())
+	}
 	return acc, nil
 }
 
-// New creates a new container with the given passphrase and private key.
+// New creates a new container with the given passphrase and private key, using scrypt
+// with the default parameters and a random salt.
 func New(passphrase []byte, acc *account.Private) (Container, error) {
-	hasher := hash.New()
-	hasher.Write([]byte(passphrase))
-	hashedPassphrase := hasher.Sum()
-	ciph, err := aes.NewCipher(hashedPassphrase)
+	salt := make([]byte, SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return Container{}, errors.Wrap(err, "Could not generate salt")
+	}
+	params := DefaultParams
+	params.Salt = hex.EncodeToString(salt)
+	c := Container{
+		Version:   CurrentVersion,
+		PublicKey: hex.EncodeToString(acc.PublicKeyBytes()),
+		KDF:       &params,
+	}
+	key, err := c.key(passphrase)
 	if err != nil {
-		return Container{}, errors.Wrap(err, "Could not create ciphersuite")
+		return Container{}, err
 	}
-	gcm, err := cipher.NewGCM(ciph)
+	gcm, err := newGCM(key)
 	if err != nil {
-		return Container{}, errors.Wrap(err, "Could not create GCM")
+		return Container{}, err
 	}
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return Container{}, errors.Wrap(err, "Could not generate nonce")
 	}
-	bytes := gcm.Seal(nonce, nonce, acc.Bytes(), nil)
+	secret, err := acc.BytesE()
+	if err != nil {
+		return Container{}, err
+	}
+	c.EncryptedPrivateKey = hex.EncodeToString(gcm.Seal(nonce, nonce, secret, nil))
+	for i := range secret {
+		secret[i] = 0
+	}
+	c.Check = hex.EncodeToString(check(key))
+	return c, nil
+}
+
+// NewWatchOnly creates a container holding only the public key of the account.
+func NewWatchOnly(pub account.Account) Container {
 	return Container{
-		PublicKey:           hex.EncodeToString(acc.PublicKeyBytes()),
-		EncryptedPrivateKey: hex.EncodeToString(bytes),
-	}, nil
+		Version:   CurrentVersion,
+		PublicKey: hex.EncodeToString(pub.PublicKeyBytes()),
+	}
+}
+
+// IsWatchOnly returns true if the container holds no private key.
+func (c Container) IsWatchOnly() bool {
+	return c.EncryptedPrivateKey == ""
+}
+
+// Public decodes the contained public key, which can verify signatures without unlocking.
+func (c Container) Public() (*account.Public, error) {
+	key, err := hex.DecodeString(c.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(ErrCorrupt, "Invalid public key format")
+	}
+	pub, err := account.NewPublicE(key)
+	if err != nil {
+		return nil, errors.Wrap(ErrCorrupt, err.Error())
+	}
+	return pub, nil
+}
+
+// ChangePassphrase re-seals the contained private key under a new passphrase. The returned
+// container holds the same account and uses the current container version.
+func (c Container) ChangePassphrase(old, new []byte) (Container, error) {
+	acc, err := c.Unlock(old)
+	if err != nil {
+		return Container{}, errors.Wrap(err, "Could not unlock container")
+	}
+	return New(new, acc)
+}
+
+// key derives the encryption key from the passphrase according to the container version.
+func (c Container) key(passphrase []byte) ([]byte, error) {
+	switch c.Version {
+	case VersionLegacy:
+		hasher := hash.New()
+		hasher.Write(passphrase)
+		return hasher.Sum(nil), nil
+	case VersionScrypt:
+		if c.KDF == nil {
+			return nil, errors.New("Missing key derivation parameters")
+		}
+		if c.KDF.N > MaxScryptMemory/128 || c.KDF.N > 0 && c.KDF.R > MaxScryptMemory/(128*c.KDF.N) {
+			return nil, errors.Errorf("Scrypt parameters exceed %d bytes of memory", MaxScryptMemory)
+		}
+		salt, err := hex.DecodeString(c.KDF.Salt)
+		if err != nil || len(salt) == 0 {
+			return nil, errors.New("Invalid salt format")
+		}
+		key, err := scrypt.Key(passphrase, salt, c.KDF.N, c.KDF.R, c.KDF.P, keySize)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not derive key")
+		}
+		return key, nil
+	default:
+		return nil, errors.Errorf("Unsupported container version %d", c.Version)
+	}
+}
+
+// check authenticates the fixed label under the derived key.
+func check(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(checkLabel)
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	ciph, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create ciphersuite")
+	}
+	gcm, err := cipher.NewGCM(ciph)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create GCM")
+	}
+	return gcm, nil
 }
@@ -0,0 +1,223 @@
+package container
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lnsp/txledger/ledger/account"
+	"github.com/pkg/errors"
+)
+
+// legacy creates a container the way containers were created before scrypt.
+func legacy(t *testing.T, passphrase []byte, acc *account.Private) Container {
+	c := Container{Version: VersionLegacy, PublicKey: hex.EncodeToString(acc.PublicKeyBytes())}
+	key, _ := c.key(passphrase)
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal("Could not create GCM:", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	io.ReadFull(rand.Reader, nonce)
+	c.EncryptedPrivateKey = hex.EncodeToString(gcm.Seal(nonce, nonce, acc.Bytes(), nil))
+	return c
+}
+
+func TestUnlock(t *testing.T) {
+	passphrase := []byte("correct horse")
+	acc := account.NewPrivate()
+	current, err := New(passphrase, acc)
+	if err != nil {
+		t.Fatal("Could not create container:", err)
+	}
+	if current.Version != VersionScrypt || current.KDF == nil || current.KDF.Salt == "" {
+		t.Fatalf("New containers should use scrypt with a salt, got %+v", current)
+	}
+	path := filepath.Join(t.TempDir(), "account")
+	for name, c := range map[string]Container{"legacy": legacy(t, passphrase, acc), "scrypt": current} {
+		if err := WriteToFile(c, path); err != nil {
+			t.Fatal("Could not write container:", err)
+		}
+		read, err := ReadFromFile(path)
+		if err != nil {
+			t.Fatal("Could not read container:", err)
+		}
+		unlocked, err := read.Unlock(passphrase)
+		if err != nil {
+			t.Fatalf("Could not unlock %s container: %v", name, err)
+		}
+		if !bytes.Equal(unlocked.Bytes(), acc.Bytes()) {
+			t.Errorf("Unlocked %s container should hold the original key", name)
+		}
+		if _, err := read.Unlock([]byte("wrong")); err == nil {
+			t.Errorf("%s container should not unlock with a wrong passphrase", name)
+		}
+	}
+}
+
+func TestUnlockParams(t *testing.T) {
+	c, err := New([]byte("passphrase"), account.NewPrivate())
+	if err != nil {
+		t.Fatal("Could not create container:", err)
+	}
+	other, err := New([]byte("passphrase"), account.NewPrivate())
+	if err != nil {
+		t.Fatal("Could not create container:", err)
+	}
+	if c.KDF.Salt == other.KDF.Salt {
+		t.Error("Containers should use random salts")
+	}
+	huge := *c.KDF
+	huge.N = 1 << 30
+	c.KDF = &huge
+	if _, err := c.Unlock([]byte("passphrase")); err == nil {
+		t.Error("Containers demanding excessive memory should be rejected")
+	}
+	c.Version = 7
+	if _, err := c.Unlock([]byte("passphrase")); err == nil {
+		t.Error("Unknown container versions should be rejected")
+	}
+}
+
+func TestChangePassphrase(t *testing.T) {
+	acc := account.NewPrivate()
+	current, err := New([]byte("old"), acc)
+	if err != nil {
+		t.Fatal("Could not create container:", err)
+	}
+	for name, c := range map[string]Container{"legacy": legacy(t, []byte("old"), acc), "scrypt": current} {
+		if _, err := c.ChangePassphrase([]byte("wrong"), []byte("new")); err == nil {
+			t.Errorf("Changing the passphrase of a %s container should fail with a wrong passphrase", name)
+		}
+		changed, err := c.ChangePassphrase([]byte("old"), []byte("new"))
+		if err != nil {
+			t.Fatalf("Could not change passphrase of %s container: %v", name, err)
+		}
+		if changed.PublicKey != c.PublicKey || changed.Version != CurrentVersion {
+			t.Errorf("Changed %s container should keep the account and use the current version", name)
+		}
+		if _, err := changed.Unlock([]byte("old")); err == nil {
+			t.Errorf("Changed %s container should not unlock with the old passphrase", name)
+		}
+		unlocked, err := changed.Unlock([]byte("new"))
+		if err != nil || !bytes.Equal(unlocked.Address(), acc.Address()) {
+			t.Errorf("Changed %s container should unlock the same account with the new passphrase", name)
+		}
+	}
+}
+
+func TestUnlockErrors(t *testing.T) {
+	c, err := New([]byte("passphrase"), account.NewPrivate())
+	if err != nil {
+		t.Fatal("Could not create container:", err)
+	}
+	if c.Check == "" {
+		t.Fatal("New containers should carry a passphrase check")
+	}
+	if _, err := c.Unlock([]byte("wrong")); errors.Cause(err) != ErrWrongPassphrase {
+		t.Errorf("Wrong passphrase should return ErrWrongPassphrase, got %v", err)
+	}
+	corrupt := c
+	ciphertext, _ := hex.DecodeString(c.EncryptedPrivateKey)
+	ciphertext[len(ciphertext)-1] ^= 0xff
+	corrupt.EncryptedPrivateKey = hex.EncodeToString(ciphertext)
+	if _, err := corrupt.Unlock([]byte("passphrase")); errors.Cause(err) != ErrCorrupt {
+		t.Errorf("Corrupt key should return ErrCorrupt, got %v", err)
+	}
+	corrupt.EncryptedPrivateKey = "zz"
+	if _, err := corrupt.Unlock([]byte("passphrase")); errors.Cause(err) != ErrCorrupt {
+		t.Errorf("Malformed key should return ErrCorrupt, got %v", err)
+	}
+}
+
+func TestKeystore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "accounts")
+	ks, err := Open(dir)
+	if err != nil {
+		t.Fatal("Could not open keystore:", err)
+	}
+	if len(ks.List()) != 0 {
+		t.Error("New keystore should be empty")
+	}
+	accs := []*account.Private{account.NewPrivate(), account.NewPrivate()}
+	for _, acc := range accs {
+		address, err := ks.Save(acc, []byte("passphrase"))
+		if err != nil {
+			t.Fatal("Could not save account:", err)
+		}
+		if address != acc.String() {
+			t.Errorf("Save should return the address %s, got %s", acc.String(), address)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "0x"+hex.EncodeToString(accs[0].Address())+".json")); err != nil {
+		t.Error("Containers should be stored as <address>.json:", err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0644)
+	listed := ks.List()
+	if len(listed) != 2 || !sort.StringsAreSorted(listed) {
+		t.Fatalf("List should return the 2 sorted addresses, got %v", listed)
+	}
+	for _, acc := range accs {
+		c, err := ks.Load(strings.ToUpper(hex.EncodeToString(acc.Address())))
+		if err != nil {
+			t.Fatal("Could not load account:", err)
+		}
+		unlocked, err := c.Unlock([]byte("passphrase"))
+		if err != nil || !bytes.Equal(unlocked.Address(), acc.Address()) {
+			t.Error("Loaded container should unlock the saved account")
+		}
+	}
+	if _, err := ks.Load("../../etc/passwd"); err == nil {
+		t.Error("Load should reject malformed addresses")
+	}
+	if _, err := ks.Load(account.NewPrivate().String()); err == nil {
+		t.Error("Load should fail for unknown addresses")
+	}
+}
+
+func TestWatchOnly(t *testing.T) {
+	acc := account.NewPrivate()
+	ks, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal("Could not open keystore:", err)
+	}
+	address, err := ks.SaveWatchOnly(account.NewPublic(acc.PublicKeyBytes()))
+	if err != nil || address != acc.String() {
+		t.Fatalf("Could not save watch-only account %s: %v", address, err)
+	}
+	c, err := ks.Load(address)
+	if err != nil {
+		t.Fatal("Could not load watch-only account:", err)
+	}
+	if !c.IsWatchOnly() {
+		t.Fatal("Loaded container should be watch-only")
+	}
+	if _, err := c.Unlock([]byte("")); err != ErrWatchOnly {
+		t.Errorf("Unlocking a watch-only container should return ErrWatchOnly, got %v", err)
+	}
+	if _, err := c.ChangePassphrase([]byte(""), []byte("new")); err == nil {
+		t.Error("Watch-only containers should not accept a passphrase")
+	}
+	pub, err := c.Public()
+	if err != nil {
+		t.Fatal("Could not decode public key:", err)
+	}
+	hash := make([]byte, 32)
+	if !bytes.Equal(pub.Address(), acc.Address()) || !pub.Verify(hash, acc.Sign(hash)) {
+		t.Error("Watch-only container should verify the account's signatures")
+	}
+	if full, _ := New([]byte("passphrase"), acc); full.IsWatchOnly() {
+		t.Error("Containers with a private key should not be watch-only")
+	}
+	c.PublicKey = hex.EncodeToString(make([]byte, account.PublicKeySize))
+	if _, err := c.Public(); errors.Cause(err) != ErrCorrupt {
+		t.Errorf("Public keys off the curve should be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package container
+
+import (
+	"encoding/hex"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/lnsp/txledger/ledger/account"
+	"github.com/lnsp/txledger/ledger/storage"
+	"github.com/pkg/errors"
+)
+
+// keystoreExt is the file extension of containers in a keystore.
+const keystoreExt = ".json"
+
+// Keystore manages account containers stored as <address>.json in a directory of a storage.
+type Keystore struct {
+	store storage.Storage
+	dir   string
+}
+
+// Open opens the keystore in the given directory, creating the directory if necessary.
+func Open(dir string) (*Keystore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "Could not create keystore directory")
+	}
+	return OpenStorage(storage.Dir(""), dir), nil
+}
+
+// OpenStorage opens the keystore in the given directory of the storage.
+func OpenStorage(s storage.Storage, dir string) *Keystore {
+	return &Keystore{store: s, dir: dir}
+}
+
+// List returns the checksummed addresses of all stored containers, sorted by address.
+func (k *Keystore) List() []string {
+	names, err := k.store.List(k.dir)
+	if err != nil {
+		return nil
+	}
+	var addresses []string
+	for _, name := range names {
+		if !strings.HasSuffix(name, keystoreExt) {
+			continue
+		}
+		if address, err := account.ParseAddress(strings.TrimSuffix(name, keystoreExt)); err == nil {
+			addresses = append(addresses, account.ChecksumAddress(address))
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return strings.ToLower(addresses[i]) < strings.ToLower(addresses[j])
+	})
+	return addresses
+}
+
+// Load reads the container of the given address.
+func (k *Keystore) Load(address string) (Container, error) {
+	name, err := k.name(address)
+	if err != nil {
+		return Container{}, err
+	}
+	return ReadFromStorage(k.store, name)
+}
+
+// Save seals the private key under the passphrase and stores it, returning its address.
+func (k *Keystore) Save(priv *account.Private, passphrase []byte) (string, error) {
+	c, err := New(passphrase, priv)
+	if err != nil {
+		return "", err
+	}
+	if err := k.Store(priv.String(), c); err != nil {
+		return "", err
+	}
+	return priv.String(), nil
+}
+
+// SaveWatchOnly stores a watch-only container of the account, returning its address.
+func (k *Keystore) SaveWatchOnly(pub account.Account) (string, error) {
+	address := account.ChecksumAddress(pub.Address())
+	if err := k.Store(address, NewWatchOnly(pub)); err != nil {
+		return "", err
+	}
+	return address, nil
+}
+
+// Store writes the container of the given address, replacing an existing one.
+func (k *Keystore) Store(address string, c Container) error {
+	name, err := k.name(address)
+	if err != nil {
+		return err
+	}
+	return WriteToStorage(c, k.store, name)
+}
+
+// name returns the container name of the address. Containers are named by the lower case address.
+func (k *Keystore) name(address string) (string, error) {
+	address, err := normalize(address)
+	if err != nil {
+		return "", err
+	}
+	return path.Join(k.dir, address+keystoreExt), nil
+}
+
+// normalize validates the address and returns it in its 0x-prefixed lower case form.
+func normalize(address string) (string, error) {
+	decoded, err := account.ParseAddress(address)
+	if err != nil {
+		return "", errors.Wrapf(err, "Invalid address %q", address)
+	}
+	return "0x" + hex.EncodeToString(decoded), nil
+}
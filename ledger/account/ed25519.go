@@ -0,0 +1,45 @@
+package account
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// KeyTypeP256 marks ECDSA P-256 keys, which are encoded untagged as (X, Y).
+	KeyTypeP256 byte = 0
+	// KeyTypeEd25519 marks Ed25519 keys, which are encoded as the type byte followed by the key.
+	KeyTypeEd25519 byte = 1
+	// Ed25519PublicKeySize is the width of a tagged Ed25519 public key.
+	Ed25519PublicKeySize = 1 + ed25519.PublicKeySize
+	// Ed25519PrivateKeySize is the width of a tagged Ed25519 seed.
+	Ed25519PrivateKeySize = 1 + ed25519.SeedSize
+)
+
+// KeyTypeOf returns the signature scheme of the encoded public key.
+func KeyTypeOf(key []byte) byte {
+	if len(key) == Ed25519PublicKeySize && key[0] == KeyTypeEd25519 {
+		return KeyTypeEd25519
+	}
+	return KeyTypeP256
+}
+
+// NewPrivateEd25519 generates a new Ed25519 key pair. It panics if no key can be generated.
+func NewPrivateEd25519() *Private {
+	priv, err := NewPrivateEd25519E()
+	if err != nil {
+		panic(err)
+	}
+	return priv
+}
+
+// NewPrivateEd25519E is like NewPrivateEd25519 but returns key generation errors.
+func NewPrivateEd25519E() (*Private, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not generate key")
+	}
+	return &Private{ed: key}, nil
+}
@@ -0,0 +1,127 @@
+package account
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger/hash"
+)
+
+// encryptionPath separates the encryption key derivation from the signing key.
+var encryptionPath = []byte("txledger/encryption/0")
+
+// ErrEncryptionUnsupported is returned for accounts whose keys can not be used for encryption.
+var ErrEncryptionUnsupported = errors.New("Encryption requires a P-256 account")
+
+// encryptionKey returns the account's encryption key. Its scalar is derived from the signing
+// key through HKDF, so the encryption key does not reveal the signing key.
+func (a *Private) encryptionKey() (*ecdh.PrivateKey, error) {
+	if a.destroyed {
+		return nil, ErrDestroyed
+	}
+	if a.key == nil {
+		return nil, ErrEncryptionUnsupported
+	}
+	secret := a.key.D.FillBytes(make([]byte, ScalarSize))
+	defer zero(secret)
+	for counter := byte(0); ; counter++ {
+		info := append(append([]byte{}, encryptionPath...), counter)
+		scalar, err := hkdf.Key(sha256.New, secret, nil, string(info), ScalarSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not derive encryption key")
+		}
+		// Scalars outside of [1, N) are rejected and derived again with the next counter.
+		if key, err := ecdh.P256().NewPrivateKey(scalar); err == nil {
+			return key, nil
+		}
+	}
+}
+
+// EncryptionPublicKey returns the uncompressed public encryption key of the account.
+// It can not be computed from the signing key, the owner hands it to senders of encrypted memos.
+func (a *Private) EncryptionPublicKey() ([]byte, error) {
+	key, err := a.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	return key.PublicKey().Bytes(), nil
+}
+
+// EncryptFor encrypts the plaintext so that only the owner of the encryption key can read it.
+// The key is the recipient's EncryptionPublicKey. The result contains the ephemeral public key,
+// the nonce and the sealed plaintext.
+func EncryptFor(key, plaintext []byte) ([]byte, error) {
+	pub, err := ecdh.P256().NewPublicKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid recipient key")
+	}
+	ephemeral, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not generate ephemeral key")
+	}
+	gcm, err := sharedCipher(ephemeral, pub)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "Could not generate nonce")
+	}
+	sealed := append(ephemeral.PublicKey().Bytes(), nonce...)
+	return gcm.Seal(sealed, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens a ciphertext created by EncryptFor with the account's encryption key.
+func (a *Private) Decrypt(ciphertext []byte) ([]byte, error) {
+	key, err := a.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	keySize := len(key.PublicKey().Bytes())
+	if len(ciphertext) < keySize {
+		return nil, errors.New("Ciphertext too short")
+	}
+	ephemeral, err := ecdh.P256().NewPublicKey(ciphertext[:keySize])
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid ephemeral key")
+	}
+	gcm, err := sharedCipher(key, ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext = ciphertext[keySize:]
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("Ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("Could not decrypt ciphertext")
+	}
+	return plaintext, nil
+}
+
+func sharedCipher(priv *ecdh.PrivateKey, pub *ecdh.PublicKey) (cipher.AEAD, error) {
+	secret, err := priv.ECDH(pub)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not derive shared secret")
+	}
+	hasher := hash.New()
+	hasher.Write(secret)
+	ciph, err := aes.NewCipher(hasher.Sum(nil))
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create ciphersuite")
+	}
+	gcm, err := cipher.NewGCM(ciph)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create GCM")
+	}
+	return gcm, nil
+}
@@ -0,0 +1,91 @@
+package account
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"math/big"
+)
+
+// masterKey is the HMAC key deriving the master key and chain code from a seed.
+var masterKey = []byte("txledger seed")
+
+// chainCodeKey is the HMAC key deriving a chain code for keys not created by NewMaster or Derive.
+var chainCodeKey = []byte("txledger chain code")
+
+// NewMaster derives the master key of a hierarchy of accounts from the seed.
+// The same seed always yields the same master key.
+func NewMaster(seed []byte) *Private {
+	mac := hmac.New(sha512.New, masterKey)
+	mac.Write(seed)
+	I := mac.Sum(nil)
+	N := PrivateKeyCurve.Params().N
+	D := new(big.Int).SetBytes(I[:ScalarSize])
+	D.Mod(D, new(big.Int).Sub(N, big.NewInt(1)))
+	D.Add(D, big.NewInt(1))
+	return newPrivateFromScalar(D, I[ScalarSize:])
+}
+
+// Derive deterministically derives the child key with the given index, like hardened BIP32
+// derivation on P-256: the child scalar is the parent scalar plus an HMAC of the parent
+// scalar and index under the parent's chain code. Children can derive further keys.
+// The chain code is serialized with the key, so restored keys derive the same children.
+// Keys never created by NewMaster or Derive use a chain code derived from their private scalar.
+// Ed25519 children use the HMAC output as their seed instead.
+// It panics if the key has been destroyed, see DeriveE.
+func (a *Private) Derive(index uint32) *Private {
+	child, err := a.DeriveE(index)
+	if err != nil {
+		panic(err)
+	}
+	return child
+}
+
+// DeriveE is like Derive but returns ErrDestroyed if the key has been destroyed.
+func (a *Private) DeriveE(index uint32) (*Private, error) {
+	if a.destroyed {
+		return nil, ErrDestroyed
+	}
+	chainCode := a.chainCode
+	if chainCode == nil {
+		mac := hmac.New(sha512.New, chainCodeKey)
+		mac.Write(a.secret())
+		chainCode = mac.Sum(nil)[:ScalarSize]
+	}
+	N := PrivateKeyCurve.Params().N
+	data := make([]byte, 1+ScalarSize+4)
+	copy(data[1:1+ScalarSize], a.secret())
+	for counter := index; ; counter++ {
+		binary.BigEndian.PutUint32(data[1+ScalarSize:], counter)
+		mac := hmac.New(sha512.New, chainCode)
+		mac.Write(data)
+		I := mac.Sum(nil)
+		if a.ed != nil {
+			return &Private{ed: ed25519.NewKeyFromSeed(I[:ed25519.SeedSize]), chainCode: I[ScalarSize:]}, nil
+		}
+		tweak := new(big.Int).SetBytes(I[:ScalarSize])
+		if tweak.Cmp(N) >= 0 {
+			continue
+		}
+		D := tweak.Add(tweak, a.key.D)
+		D.Mod(D, N)
+		if D.Sign() == 0 {
+			continue
+		}
+		return newPrivateFromScalar(D, I[ScalarSize:]), nil
+	}
+}
+
+// newPrivateFromScalar creates the key pair of the scalar D with the given chain code.
+func newPrivateFromScalar(D *big.Int, chainCode []byte) *Private {
+	X, Y := PrivateKeyCurve.ScalarBaseMult(D.FillBytes(make([]byte, ScalarSize)))
+	return &Private{
+		key: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{Curve: PrivateKeyCurve, X: X, Y: Y},
+			D:         D,
+		},
+		chainCode: append([]byte{}, chainCode...),
+	}
+}
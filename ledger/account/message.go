@@ -0,0 +1,35 @@
+package account
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/lnsp/txledger/ledger/hash"
+)
+
+// messagePrefix separates message signatures from transaction proofs. Transaction hashes
+// start with their version byte and can never share a preimage with a prefixed message.
+var messagePrefix = []byte("txledger signed message:\n")
+
+// MessageHash hashes the message together with the domain-separation prefix and its length.
+func MessageHash(msg []byte) []byte {
+	hasher := hash.New()
+	hasher.Write(messagePrefix)
+	binary.Write(hasher, binary.LittleEndian, uint64(len(msg)))
+	hasher.Write(msg)
+	return hasher.Sum(nil)
+}
+
+// SignMessage signs an arbitrary message to prove control of the account off-chain.
+func SignMessage(priv *Private, msg []byte) []byte {
+	return priv.Sign(MessageHash(msg))
+}
+
+// VerifyMessage checks that the signature of the message was created by the account
+// with the given address.
+func VerifyMessage(addr, msg, sig []byte, pub Account) bool {
+	if !bytes.Equal(pub.Address(), addr) {
+		return false
+	}
+	return pub.Verify(MessageHash(msg), sig)
+}
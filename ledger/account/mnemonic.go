@@ -0,0 +1,128 @@
+package account
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// MnemonicIterations is the number of PBKDF2 rounds used to stretch a mnemonic into a seed.
+	MnemonicIterations = 2048
+	// MnemonicSeedSize is the size of a seed derived from a mnemonic.
+	MnemonicSeedSize = 64
+	// MnemonicEntropyBits is the entropy of generated mnemonics, encoded as 12 words.
+	MnemonicEntropyBits = 128
+)
+
+// NewMnemonic encodes entropyBits of randomness as a BIP39 mnemonic. The entropy must be a
+// multiple of 32 between 128 and 256 bits. A nil reader defaults to crypto/rand.
+func NewMnemonic(entropyBits int, random io.Reader) (string, error) {
+	if entropyBits < 128 || entropyBits > 256 || entropyBits%32 != 0 {
+		return "", errors.Errorf("Invalid mnemonic entropy of %d bits", entropyBits)
+	}
+	if random == nil {
+		random = rand.Reader
+	}
+	entropy := make([]byte, entropyBits/8)
+	if _, err := io.ReadFull(random, entropy); err != nil {
+		return "", errors.Wrap(err, "Could not read entropy")
+	}
+	checksum := sha256.Sum256(entropy)
+	// The checksum contributes entropyBits/32 bits, which always fit into its first byte.
+	bits := new(big.Int).SetBytes(entropy)
+	bits.Lsh(bits, uint(entropyBits/32))
+	bits.Or(bits, big.NewInt(int64(checksum[0]>>(8-entropyBits/32))))
+	words := make([]string, (entropyBits+entropyBits/32)/11)
+	mask := big.NewInt(1<<11 - 1)
+	for i := len(words) - 1; i >= 0; i-- {
+		words[i] = mnemonicWords[new(big.Int).And(bits, mask).Int64()]
+		bits.Rsh(bits, 11)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// SeedFromMnemonic derives a BIP39 seed from the mnemonic and an optional passphrase.
+// Every passphrase yields a valid seed, so a wrong passphrase silently derives a different account.
+// The mnemonic is expected to be NFKD-normalized, which holds for plain ASCII word lists.
+func SeedFromMnemonic(mnemonic, passphrase string) []byte {
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), MnemonicIterations, MnemonicSeedSize, sha512.New)
+}
+
+// NewPrivateFromMnemonic restores the private key derived from the mnemonic and passphrase.
+func NewPrivateFromMnemonic(mnemonic, passphrase string) *Private {
+	return NewPrivateFromSeed(SeedFromMnemonic(mnemonic, passphrase))
+}
+
+// MnemonicChallenge asks for randomly selected words of a mnemonic to confirm it has been written down correctly.
+type MnemonicChallenge struct {
+	words []string
+	// Positions are the zero-based, ascending indices of the requested words.
+	Positions []int
+}
+
+// NewMnemonicChallenge selects count distinct words of the mnemonic using the given source of randomness.
+// A nil reader defaults to crypto/rand.
+func NewMnemonicChallenge(mnemonic string, count int, random io.Reader) (MnemonicChallenge, error) {
+	if random == nil {
+		random = rand.Reader
+	}
+	words := strings.Fields(mnemonic)
+	if count < 1 || count > len(words) {
+		return MnemonicChallenge{}, errors.Errorf("Can not challenge %d of %d words", count, len(words))
+	}
+	chosen := make(map[int]bool, count)
+	positions := make([]int, 0, count)
+	for len(positions) < count {
+		n, err := rand.Int(random, big.NewInt(int64(len(words))))
+		if err != nil {
+			return MnemonicChallenge{}, errors.Wrap(err, "Could not select word")
+		}
+		if position := int(n.Int64()); !chosen[position] {
+			chosen[position] = true
+			positions = append(positions, position)
+		}
+	}
+	sort.Ints(positions)
+	return MnemonicChallenge{words: words, Positions: positions}, nil
+}
+
+// Check returns true if the answers match the requested words in order, ignoring case and surrounding whitespace.
+func (c MnemonicChallenge) Check(answers []string) bool {
+	if len(answers) != len(c.Positions) {
+		return false
+	}
+	for i, position := range c.Positions {
+		if !strings.EqualFold(strings.TrimSpace(answers[i]), c.words[position]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Confirm asks for the requested words until all answers are correct or the attempts are exhausted.
+// The ask function receives the zero-based position of the requested word.
+func (c MnemonicChallenge) Confirm(ask func(position int) (string, error), attempts int) error {
+	for attempt := 0; attempt < attempts; attempt++ {
+		answers := make([]string, len(c.Positions))
+		for i, position := range c.Positions {
+			answer, err := ask(position)
+			if err != nil {
+				return errors.Wrap(err, "Could not read word")
+			}
+			answers[i] = answer
+		}
+		if c.Check(answers) {
+			return nil
+		}
+	}
+	return errors.Errorf("Mnemonic not confirmed after %d attempts", attempts)
+}
@@ -0,0 +1,76 @@
+package account
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// PartialTransfer accumulates signatures of a k-of-n signer set over a transfer hash.
+type PartialTransfer struct {
+	Hash       []byte
+	Required   int
+	Signers    []Account
+	Signatures map[string][]byte
+}
+
+// NewPartialTransfer creates an unsigned partial transfer requiring k of the given signers.
+func NewPartialTransfer(hash []byte, required int, signers []Account) (*PartialTransfer, error) {
+	if required < 1 || required > len(signers) {
+		return nil, errors.Errorf("Required signatures must be between 1 and %d", len(signers))
+	}
+	return &PartialTransfer{
+		Hash:       hash,
+		Required:   required,
+		Signers:    signers,
+		Signatures: make(map[string][]byte),
+	}, nil
+}
+
+// Sign adds a signature created by the given private key.
+func (p *PartialTransfer) Sign(priv *Private) error {
+	return p.AddSignature(priv.Address(), priv.Sign(p.Hash))
+}
+
+// AddSignature adds a signature of the signer with the given address after verifying it.
+func (p *PartialTransfer) AddSignature(address, signature []byte) error {
+	signer := p.signer(address)
+	if signer == nil {
+		return errors.New("Address is not an authorized signer")
+	}
+	if !signer.Verify(p.Hash, signature) {
+		return errors.New("Invalid signature")
+	}
+	p.Signatures[string(address)] = signature
+	return nil
+}
+
+// Signed returns the number of valid signatures collected so far.
+func (p *PartialTransfer) Signed() int {
+	return len(p.Signatures)
+}
+
+// Missing returns the addresses of the signers that have not signed yet.
+func (p *PartialTransfer) Missing() [][]byte {
+	missing := [][]byte{}
+	for _, signer := range p.Signers {
+		if _, ok := p.Signatures[string(signer.Address())]; !ok {
+			missing = append(missing, signer.Address())
+		}
+	}
+	return missing
+}
+
+// Ready returns true if at least the required amount of signatures is present.
+func (p *PartialTransfer) Ready() bool {
+	return p.Signed() >= p.Required
+}
+
+func (p *PartialTransfer) signer(address []byte) Account {
+	for _, signer := range p.Signers {
+		if bytes.Equal(signer.Address(), address) {
+			return signer
+		}
+	}
+	return nil
+}
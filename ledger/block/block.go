@@ -9,7 +9,8 @@ import (
 	"math"
 	"math/bits"
 	"runtime"
-	"time"
+	"sync"
+	"sync/atomic"
 
 	"github.com/google/btree"
 	"github.com/pkg/errors"
@@ -26,8 +27,98 @@ const (
 	RewardBase        uint64 = 2 << 4
 	HashSize                 = 32
 	VarianceChunkSize        = 2 << 16
+	MaxBlockBytes            = 1 << 20
+	MaxTxPerBlock            = 1 << 12
+	// HalvingInterval is the number of blocks after which the subsidy halves.
+	HalvingInterval uint64 = 1 << 18
 )
 
+// Verification errors. Verify and SuccessorOf wrap them with details, use errors.Is to match them.
+var (
+	ErrNotCompliant      = errors.New("Block is not compliant")
+	ErrEmptyBlock        = errors.New("Block is empty")
+	ErrNoCoinbase        = errors.New("Block does not begin with coinbase")
+	ErrMisplacedCoinbase = errors.New("Coinbase is misplaced")
+	ErrNotSuccessor      = errors.New("Block not successor")
+	ErrNotGenesis        = errors.New("Block is not a genesis block")
+	ErrInsufficientFunds = errors.New("Insufficient funds")
+)
+
+// Now returns the current time as a unix timestamp. It is used for block timestamps and
+// the future drift check, and follows transaction.Now unless replaced.
+var Now = func() uint64 {
+	return transaction.Now()
+}
+
+// chainParamsMu guards the per-chain parameter maps. Writing the maps directly is only safe
+// before any ledger is in use, SetChainParams may be called at any time.
+var chainParamsMu sync.RWMutex
+
+// ChainRewardBases holds the subsidy per unit of hash quality for chains deviating from RewardBase.
+var ChainRewardBases = map[uint64]uint64{}
+
+// SetChainParams sets the block interval, reward base and, unless zero, the halving interval
+// of the given chain.
+func SetChainParams(chain, targetInterval, rewardBase, halvingInterval uint64) {
+	chainParamsMu.Lock()
+	defer chainParamsMu.Unlock()
+	ChainTargetIntervals[chain] = targetInterval
+	ChainRewardBases[chain] = rewardBase
+	if halvingInterval != 0 {
+		ChainHalvingIntervals[chain] = halvingInterval
+	}
+}
+
+// RewardBaseFor returns the subsidy per unit of hash quality on the given chain.
+func RewardBaseFor(chain uint64) uint64 {
+	chainParamsMu.RLock()
+	defer chainParamsMu.RUnlock()
+	if base, ok := ChainRewardBases[chain]; ok {
+		return base
+	}
+	return RewardBase
+}
+
+// ChainHalvingIntervals holds the halving interval for chains deviating from HalvingInterval.
+// An interval of zero disables halving.
+var ChainHalvingIntervals = map[uint64]uint64{}
+
+// HalvingIntervalFor returns the number of blocks after which the subsidy halves on the given chain.
+func HalvingIntervalFor(chain uint64) uint64 {
+	chainParamsMu.RLock()
+	defer chainParamsMu.RUnlock()
+	if interval, ok := ChainHalvingIntervals[chain]; ok {
+		return interval
+	}
+	return HalvingInterval
+}
+
+// Subsidy returns the subsidy per unit of hash quality of the block at the given index.
+// The reward base halves every halving interval until it reaches zero.
+func Subsidy(chain, index uint64) uint64 {
+	interval := HalvingIntervalFor(chain)
+	if interval == 0 {
+		return RewardBaseFor(chain)
+	}
+	halvings := index / interval
+	if halvings >= 64 {
+		return 0
+	}
+	return RewardBaseFor(chain) >> halvings
+}
+
+// ChainMaxTxPerBlock holds the maximum number of transactions per block for chains
+// deviating from MaxTxPerBlock.
+var ChainMaxTxPerBlock = map[uint64]int{}
+
+// MaxTxPerBlockFor returns the maximum number of transactions per block on the given chain.
+func MaxTxPerBlockFor(chain uint64) int {
+	if limit, ok := ChainMaxTxPerBlock[chain]; ok {
+		return limit
+	}
+	return MaxTxPerBlock
+}
+
 type Block struct {
 	Chain        uint64
 	Index        uint64
@@ -42,48 +133,109 @@ func (b Block) String() string {
 	return fmt.Sprintf("Block [chain = %d; index = %d; fingerprint = %s; quality = %d]", b.Chain, b.Index, b.Fingerprint(), HashQuality(b.Complexity))
 }
 
-func (b Block) SetBytesFrom(source io.Reader) Block {
+// SetBytesFrom decodes the block from the reader, stopping at the first field that can not be read.
+func (b Block) SetBytesFrom(source io.Reader) (Block, error) {
 	var dataSize, txSize uint64
-	binary.Read(source, binary.LittleEndian, &b.Chain)
-	binary.Read(source, binary.LittleEndian, &b.Index)
-	binary.Read(source, binary.LittleEndian, &b.Complexity)
-	binary.Read(source, binary.LittleEndian, &b.Timestamp)
-	binary.Read(source, binary.LittleEndian, &b.Variance)
-	binary.Read(source, binary.LittleEndian, &dataSize)
+	fields := []struct {
+		name  string
+		value interface{}
+	}{
+		{"chain", &b.Chain},
+		{"index", &b.Index},
+		{"complexity", &b.Complexity},
+		{"timestamp", &b.Timestamp},
+		{"variance", &b.Variance},
+		{"transaction count", &dataSize},
+	}
+	for _, field := range fields {
+		if err := binary.Read(source, binary.LittleEndian, field.value); err != nil {
+			return b, errors.Wrapf(err, "Could not read %s", field.name)
+		}
+	}
 
+	b.PreviousHash = make([]byte, HashSize)
+	if _, err := io.ReadFull(source, b.PreviousHash); err != nil {
+		return b, errors.Wrap(err, "Could not read previous hash")
+	}
+	// Bound the declared sizes by the block size limit and, where known, the remaining input
+	// before allocating anything.
+	remaining, cause := uint64(MaxBlockBytes), errors.Errorf("Block exceeds %d bytes", MaxBlockBytes)
+	if sized, ok := source.(interface{ Len() int }); ok && uint64(sized.Len()) < remaining {
+		remaining, cause = uint64(sized.Len()), io.ErrUnexpectedEOF
+	}
+	if dataSize > remaining/8 {
+		return b, errors.Wrapf(cause, "Block declares %d transactions, input holds at most %d", dataSize, remaining/8)
+	}
+	remaining -= dataSize * 8
 	b.Data = make([]transaction.TX, dataSize)
-	source.Read(b.PreviousHash)
+	var err error
 	for i := range b.Data {
-		binary.Read(source, binary.LittleEndian, &txSize)
+		if err := binary.Read(source, binary.LittleEndian, &txSize); err != nil {
+			return b, errors.Wrapf(err, "Could not read size of TX %d", i)
+		}
+		if txSize > remaining {
+			return b, errors.Wrapf(cause, "TX %d declares %d bytes, input holds at most %d", i, txSize, remaining)
+		}
+		remaining -= txSize
 		txBytes := make([]byte, txSize)
-		source.Read(txBytes)
-		b.Data[i] = transaction.New().SetBytes(txBytes)
+		if _, err := io.ReadFull(source, txBytes); err != nil {
+			return b, errors.Wrapf(err, "Could not read TX %d", i)
+		}
+		if b.Data[i], err = transaction.Decode(b.Chain, txBytes); err != nil {
+			return b, errors.Wrapf(err, "Could not decode TX %d", i)
+		}
 	}
-	return b
+	return b, nil
 }
 
 func (b Block) Bytes() []byte {
 	buffer := bytes.NewBuffer([]byte{})
-	binary.Write(buffer, binary.LittleEndian, b.Chain)
-	binary.Write(buffer, binary.LittleEndian, b.Index)
-	binary.Write(buffer, binary.LittleEndian, b.Complexity)
-	binary.Write(buffer, binary.LittleEndian, b.Timestamp)
-	binary.Write(buffer, binary.LittleEndian, b.Variance)
-	binary.Write(buffer, binary.LittleEndian, uint64(len(b.Data)))
-
-	buffer.Write(b.PreviousHash)
+	b.WriteTo(buffer)
+	return buffer.Bytes()
+}
+
+// WriteTo streams the binary encoding of the block to w, one transaction at a time.
+// It returns the number of bytes written and the first write error.
+func (b Block) WriteTo(w io.Writer) (int64, error) {
+	header := make([]byte, 6*8, 6*8+len(b.PreviousHash))
+	for i, field := range []uint64{b.Chain, b.Index, b.Complexity, b.Timestamp, b.Variance, uint64(len(b.Data))} {
+		binary.LittleEndian.PutUint64(header[8*i:], field)
+	}
+	header = append(header, b.PreviousHash...)
+	n, err := w.Write(header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
 	for _, tx := range b.Data {
 		txBytes := tx.Bytes()
-		txSize := uint64(len(txBytes))
-		binary.Write(buffer, binary.LittleEndian, txSize)
-		buffer.Write(txBytes)
+		encoded := make([]byte, 8, 8+len(txBytes))
+		binary.LittleEndian.PutUint64(encoded, uint64(len(txBytes)))
+		n, err := w.Write(append(encoded, txBytes...))
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
 	}
-	return buffer.Bytes()
+	return written, nil
+}
+
+// Size returns the length of the serialized block in bytes.
+func (b Block) Size() uint64 {
+	return uint64(len(b.Bytes()))
+}
+
+// Fits returns true if the transaction can be appended without exceeding the block limits.
+func (b Block) Fits(tx transaction.TX) bool {
+	if len(b.Data)+1 > MaxTxPerBlockFor(b.Chain) {
+		return false
+	}
+	return b.Size()+8+tx.Size() <= MaxBlockBytes
 }
 
 func (b Block) SetBytes(data []byte) Block {
 	buffer := bytes.NewBuffer(data)
-	b.SetBytesFrom(buffer)
+	b, _ = b.SetBytesFrom(buffer)
 	return b
 }
 
@@ -96,10 +248,8 @@ func (b Block) Hash() []byte {
 	binary.Write(hasher, binary.LittleEndian, b.Variance)
 
 	hasher.Write(b.PreviousHash)
-	for _, tx := range b.Data {
-		hasher.Write(tx.Hash())
-	}
-	return hasher.Sum()
+	hasher.Write(b.MerkleRoot())
+	return hasher.Sum(nil)
 }
 
 func (b Block) HashString() string {
@@ -111,23 +261,44 @@ func (b Block) Fingerprint() string {
 }
 
 func (b Block) Verify(fallback *btree.BTree) (*btree.BTree, error) {
+	if limit := MaxTxPerBlockFor(b.Chain); len(b.Data) > limit {
+		return fallback, errors.Errorf("Block has %d transactions, limit is %d on chain %d", len(b.Data), limit, b.Chain)
+	}
+	if size := b.Size(); size > MaxBlockBytes {
+		return fallback, errors.Errorf("Block has %d bytes, limit is %d", size, MaxBlockBytes)
+	}
 	if !b.Compliant() {
-		return fallback, errors.New("Block is not compliant")
+		return fallback, ErrNotCompliant
 	}
 	if len(b.Data) < 1 {
-		return fallback, errors.New("Block is empty")
+		return fallback, ErrEmptyBlock
+	}
+	if err := b.checkCoinbase(); err != nil {
+		return fallback, err
+	}
+	if err := b.checkOutflows(fallback); err != nil {
+		return fallback, err
 	}
 	tree := fallback.Clone()
-	reward := BlockReward(b.Complexity, b.Data)
+	reward, err := BlockReward(b.Chain, b.Index, b.Complexity, b.Data)
+	if err != nil {
+		return fallback, err
+	}
+	seen := make(map[string]int, len(b.Data))
 	for i, tx := range b.Data {
-		if (tx.Type == transaction.TypeCoinbase && i != 0) || (tx.Type != transaction.TypeCoinbase && i == 0) {
-			return fallback, errors.New("TX %d does not begin with coinbase")
+		if tx.Chain != b.Chain {
+			return fallback, errors.Errorf("TX %d belongs to chain %d instead of %d", i, tx.Chain, b.Chain)
 		}
-		if !tx.VerifyFees(reward, b.Complexity) {
-			return fallback, errors.Errorf("TX %d does not use valid fees", i)
+		hash := string(tx.Hash())
+		if j, ok := seen[hash]; ok {
+			return fallback, errors.Errorf("TX %d duplicates TX %d", i, j)
 		}
-		if !tx.VerifyProof(tree) {
-			return fallback, errors.Errorf("TX %d does not have a valid proof", i)
+		seen[hash] = i
+		if tx.Version < transaction.MinVersion(b.Chain) || tx.Version > transaction.CurrentVersion {
+			return fallback, errors.Errorf("TX %d uses unsupported version %d", i, tx.Version)
+		}
+		if err := tx.Validate(tree, reward, b.Complexity); err != nil {
+			return fallback, errors.Wrapf(err, "TX %d is invalid", i)
 		}
 		if !tx.Apply(tree) {
 			return fallback, errors.Errorf("TX %d can not be applied", i)
@@ -136,22 +307,87 @@ func (b Block) Verify(fallback *btree.BTree) (*btree.BTree, error) {
 	return tree, nil
 }
 
+// checkCoinbase ensures that the block holds exactly one coinbase, at index 0.
+func (b Block) checkCoinbase() error {
+	if _, ok := b.Coinbase(); !ok {
+		return fmt.Errorf("%w: TX 0 has type %d", ErrNoCoinbase, b.Data[0].Type)
+	}
+	for i, tx := range b.Data[1:] {
+		if tx.Type == transaction.TypeCoinbase {
+			return fmt.Errorf("%w: TX %d is a coinbase, only TX 0 may be one", ErrMisplacedCoinbase, i+1)
+		}
+	}
+	return nil
+}
+
+// checkOutflows ensures that no sender spends more than it owns plus what it receives within the block.
+func (b Block) checkOutflows(addresses *btree.BTree) error {
+	inflows := map[string]uint64{}
+	outflows := map[string]uint64{}
+	receive := func(recipient []byte, amount uint64) error {
+		received, ok := transaction.AddAmounts(inflows[string(recipient)], amount)
+		if !ok {
+			return errors.Errorf("Recipient %s receives more than representable within block", hex.EncodeToString(recipient))
+		}
+		inflows[string(recipient)] = received
+		return nil
+	}
+	for _, tx := range b.Data {
+		switch tx.Type {
+		case transaction.TypeCoinbase:
+			if err := receive(tx.Recipient, tx.Amount); err != nil {
+				return err
+			}
+		case transaction.TypeTransfer, transaction.TypeBurn:
+			if tx.Type == transaction.TypeTransfer {
+				if err := receive(tx.Recipient, tx.Amount); err != nil {
+					return err
+				}
+			}
+			sender := string(tx.Sender)
+			spent, ok := transaction.AddAmounts(outflows[sender], tx.Amount, tx.Fee)
+			if !ok {
+				return fmt.Errorf("%w: Sender %s over-spends within block", ErrInsufficientFunds, hex.EncodeToString(tx.Sender))
+			}
+			outflows[sender] = spent
+		}
+	}
+	for sender, spent := range outflows {
+		available := inflows[sender]
+		if item := addresses.Get(account.AddressTreeItem{Address: []byte(sender)}); item != nil {
+			if funds, ok := transaction.AddAmounts(available, item.(account.AddressTreeItem).Funds); ok {
+				available = funds
+			} else {
+				available = math.MaxUint64
+			}
+		}
+		if spent > available {
+			return fmt.Errorf("%w: Sender %s over-spends within block", ErrInsufficientFunds, hex.EncodeToString([]byte(sender)))
+		}
+	}
+	return nil
+}
+
 // SuccessorOf returns true if this block is the direct successor of the given block.
+// The complexity depends on the chain history and is checked against ExpectedComplexity instead.
 func (b Block) SuccessorOf(prev Block) error {
+	return b.SuccessorOfHeader(prev, prev.Hash())
+}
+
+// SuccessorOfHeader is like SuccessorOf, but takes the hash of prev instead of computing it.
+// This allows checking the successor of a pruned block whose transactions are gone.
+func (b Block) SuccessorOfHeader(prev Block, prevHash []byte) error {
 	if b.Chain != prev.Chain {
-		return errors.New("Chain ID should match")
+		return fmt.Errorf("%w: Chain ID should match", ErrNotSuccessor)
 	}
 	if b.Index != prev.Index+1 {
-		return errors.New("Index should be larger than of prev block")
-	}
-	if b.Complexity != prev.Complexity+1 {
-		return errors.New("Complexity should be larger than of prev block")
+		return fmt.Errorf("%w: Index should be larger than of prev block", ErrNotSuccessor)
 	}
 	if b.Timestamp < prev.Timestamp {
-		return errors.New("Timestamp should be newer than prev block")
+		return fmt.Errorf("%w: Timestamp should be newer than prev block", ErrNotSuccessor)
 	}
-	if !bytes.Equal(b.PreviousHash, prev.Hash()) {
-		return errors.New("Prev hash should be equal to hash")
+	if !bytes.Equal(b.PreviousHash, prevHash) {
+		return fmt.Errorf("%w: Prev hash should be equal to hash", ErrNotSuccessor)
 	}
 	return nil
 }
@@ -173,6 +409,40 @@ func (b Block) Compliant() bool {
 	return false
 }
 
+// Coinbase returns the leading coinbase transaction and whether the block has one.
+func (b Block) Coinbase() (transaction.TX, bool) {
+	if len(b.Data) < 1 || b.Data[0].Type != transaction.TypeCoinbase {
+		return transaction.TX{}, false
+	}
+	return b.Data[0], true
+}
+
+// CollectedFees sums up the fees of all transfers and burns in the block.
+func (b Block) CollectedFees() (uint64, error) {
+	var sum uint64
+	for i, tx := range b.Data {
+		if tx.Type != transaction.TypeTransfer && tx.Type != transaction.TypeBurn {
+			continue
+		}
+		var ok bool
+		if sum, ok = transaction.AddAmounts(sum, tx.Fee); !ok {
+			return 0, errors.Errorf("Fees overflow at TX %d", i)
+		}
+	}
+	return sum, nil
+}
+
+// Clone creates a deep copy of the block including its transactions.
+func (b Block) Clone() Block {
+	b.PreviousHash = append([]byte(nil), b.PreviousHash...)
+	data := make([]transaction.TX, len(b.Data))
+	for i, tx := range b.Data {
+		data[i] = tx.Clone()
+	}
+	b.Data = data
+	return b
+}
+
 func (b Block) Append(tx transaction.TX) Block {
 	b.Data = append(b.Data, tx)
 	return b
@@ -182,40 +452,65 @@ func HashQuality(complexity uint64) uint64 {
 	return uint64(math.Sqrt(float64(complexity) / BlockEpoch))
 }
 
-func BlockReward(complexity uint64, transactions []transaction.TX) uint64 {
-	var sum uint64
-	if transactions != nil {
-		for _, tx := range transactions {
-			if tx.Type != transaction.TypeTransfer {
-				continue
-			}
-			sum += tx.Fee
-		}
+// BlockReward returns the collected fees plus the subsidy for the given complexity of the block
+// at the given index on the chain.
+func BlockReward(chain, index, complexity uint64, transactions []transaction.TX) (uint64, error) {
+	fees, err := Block{Data: transactions}.CollectedFees()
+	if err != nil {
+		return 0, err
+	}
+	reward, ok := transaction.AddAmounts(fees, HashQuality(complexity)*Subsidy(chain, index))
+	if !ok {
+		return 0, errors.New("Block reward overflows")
 	}
-	return sum + HashQuality(complexity)*RewardBase
+	return reward, nil
 }
 
 func Genesis(chain, complexity uint64, creator *account.Private) Block {
+	reward, _ := BlockReward(chain, 0, complexity, nil)
 	data := []transaction.TX{
-		transaction.NewCoinbase(chain, creator, BlockReward(complexity, nil)),
+		transaction.NewCoinbase(chain, creator, reward),
 	}
 	return Block{
 		Chain:        chain,
 		Index:        0,
 		Complexity:   complexity,
-		Timestamp:    uint64(time.Now().Unix()),
+		Timestamp:    Now(),
 		Variance:     0,
 		PreviousHash: make([]byte, HashSize),
 		Data:         data,
 	}
 }
 
+// IsGenesis checks that the block is structurally a genesis block: it has index 0, an all-zero
+// previous hash and a single coinbase.
+func IsGenesis(b Block) error {
+	if b.Index != 0 {
+		return fmt.Errorf("%w: Index should be 0, got %d", ErrNotGenesis, b.Index)
+	}
+	if !bytes.Equal(b.PreviousHash, make([]byte, HashSize)) {
+		return fmt.Errorf("%w: Previous hash should be zero", ErrNotGenesis)
+	}
+	if len(b.Data) != 1 || b.Data[0].Type != transaction.TypeCoinbase {
+		return fmt.Errorf("%w: Block should only hold a coinbase", ErrNotGenesis)
+	}
+	return nil
+}
+
+// Next creates the successor of prev with the complexity ExpectedComplexity yields for it.
+//
+// Deprecated: A single block is not enough history to retarget, use NextWithHistory.
 func Next(prev Block) Block {
+	return successor(prev, ExpectedComplexity([]Block{prev}))
+}
+
+// successor creates an empty block following prev with the given complexity.
+func successor(prev Block, complexity uint64) Block {
 	return Block{
 		Chain:        prev.Chain,
 		Index:        prev.Index + 1,
-		Complexity:   prev.Complexity + 1,
-		Timestamp:    uint64(time.Now().Unix()),
+		Complexity:   complexity,
+		Timestamp:    Now(),
 		Variance:     0,
 		PreviousHash: prev.Hash(),
 		Data:         []transaction.TX{},
@@ -234,47 +529,84 @@ func New() Block {
 	}
 }
 
-func runVarianceWorker(init Block, chunks <-chan [2]uint64, sols chan<- uint64, quit <-chan bool) {
+// VarianceLimit bounds the variance values tried per timestamp. Once all values below the limit
+// have been handed out without a solution, the block timestamp is bumped and the search restarts.
+var VarianceLimit uint64 = math.MaxUint64
+
+type varianceChunk struct {
+	timestamp, from, to uint64
+}
+
+func runVarianceWorker(init Block, chunks <-chan varianceChunk, sols chan<- [2]uint64, quit <-chan bool, attempts *uint64, report func(uint64)) {
 	for {
 		select {
 		case <-quit:
 			return
 		case c := <-chunks:
-			for v := c[0]; v < c[1]; v++ {
+			init.Timestamp = c.timestamp
+			for v := c.from; v < c.to; v++ {
 				init.Variance = v
 				if !init.Compliant() {
 					continue
 				}
 				select {
-				case sols <- v:
+				case sols <- [2]uint64{c.timestamp, v}:
 				default:
 				}
 			}
+			total := atomic.AddUint64(attempts, c.to-c.from)
+			if report != nil {
+				report(total)
+			}
 		}
 	}
 }
 
+// Find solves the proof of work for the block using one worker per CPU.
 func Find(init Block) Block {
-	chunks := make(chan [2]uint64)
-	sols := make(chan uint64, 1)
+	return FindWithWorkers(init, runtime.NumCPU())
+}
+
+// FindWithWorkers solves the proof of work for the block using the given amount of workers.
+// If less than one worker is requested, one worker per CPU is used.
+func FindWithWorkers(init Block, workers int) Block {
+	return FindWithProgress(init, workers, nil)
+}
+
+// FindWithProgress solves the proof of work like FindWithWorkers and periodically reports
+// the cumulative number of variance values tried across all workers.
+// The report callback may be invoked concurrently from multiple workers.
+func FindWithProgress(init Block, workers int, report func(attempts uint64)) Block {
+	chunks := make(chan varianceChunk)
+	sols := make(chan [2]uint64, 1)
 	quit := make(chan bool)
-	procs := runtime.NumCPU()
+	procs := workers
+	if procs < 1 {
+		procs = runtime.NumCPU()
+	}
+	var attempts uint64
 	for i := 0; i < procs; i++ {
-		go runVarianceWorker(init, chunks, sols, quit)
+		go runVarianceWorker(init, chunks, sols, quit, &attempts, report)
 	}
 	var (
-		varianceChunk uint64
-		variance      uint64
+		next     = varianceChunk{timestamp: init.Timestamp}
+		solution [2]uint64
 	)
+	next.to = nextVarianceBound(next.from)
 varianceLoop:
 	for {
 		select {
-		case variance = <-sols:
+		case solution = <-sols:
 			break varianceLoop
-		case chunks <- [2]uint64{
-			varianceChunk,
-			varianceChunk + VarianceChunkSize}:
-			varianceChunk += VarianceChunkSize
+		case chunks <- next:
+			if next.to >= VarianceLimit {
+				// Variance space exhausted for this timestamp, restart with a newer one
+				next.timestamp++
+				next.from = 0
+			} else {
+				next.from = next.to
+			}
+			next.to = nextVarianceBound(next.from)
 		}
 	}
 	for i := 0; i < procs; i++ {
@@ -283,6 +615,16 @@ varianceLoop:
 	close(chunks)
 	close(sols)
 	close(quit)
-	init.Variance = variance
+	init.Timestamp = solution[0]
+	init.Variance = solution[1]
 	return init
 }
+
+// nextVarianceBound returns the exclusive upper bound of the chunk starting at from,
+// clamped to the variance limit without overflowing.
+func nextVarianceBound(from uint64) uint64 {
+	if VarianceLimit-from < VarianceChunkSize {
+		return VarianceLimit
+	}
+	return from + VarianceChunkSize
+}
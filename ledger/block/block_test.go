@@ -2,11 +2,20 @@ package block
 
 import (
 	"bytes"
-	"fmt"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
 	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/lnsp/txledger/ledger/account"
+	"github.com/lnsp/txledger/ledger/transaction"
 )
 
 func TestBlock(t *testing.T) {
@@ -15,12 +24,615 @@ func TestBlock(t *testing.T) {
 	g2 := New().SetBytes(g.Bytes())
 
 	if !reflect.DeepEqual(g, g2) {
-		fmt.Println("Bytes not reversible")
+		t.Error("Bytes not reversible")
 	}
 
 	buf := bytes.NewBuffer(g.Bytes())
-	g2 = New().SetBytesFrom(buf)
-	if !reflect.DeepEqual(g, g2) {
-		fmt.Println("BytesFrom not reversible")
+	g2, err := New().SetBytesFrom(buf)
+	if err != nil || !reflect.DeepEqual(g, g2) {
+		t.Error("BytesFrom not reversible:", err)
+	}
+
+	n := NextWithHistory([]Block{g})
+	n = n.Append(transaction.NewCoinbase(0, p, 0))
+	n2, err := Block{}.SetBytesFrom(bytes.NewBuffer(n.Bytes()))
+	if err != nil || !reflect.DeepEqual(n.PreviousHash, n2.PreviousHash) {
+		t.Error("BytesFrom should restore the previous hash:", err)
+	}
+
+	data := n.Bytes()
+	for _, cut := range []int{4, 44, 60, len(data) - 1} {
+		if _, err := New().SetBytesFrom(bytes.NewBuffer(data[:cut])); err == nil {
+			t.Errorf("BytesFrom should fail on block truncated to %d bytes", cut)
+		}
+	}
+}
+
+// legacyBlock is a block holding a coinbase, an account announcement and a transfer on chain 7,
+// encoded by the code predating transaction versions.
+const legacyBlock = "" +
+	"070000000000000001000000000000000000000000000000010000000000000000000000000000000300000000000000" +
+	"0000000000000000000000000000000000000000000000000000000000000000e8000000000000000700000000000000" +
+	"0000000000000000e8030000000000000000000000000000c6a0d16a0000000000000000000000000000000000000000" +
+	"0000000000000000000000000000000041005ad33c4ef391e131ca6110784d1c0a806118b280a57ec2f2bbc1cd8be910" +
+	"6438097d240aaeedc73b0277598d094406457aa30c926b639f9bc7011b1c6195e46788fc37b08dd804029f84b32bb758" +
+	"6ee47bd8645f1d2770ab761913b249703eeec084680029b620b35007f406f85ff57b5fd8214d379f14484802960d57d4" +
+	"e03bb70cc210ac648a426064b5d5c3a2bda3079e51d40fadc2eddb718828cbf0e8000000000000000700000000000000" +
+	"010000000000000000000000000000000000000000000000c6a0d16a0000000049fffd73456f420eb0904192269489f9" +
+	"06b9f172cfe9f211ead5ea86205b57f60000000000000000000000000000000000000000000000000000000000000000" +
+	"c028531bea1ed0b6193718d0482458791fa7ddff4263164541f4c7665e7138f7348b4920d5638b9041a5aa6c1893326a" +
+	"2d8e83ac8c988cb11c8b678317fe02a3f5cdb5bcb9b6a39810735acee1f2e8ddc36fb775a96c3bc0f5976ae3563eecdd" +
+	"232b8f76d84086054521612bcf233b326d38c66fc300dfdf8bab22698296464da8000000000000000700000000000000" +
+	"02000000000000000a000000000000005802000000000000c6a0d16a0000000041005ad33c4ef391e131ca6110784d1c" +
+	"0a806118b280a57ec2f2bbc1cd8be91049fffd73456f420eb0904192269489f906b9f172cfe9f211ead5ea86205b57f6" +
+	"0e429283493e9859836260f846a1e239a3bf52e21cc6c0a18db6a88c2d1b0b15e5f061cfc1eb0d34b78f19a8690e90cb" +
+	"e31f3a708bd529bb8b4b59ee1c584166"
+
+func TestLegacyTransactions(t *testing.T) {
+	encoded, _ := hex.DecodeString(legacyBlock)
+	b, err := New().SetBytesFrom(bytes.NewReader(encoded))
+	if err != nil || len(b.Data) != 3 {
+		t.Fatalf("Legacy block should decode, got %d transactions (%v)", len(b.Data), err)
+	}
+	for i, tx := range b.Data {
+		if tx.Version != transaction.VersionLegacy || tx.Chain != 7 || tx.Type != uint64(i) {
+			t.Errorf("TX %d should decode as legacy transaction of type %d, got version %d type %d", i, i, tx.Version, tx.Type)
+		}
+	}
+	if !bytes.Equal(b.Bytes(), encoded) {
+		t.Error("Legacy transactions should be re-encoded in their original layout")
+	}
+	if hash := hex.EncodeToString(b.Data[2].Hash()); hash != "b142f1b98da68bef97abfcb641aedbc1d6d0683f71715ff40061a3670f49b6d6" {
+		t.Error("Legacy transfer hash should match the original hash, got", hash)
+	}
+	tree := account.NewAddressTree()
+	for _, tx := range b.Data {
+		if !tx.VerifyProof(tree) {
+			t.Errorf("Proof of legacy %s should verify", tx)
+		}
+		if tx.Type != transaction.TypeTransfer && !tx.Apply(tree) {
+			t.Errorf("Legacy %s should apply", tx)
+		}
+	}
+}
+
+func TestBlockDeclaredSizes(t *testing.T) {
+	p := account.NewPrivate()
+	n := NextWithHistory([]Block{Genesis(0, 0, p)}).Append(transaction.NewCoinbase(0, p, 0))
+
+	data := n.Bytes()
+	binary.LittleEndian.PutUint64(data[40:], 4e9)
+	for _, source := range []io.Reader{bytes.NewReader(data), io.MultiReader(bytes.NewReader(data))} {
+		if _, err := New().SetBytesFrom(source); err == nil {
+			t.Error("BytesFrom should reject a block declaring billions of transactions")
+		}
+	}
+
+	data = n.Bytes()
+	binary.LittleEndian.PutUint64(data[48+HashSize:], 1<<40)
+	if _, err := New().SetBytesFrom(io.MultiReader(bytes.NewReader(data))); err == nil {
+		t.Error("BytesFrom should reject a transaction exceeding the block size")
+	}
+}
+
+func TestCoinbase(t *testing.T) {
+	p := account.NewPrivate()
+	g := Genesis(0, 0, p)
+	coinbase, ok := g.Coinbase()
+	if !ok {
+		t.Fatal("Genesis block should have a coinbase")
+	}
+	if !reflect.DeepEqual(coinbase, g.Data[0]) {
+		t.Error("Coinbase should return the leading transaction")
+	}
+
+	empty := NextWithHistory([]Block{g})
+	if _, ok := empty.Coinbase(); ok {
+		t.Error("Empty block should not have a coinbase")
+	}
+	transfer := transaction.NewTransfer(0, 10, 7, 1, p, p)
+	noCoinbase := empty.Append(transfer)
+	if _, ok := noCoinbase.Coinbase(); ok {
+		t.Error("Block starting with a transfer should not have a coinbase")
+	}
+	if _, err := Find(noCoinbase).Verify(account.NewAddressTree()); !errors.Is(err, ErrNoCoinbase) || !strings.Contains(err.Error(), "TX 0") {
+		t.Error("Block without leading coinbase should not verify, got", err)
+	}
+	tree := account.NewAddressTree()
+	tree.ReplaceOrInsert(account.AddressTreeItem{Address: p.Address(), Account: p, Funds: 1 << 20})
+	paid := transaction.NewTransfer(0, 600, transaction.EstimateFee(0, 0), 1, p, p)
+	misplaced := empty.Append(transaction.NewCoinbase(0, p, 0)).Append(paid).Append(transaction.NewCoinbase(0, account.NewPrivate(), 0))
+	if _, err := Find(misplaced).Verify(tree); !errors.Is(err, ErrMisplacedCoinbase) || !strings.Contains(err.Error(), "TX 2") {
+		t.Error("Block with misplaced coinbase should not verify, got", err)
+	}
+
+	withFees := empty.Append(transaction.NewCoinbase(0, p, 0)).Append(transfer).Append(transfer)
+	if fees, err := withFees.CollectedFees(); err != nil || fees != 14 {
+		t.Errorf("CollectedFees should be 14, got %d (%v)", fees, err)
+	}
+	if reward, err := BlockReward(withFees.Chain, withFees.Index, withFees.Complexity, withFees.Data); err != nil || reward != 14+HashQuality(withFees.Complexity)*RewardBase {
+		t.Errorf("BlockReward should include collected fees, got %d (%v)", reward, err)
+	}
+}
+
+func TestCompressedCoinbase(t *testing.T) {
+	miner := account.NewPrivate()
+	coinbase := transaction.NewCoinbase(0, miner, 0)
+	if len(coinbase.Data) != account.CompressedPublicKeySize {
+		t.Fatalf("Coinbase should carry a %d byte compressed key, got %d bytes", account.CompressedPublicKeySize, len(coinbase.Data))
+	}
+	tree := account.NewAddressTree()
+	for i := 0; i < 2; i++ {
+		next, err := Find(New().Append(transaction.NewCoinbase(0, miner, 0))).Verify(tree)
+		if err != nil {
+			t.Fatalf("Block %d with compressed coinbase should verify: %v", i, err)
+		}
+		tree = next
+	}
+	known := account.NewAddressTree()
+	known.ReplaceOrInsert(account.AddressTreeItem{Address: miner.Address(), Account: account.NewPublic(miner.PublicKeyBytes())})
+	if _, err := Find(New().Append(coinbase)).Verify(known); err != nil {
+		t.Error("Compressed coinbase should apply to an account known by its uncompressed key:", err)
+	}
+}
+
+func TestIsGenesis(t *testing.T) {
+	g := Find(Genesis(0, 0, account.NewPrivate()))
+	if err := IsGenesis(g); err != nil {
+		t.Fatal("Genesis block should be recognized:", err)
+	}
+	tampered := g
+	tampered.PreviousHash = append([]byte{1}, g.PreviousHash[1:]...)
+	if err := IsGenesis(tampered); !errors.Is(err, ErrNotGenesis) {
+		t.Error("Genesis with nonzero previous hash should be rejected, got", err)
+	}
+	if err := IsGenesis(NextWithHistory([]Block{g}).Append(g.Data[0])); !errors.Is(err, ErrNotGenesis) {
+		t.Error("Successor block should be rejected, got", err)
+	}
+}
+
+func TestSingleCoinbase(t *testing.T) {
+	miner := account.NewPrivate()
+	tree := account.NewAddressTree()
+	tree.ReplaceOrInsert(account.AddressTreeItem{Address: miner.Address(), Account: miner, Funds: 100})
+	b := Find(New().Append(transaction.NewCoinbase(0, miner, 0)).Append(transaction.NewCoinbase(0, account.NewPrivate(), 0)))
+	result, err := b.Verify(tree)
+	if !errors.Is(err, ErrMisplacedCoinbase) || !strings.Contains(err.Error(), "TX 1") {
+		t.Error("Block with two coinbases should not verify, got", err)
+	}
+	if result != tree || tree.Len() != 1 {
+		t.Error("Rejected block should return the untouched fallback tree")
+	}
+	if item := tree.Get(account.AddressTreeItem{Address: miner.Address()}); item.(account.AddressTreeItem).Funds != 100 {
+		t.Error("Rejected block should not credit funds")
+	}
+}
+
+func TestFeeOverflow(t *testing.T) {
+	miner, alice, bob := account.NewPrivate(), account.NewPrivate(), account.NewPrivate()
+	tree := account.NewAddressTree()
+	for _, acc := range []*account.Private{alice, bob} {
+		tree.ReplaceOrInsert(account.AddressTreeItem{Address: acc.Address(), Account: acc, Funds: math.MaxUint64 / 2})
+	}
+	fee := uint64(math.MaxUint64/2 - 1)
+	txs := []transaction.TX{
+		transaction.NewTransfer(0, 1, fee, 1, alice, bob),
+		transaction.NewTransfer(0, 1, fee, 1, bob, alice),
+		transaction.NewTransfer(0, 1, 4, 1, miner, alice),
+	}
+	if _, err := BlockReward(0, 0, 0, txs[:2]); err != nil {
+		t.Error("BlockReward should not fail below the maximum:", err)
+	}
+	if _, err := BlockReward(0, 0, 0, txs); err == nil {
+		t.Error("BlockReward should fail when fees sum past the maximum")
+	}
+
+	b := NextWithHistory([]Block{Genesis(0, 0, miner)})
+	b.Data = append([]transaction.TX{transaction.NewCoinbase(0, miner, math.MaxUint64)}, txs...)
+	if _, err := b.Verify(tree); err == nil {
+		t.Error("Block with fees summing past the maximum should not verify")
+	}
+
+	rich := account.NewAddressTree()
+	rich.ReplaceOrInsert(account.AddressTreeItem{Address: miner.Address(), Account: miner, Funds: math.MaxUint64 - 1})
+	if transaction.NewCoinbase(0, miner, 2).Apply(rich) {
+		t.Error("Coinbase overflowing the miner's funds should not apply")
+	}
+	if funds := rich.Get(account.AddressTreeItem{Address: miner.Address()}).(account.AddressTreeItem).Funds; funds != math.MaxUint64-1 {
+		t.Errorf("Failed coinbase should leave funds untouched, got %d", funds)
+	}
+}
+
+func TestFindWithWorkers(t *testing.T) {
+	g := Genesis(0, 16, account.NewPrivate())
+	for _, workers := range []int{0, 1, 3} {
+		if b := FindWithWorkers(g, workers); !b.Compliant() {
+			t.Errorf("FindWithWorkers(%d) should yield a compliant block", workers)
+		}
+	}
+}
+
+func TestOverspend(t *testing.T) {
+	sender, recipient := account.NewPrivate(), account.NewPrivate()
+	tree := account.NewAddressTree()
+	tree.ReplaceOrInsert(account.AddressTreeItem{
+		Address: sender.Address(),
+		Account: account.NewPublic(sender.PublicKeyBytes()),
+		Funds:   2000,
+	})
+	tree.ReplaceOrInsert(account.AddressTreeItem{
+		Address: recipient.Address(),
+		Account: account.NewPublic(recipient.PublicKeyBytes()),
+		Funds:   0,
+	})
+	fee := transaction.EstimateFee(0, 0)
+	b := New().Append(transaction.NewCoinbase(0, recipient, 0))
+	b = b.Append(transaction.NewTransfer(0, 600, fee, 1, sender, recipient))
+	b = b.Append(transaction.NewTransfer(0, 600, fee, 2, sender, recipient))
+	result, err := Find(b).Verify(tree)
+	if err == nil || !strings.Contains(err.Error(), "over-spends") || !errors.Is(err, ErrInsufficientFunds) {
+		t.Fatal("Verify should reject over-spending sender, got", err)
+	}
+	if result != tree {
+		t.Error("Verify should return the fallback tree on rejection")
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	miner, sender := account.NewPrivate(), account.NewPrivate()
+	tree := account.NewAddressTree()
+	tree.ReplaceOrInsert(account.AddressTreeItem{Address: sender.Address(), Account: sender, Funds: 1 << 20})
+	fee := transaction.EstimateFee(0, 0)
+	coinbase := transaction.NewCoinbase(0, miner, 0)
+
+	hard := New().Append(coinbase)
+	hard.Complexity = 1 << 20
+	for hard.Compliant() {
+		hard.Variance++
+	}
+	forged := transaction.NewTransfer(0, 600, fee, 1, sender, miner)
+	forged.Amount++
+	cases := []struct {
+		name  string
+		block Block
+		err   error
+	}{
+		{"not compliant", hard, ErrNotCompliant},
+		{"empty", Find(New()), ErrEmptyBlock},
+		{"no coinbase", Find(New().Append(transaction.NewTransfer(0, 600, fee, 1, sender, miner))), ErrNoCoinbase},
+		{"bad fee", Find(New().Append(coinbase).Append(transaction.NewTransfer(0, 600, fee-1, 1, sender, miner))), transaction.ErrBadFee},
+		{"bad proof", Find(New().Append(coinbase).Append(forged)), transaction.ErrBadProof},
+		{"insufficient funds", Find(New().Append(coinbase).Append(transaction.NewTransfer(0, 1<<21, fee, 1, sender, miner))), ErrInsufficientFunds},
+	}
+	for _, c := range cases {
+		if _, err := c.block.Verify(tree); !errors.Is(err, c.err) {
+			t.Errorf("%s: Verify should fail with %v, got %v", c.name, c.err, err)
+		}
+	}
+
+	g := Genesis(0, 0, miner)
+	next := NextWithHistory([]Block{g})
+	next.Index += 1
+	if err := next.SuccessorOf(g); !errors.Is(err, ErrNotSuccessor) {
+		t.Error("SuccessorOf should fail with ErrNotSuccessor, got", err)
+	}
+}
+
+func TestDuplicateTx(t *testing.T) {
+	miner, sender := account.NewPrivate(), account.NewPrivate()
+	tree := account.NewAddressTree()
+	tree.ReplaceOrInsert(account.AddressTreeItem{Address: sender.Address(), Account: sender, Funds: 1 << 20})
+	transfer := transaction.NewTransfer(0, 600, transaction.EstimateFee(0, 0), 1, sender, miner)
+	b := New().Append(transaction.NewCoinbase(0, miner, 0)).Append(transfer)
+	if _, err := Find(b).Verify(tree); err != nil {
+		t.Fatal("Block with a single transfer should verify:", err)
+	}
+	if _, err := Find(b.Append(transfer)).Verify(tree); err == nil || !strings.Contains(err.Error(), "TX 2 duplicates TX 1") {
+		t.Error("Verify should reject duplicate transactions, got", err)
+	}
+}
+
+func TestFindWithProgress(t *testing.T) {
+	g := Genesis(0, 16*16*16, account.NewPrivate())
+	var last uint64
+	b := FindWithProgress(g, 2, func(attempts uint64) {
+		for {
+			prev := atomic.LoadUint64(&last)
+			if attempts <= prev || atomic.CompareAndSwapUint64(&last, prev, attempts) {
+				return
+			}
+		}
+	})
+	if !b.Compliant() {
+		t.Error("FindWithProgress should yield a compliant block")
+	}
+	if reported := atomic.LoadUint64(&last); reported%VarianceChunkSize != 0 {
+		t.Errorf("Reported attempts should be a multiple of the chunk size, got %d", reported)
+	}
+}
+
+func TestFindVarianceExhaustion(t *testing.T) {
+	prev := VarianceLimit
+	VarianceLimit = 1
+	defer func() { VarianceLimit = prev }()
+
+	g := Genesis(0, 16*16, account.NewPrivate())
+	for g.Compliant() {
+		g.Timestamp++
+	}
+	b := FindWithWorkers(g, 2)
+	if !b.Compliant() {
+		t.Error("Find should yield a compliant block after exhausting the variance space")
+	}
+	if b.Variance != 0 {
+		t.Errorf("Find should not exceed the variance limit, got %d", b.Variance)
+	}
+	if b.Timestamp <= g.Timestamp {
+		t.Error("Find should bump the timestamp once the variance space is exhausted")
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	p := account.NewPrivate()
+	for size := 1; size <= 7; size++ {
+		b := New()
+		for i := 0; i < size; i++ {
+			b = b.Append(transaction.NewAccount(uint64(i), p))
+		}
+		root := b.MerkleRoot()
+		for i, tx := range b.Data {
+			path, err := b.MerkleProof(i)
+			if err != nil {
+				t.Fatal("MerkleProof should not fail:", err)
+			}
+			if !VerifyMerkleProof(root, tx.Hash(), path, i) {
+				t.Errorf("Proof for transaction %d of %d should verify", i, size)
+			}
+			if size > 1 && VerifyMerkleProof(root, tx.Hash(), path, (i+1)%size) {
+				t.Errorf("Proof for transaction %d of %d should not verify at another index", i, size)
+			}
+		}
+		if _, err := b.MerkleProof(size); err == nil {
+			t.Error("MerkleProof should reject out of range index")
+		}
+	}
+	g := Genesis(0, 0, p)
+	before := g.Hash()
+	g.Data[0].Amount++
+	if bytes.Equal(before, g.Hash()) {
+		t.Error("Block hash should cover the transactions via the Merkle root")
+	}
+}
+
+func TestRetargetComplexity(t *testing.T) {
+	history := func(start, interval uint64) []Block {
+		blocks := make([]Block, RetargetWindow+1)
+		for i := range blocks {
+			blocks[i] = Block{Index: uint64(i), Complexity: start, Timestamp: 1000 + uint64(i)*interval}
+		}
+		return blocks
+	}
+	if c := RetargetComplexity(history(100, 60), 60); c != 100 {
+		t.Errorf("Complexity should stay at 100 on target, got %d", c)
+	}
+	if c := RetargetComplexity(history(100, 30), 60); c != 114 {
+		t.Errorf("Complexity should rise damped for fast blocks, got %d", c)
+	}
+	if c := RetargetComplexity(history(100, 1), 60); c != 132 {
+		t.Errorf("Complexity should rise damped for very fast blocks, got %d", c)
+	}
+	slow := history(100, 120)
+	if c := RetargetComplexity(slow, 60); c != 100 {
+		t.Errorf("Complexity should not drop below genesis, got %d", c)
+	}
+	slow[0].Complexity = 10
+	if c := RetargetComplexity(slow, 60); c != 80 {
+		t.Errorf("Complexity should drop damped for slow blocks, got %d", c)
+	}
+	if c := ExpectedComplexity(slow[:3]); c != 101 {
+		t.Errorf("ExpectedComplexity should increment without enough history, got %d", c)
+	}
+
+	ChainRetargetParams[7] = RetargetParams{Window: RetargetWindow, Damping: 1, MaxAdjustment: 4}
+	defer delete(ChainRetargetParams, 7)
+	for _, interval := range []uint64{1, 1000} {
+		clamped := history(100, interval)
+		for i := range clamped {
+			clamped[i].Chain = 7
+		}
+		clamped[0].Complexity = 10
+		if c := RetargetComplexity(clamped, 60); c != 400 && c != 25 {
+			t.Errorf("Complexity should change at most 4x per step, got %d", c)
+		}
+	}
+}
+
+// simulateChain extends the chain by n blocks, assuming that mining a block takes
+// time proportional to its complexity divided by the hashrate returned for each index.
+func simulateChain(blocks []Block, n int, hashrate func(i int) float64) []Block {
+	for i := 0; i < n; i++ {
+		last := blocks[len(blocks)-1]
+		next := Block{Chain: last.Chain, Index: last.Index + 1, Complexity: ExpectedComplexity(blocks)}
+		interval := uint64(float64(next.Complexity) / hashrate(len(blocks)))
+		if interval < 1 {
+			interval = 1
+		}
+		next.Timestamp = last.Timestamp + interval
+		blocks = append(blocks, next)
+	}
+	return blocks
+}
+
+func complexityRange(blocks []Block) (min, max uint64) {
+	min, max = math.MaxUint64, 0
+	for _, b := range blocks {
+		if b.Complexity < min {
+			min = b.Complexity
+		}
+		if b.Complexity > max {
+			max = b.Complexity
+		}
+	}
+	return min, max
+}
+
+func TestRetargetSmoothing(t *testing.T) {
+	// Alternating fast and slow blocks around a hashrate that balances at complexity 6000.
+	alternating := func(i int) float64 {
+		if i%2 == 0 {
+			return 200
+		}
+		return 200.0 / 3
+	}
+	blocks := simulateChain([]Block{{Complexity: 1}}, 400, alternating)
+	min, max := complexityRange(blocks[len(blocks)-100:])
+	if min < 5700 || max > 6300 {
+		t.Errorf("Complexity should settle around 6000 without oscillating, got range [%d, %d]", min, max)
+	}
+
+	// A sudden hashrate drop by a factor of 100 must not freeze the chain.
+	dropped := simulateChain(blocks, 100, func(int) float64 { return 1 })
+	for i := len(blocks); i < len(dropped); i++ {
+		if prev, c := dropped[i-1].Complexity, dropped[i].Complexity; c < prev/4 || c > prev*4 {
+			t.Fatalf("Complexity should change at most 4x per block, got %d after %d", c, prev)
+		}
+	}
+	min, max = complexityRange(dropped[len(dropped)-20:])
+	if min < 50 || max > 70 {
+		t.Errorf("Complexity should recover after a hashrate drop, got range [%d, %d]", min, max)
+	}
+	elapsed := dropped[len(dropped)-1].Timestamp - dropped[len(blocks)-1].Timestamp
+	if elapsed > 100*TargetInterval*20 {
+		t.Errorf("Chain should keep producing blocks after a hashrate drop, took %ds", elapsed)
+	}
+}
+
+func TestBlockLimits(t *testing.T) {
+	p := account.NewPrivate()
+	coinbase := transaction.NewCoinbase(0, p, 0)
+	crowded := New()
+	for i := 0; i <= MaxTxPerBlock; i++ {
+		crowded = crowded.Append(coinbase)
+	}
+	if _, err := crowded.Verify(account.NewAddressTree()); err == nil || !strings.Contains(err.Error(), "transactions") {
+		t.Error("Verify should reject blocks with too many transactions, got", err)
+	}
+	large := transaction.NewAccount(0, p)
+	large.Data = make([]byte, MaxBlockBytes)
+	oversized := New().Append(coinbase).Append(large)
+	if _, err := oversized.Verify(account.NewAddressTree()); err == nil || !strings.Contains(err.Error(), "bytes") {
+		t.Error("Verify should reject oversized blocks, got", err)
+	}
+	if New().Append(coinbase).Fits(large) {
+		t.Error("Fits should reject transactions exceeding the size budget")
+	}
+	if !New().Append(coinbase).Fits(coinbase) {
+		t.Error("Fits should accept small transactions")
+	}
+}
+
+func TestMaxTxPerBlockFor(t *testing.T) {
+	ChainMaxTxPerBlock[5] = 3
+	defer delete(ChainMaxTxPerBlock, 5)
+	if MaxTxPerBlockFor(0) != MaxTxPerBlock || MaxTxPerBlockFor(5) != 3 {
+		t.Fatal("MaxTxPerBlockFor should respect per-chain overrides")
+	}
+	p := account.NewPrivate()
+	full := Genesis(5, 0, p).Append(transaction.NewAccount(5, account.NewPrivate())).Append(transaction.NewAccount(5, account.NewPrivate()))
+	if _, err := Find(full).Verify(account.NewAddressTree()); err != nil {
+		t.Error("Block at the transaction limit should verify, got", err)
+	}
+	if full.Fits(transaction.NewAccount(5, p)) {
+		t.Error("Fits should respect the chain's transaction limit")
+	}
+	crowded := full.Append(transaction.NewAccount(5, account.NewPrivate()))
+	if _, err := Find(crowded).Verify(account.NewAddressTree()); err == nil || !strings.Contains(err.Error(), "4 transactions, limit is 3") {
+		t.Error("Block exceeding the transaction limit should be rejected, got", err)
+	}
+}
+
+func TestCheckTimestamp(t *testing.T) {
+	now := uint64(time.Now().Unix())
+	history := make([]Block, 15)
+	for i := range history {
+		history[i] = Block{Index: uint64(i), Timestamp: now - 100 + uint64(i)}
+	}
+	if median := MedianTimePast(history); median != now-100+9 {
+		t.Errorf("MedianTimePast should consider the last %d blocks, got %d", MedianTimeSpan, now-median)
+	}
+	if err := CheckTimestamp(Block{Timestamp: now - 100 + 9}, history); err == nil {
+		t.Error("CheckTimestamp should reject timestamps not exceeding the median")
+	}
+	if err := CheckTimestamp(Block{Timestamp: now}, history); err != nil {
+		t.Error("CheckTimestamp should accept current timestamps:", err)
+	}
+	prev := MaxTimeDrift
+	MaxTimeDrift = time.Minute
+	defer func() { MaxTimeDrift = prev }()
+	if err := CheckTimestamp(Block{Timestamp: now + 120}, history); err == nil {
+		t.Error("CheckTimestamp should reject timestamps beyond the allowed drift")
+	}
+	if next := NextWithHistory(append(history, Block{Timestamp: now + 60})); next.Timestamp <= MedianTimePast(history) {
+		t.Error("NextWithHistory should move the timestamp past the median")
+	}
+}
+
+func TestBlockJSON(t *testing.T) {
+	p := account.NewPrivate()
+	g := Genesis(3, 16, p).Append(transaction.NewTransfer(3, 10, 600, 1, p, p))
+	g.Variance = 42
+	encoded, err := json.Marshal(g)
+	if err != nil {
+		t.Fatal("Marshal should not fail:", err)
+	}
+	if !strings.Contains(string(encoded), `"type":"transfer"`) {
+		t.Error("Transaction type should be encoded as a readable string")
+	}
+	var decoded Block
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal("Unmarshal should not fail:", err)
+	}
+	if !bytes.Equal(g.Hash(), decoded.Hash()) {
+		t.Error("JSON round trip should preserve the block hash")
+	}
+	if !reflect.DeepEqual(g, decoded) {
+		t.Error("JSON round trip should preserve every field")
+	}
+}
+
+func TestRecommendFee(t *testing.T) {
+	if fee := RecommendFee(nil); fee != transaction.EstimateFee(0, 0) {
+		t.Errorf("RecommendFee should fall back to the default minimum fee, got %d", fee)
+	}
+	p := account.NewPrivate()
+	history := []Block{Genesis(0, 16, p)}
+	minimum := transaction.EstimateFee(0, ExpectedComplexity(history))
+	if fee := RecommendFee(history); fee != minimum {
+		t.Errorf("RecommendFee should return the minimum fee without transfers, got %d", fee)
+	}
+	next := NextWithHistory(history).Append(transaction.NewCoinbase(0, p, 0))
+	for i, fee := range []uint64{minimum, 5 * minimum, 3 * minimum} {
+		next = next.Append(transaction.NewTransfer(0, 1, fee, uint64(i+1), p, p))
+	}
+	if fee := RecommendFee(append(history, next)); fee != 3*minimum {
+		t.Errorf("RecommendFee should return the median fee %d, got %d", 3*minimum, fee)
+	}
+}
+
+func TestNow(t *testing.T) {
+	prev := transaction.Now
+	defer func() { transaction.Now = prev }()
+	transaction.Now = func() uint64 { return 1500000000 }
+
+	p := account.NewPrivate()
+	g := Genesis(0, 0, p)
+	if g.Timestamp != 1500000000 || g.Data[0].Timestamp != 1500000000 {
+		t.Errorf("Genesis should use the injected clock, got %d", g.Timestamp)
+	}
+	if next := successor(g, g.Complexity); next.Timestamp != 1500000000 {
+		t.Errorf("Successor should use the injected clock, got %d", next.Timestamp)
+	}
+	if err := CheckTimestamp(Block{Timestamp: 1500000000 + uint64(MaxTimeDrift/time.Second) + 1}, nil); err == nil {
+		t.Error("CheckTimestamp should measure the drift against the injected clock")
 	}
 }
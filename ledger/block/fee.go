@@ -0,0 +1,41 @@
+package block
+
+import (
+	"sort"
+
+	"github.com/lnsp/txledger/ledger/transaction"
+)
+
+// FeeWindow is the number of most recent blocks sampled by RecommendFee.
+const FeeWindow = 16
+
+// RecommendFee suggests a competitive fee for a transfer without data following the given history.
+// It returns the median fee paid by transfers in the last FeeWindow blocks, but never less than
+// the minimum fee required of the next block under the chain's fee schedule. Without any history
+// it falls back to the minimum fee of the default schedule.
+func RecommendFee(history []Block) uint64 {
+	if len(history) < 1 {
+		return transaction.EstimateFee(0, 0)
+	}
+	minimum := transaction.FeeScheduleFor(history[0].Chain).Estimate(0, ExpectedComplexity(history))
+	recent := history
+	if len(recent) > FeeWindow {
+		recent = recent[len(recent)-FeeWindow:]
+	}
+	var fees []uint64
+	for _, b := range recent {
+		for _, tx := range b.Data {
+			if tx.Type == transaction.TypeTransfer {
+				fees = append(fees, tx.Fee)
+			}
+		}
+	}
+	if len(fees) == 0 {
+		return minimum
+	}
+	sort.Slice(fees, func(i, j int) bool { return fees[i] < fees[j] })
+	if median := fees[len(fees)/2]; median > minimum {
+		return median
+	}
+	return minimum
+}
@@ -0,0 +1,58 @@
+package block
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger/transaction"
+)
+
+type blockJSON struct {
+	Chain        uint64           `json:"chain"`
+	Index        uint64           `json:"index"`
+	Complexity   uint64           `json:"complexity"`
+	Timestamp    uint64           `json:"timestamp"`
+	Variance     uint64           `json:"variance"`
+	PreviousHash string           `json:"previousHash"`
+	Data         []transaction.TX `json:"transactions"`
+}
+
+// MarshalJSON encodes the block with a hex-encoded previous hash.
+func (b Block) MarshalJSON() ([]byte, error) {
+	return json.Marshal(blockJSON{
+		Chain:        b.Chain,
+		Index:        b.Index,
+		Complexity:   b.Complexity,
+		Timestamp:    b.Timestamp,
+		Variance:     b.Variance,
+		PreviousHash: hex.EncodeToString(b.PreviousHash),
+		Data:         b.Data,
+	})
+}
+
+// UnmarshalJSON decodes a block encoded by MarshalJSON.
+func (b *Block) UnmarshalJSON(data []byte) error {
+	var decoded blockJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	previousHash, err := hex.DecodeString(decoded.PreviousHash)
+	if err != nil {
+		return errors.Wrap(err, "Invalid previous hash")
+	}
+	if decoded.Data == nil {
+		decoded.Data = []transaction.TX{}
+	}
+	*b = Block{
+		Chain:        decoded.Chain,
+		Index:        decoded.Index,
+		Complexity:   decoded.Complexity,
+		Timestamp:    decoded.Timestamp,
+		Variance:     decoded.Variance,
+		PreviousHash: previousHash,
+		Data:         decoded.Data,
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package block
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger/hash"
+)
+
+// MerkleRoot computes the root of a binary Merkle tree over the transaction hashes.
+// Odd levels duplicate their last node. An empty block has an all-zero root.
+func (b Block) MerkleRoot() []byte {
+	level := b.merkleLeaves()
+	if len(level) == 0 {
+		return make([]byte, HashSize)
+	}
+	for len(level) > 1 {
+		level = merkleLevel(level)
+	}
+	return level[0]
+}
+
+// MerkleProof returns the sibling path proving the inclusion of transaction i.
+func (b Block) MerkleProof(i int) ([][]byte, error) {
+	if i < 0 || i >= len(b.Data) {
+		return nil, errors.Errorf("Transaction %d does not exist", i)
+	}
+	path := [][]byte{}
+	level := b.merkleLeaves()
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		path = append(path, level[i^1])
+		level = merkleLevel(level)
+		i /= 2
+	}
+	return path, nil
+}
+
+// VerifyMerkleProof checks that the leaf at the given index is included in the tree with the given root.
+func VerifyMerkleProof(root, leaf []byte, path [][]byte, index int) bool {
+	if index < 0 {
+		return false
+	}
+	node := leaf
+	for _, sibling := range path {
+		if index%2 == 0 {
+			node = merkleNode(node, sibling)
+		} else {
+			node = merkleNode(sibling, node)
+		}
+		index /= 2
+	}
+	return index == 0 && bytes.Equal(node, root)
+}
+
+func (b Block) merkleLeaves() [][]byte {
+	leaves := make([][]byte, len(b.Data))
+	for i, tx := range b.Data {
+		leaves[i] = tx.Hash()
+	}
+	return leaves
+}
+
+func merkleLevel(level [][]byte) [][]byte {
+	if len(level)%2 == 1 {
+		level = append(level, level[len(level)-1])
+	}
+	next := make([][]byte, len(level)/2)
+	for i := range next {
+		next[i] = merkleNode(level[2*i], level[2*i+1])
+	}
+	return next
+}
+
+func merkleNode(left, right []byte) []byte {
+	hasher := hash.New()
+	hasher.Write(left)
+	hasher.Write(right)
+	return hasher.Sum(nil)
+}
@@ -0,0 +1,193 @@
+package block
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// RetargetWindow is the number of block intervals considered when retargeting complexity.
+	RetargetWindow = 16
+	// MedianTimeSpan is the number of blocks considered for the median time past.
+	MedianTimeSpan = 11
+)
+
+var (
+	// TargetInterval is the desired number of seconds between two blocks.
+	TargetInterval uint64 = 60
+	// MaxTimeDrift is how far a block timestamp may lie ahead of the verifier's clock.
+	MaxTimeDrift = 2 * time.Hour
+)
+
+// ChainTargetIntervals holds the block interval per chain for chains deviating from TargetInterval.
+var ChainTargetIntervals = map[uint64]uint64{}
+
+// TargetIntervalFor returns the desired number of seconds between two blocks on the given chain.
+func TargetIntervalFor(chain uint64) uint64 {
+	chainParamsMu.RLock()
+	defer chainParamsMu.RUnlock()
+	if interval, ok := ChainTargetIntervals[chain]; ok {
+		return interval
+	}
+	return TargetInterval
+}
+
+// MedianTimePast returns the median timestamp of the last MedianTimeSpan blocks.
+func MedianTimePast(history []Block) uint64 {
+	if len(history) > MedianTimeSpan {
+		history = history[len(history)-MedianTimeSpan:]
+	}
+	timestamps := make([]uint64, len(history))
+	for i, b := range history {
+		timestamps[i] = b.Timestamp
+	}
+	if len(timestamps) == 0 {
+		return 0
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+// CheckTimestamp verifies that the block timestamp is not too far in the future
+// and strictly exceeds the median time past of the given history.
+func CheckTimestamp(b Block, history []Block) error {
+	limit := Now() + uint64(MaxTimeDrift/time.Second)
+	if b.Timestamp > limit {
+		return errors.Errorf("Timestamp %d is too far in the future", b.Timestamp)
+	}
+	if len(history) > 0 {
+		if median := MedianTimePast(history); b.Timestamp <= median {
+			return errors.Errorf("Timestamp %d should be newer than median time past %d", b.Timestamp, median)
+		}
+	}
+	return nil
+}
+
+// RetargetParams controls how the complexity adapts to the observed block interval.
+type RetargetParams struct {
+	// Window is the number of block intervals averaged per retarget.
+	Window int
+	// Damping divides the deviation of the observed timespan from the target timespan.
+	Damping uint64
+	// MaxAdjustment bounds the factor by which the complexity may change in one step.
+	MaxAdjustment uint64
+}
+
+// DefaultRetargetParams are used on chains without an entry in ChainRetargetParams.
+var DefaultRetargetParams = RetargetParams{Window: RetargetWindow, Damping: 4, MaxAdjustment: 4}
+
+// ChainRetargetParams holds the retarget parameters per chain.
+var ChainRetargetParams = map[uint64]RetargetParams{}
+
+// RetargetParamsFor returns the retarget parameters used on the given chain.
+func RetargetParamsFor(chain uint64) RetargetParams {
+	if params, ok := ChainRetargetParams[chain]; ok {
+		return params
+	}
+	return DefaultRetargetParams
+}
+
+// RetargetComplexity computes the complexity of the block following the given chain history
+// so that block production converges on the target interval. The average complexity of the
+// last window is scaled by the ratio of expected to observed timespan, where the observed
+// timespan is damped towards the expected one and clamped by the maximum adjustment factor.
+// The result never drops below the complexity of the first block in the history.
+func RetargetComplexity(blocks []Block, targetInterval uint64) uint64 {
+	if len(blocks) < 1 {
+		return 0
+	}
+	last := blocks[len(blocks)-1]
+	if len(blocks) < 2 {
+		return last.Complexity + 1
+	}
+	params := RetargetParamsFor(last.Chain)
+	window := blocks
+	if params.Window > 0 && len(window) > params.Window+1 {
+		window = window[len(window)-params.Window-1:]
+	}
+	intervals := uint64(len(window) - 1)
+	expected := targetInterval * intervals
+	if expected < 1 {
+		expected = 1
+	}
+	actual := uint64(1)
+	if last.Timestamp > window[0].Timestamp {
+		actual = last.Timestamp - window[0].Timestamp
+	}
+	damping := params.Damping
+	if damping < 1 {
+		damping = 1
+	}
+	if actual > expected {
+		actual = expected + (actual-expected)/damping
+	} else {
+		actual = expected - (expected-actual)/damping
+	}
+	if adjustment := params.MaxAdjustment; adjustment > 0 {
+		if lower := expected / adjustment; actual < lower {
+			actual = lower
+		}
+		if expected <= math.MaxUint64/adjustment && actual > expected*adjustment {
+			actual = expected * adjustment
+		}
+	}
+	if actual < 1 {
+		actual = 1
+	}
+	complexity := mulDiv(averageComplexity(window[1:]), expected, actual)
+	if floor := blocks[0].Complexity; complexity < floor {
+		complexity = floor
+	}
+	return complexity
+}
+
+// ExpectedComplexity returns the complexity required of the block following the given history.
+// Until enough history exists the complexity grows by one per block.
+func ExpectedComplexity(history []Block) uint64 {
+	if len(history) < 1 {
+		return 0
+	}
+	last := history[len(history)-1]
+	if len(history) <= RetargetParamsFor(last.Chain).Window {
+		return last.Complexity + 1
+	}
+	return RetargetComplexity(history, TargetIntervalFor(last.Chain))
+}
+
+// NextWithHistory creates the successor of the last block in the history using the expected complexity.
+// The timestamp is moved past the median time past if the clock has not advanced far enough.
+func NextWithHistory(history []Block) Block {
+	next := successor(history[len(history)-1], ExpectedComplexity(history))
+	if median := MedianTimePast(history); next.Timestamp <= median {
+		next.Timestamp = median + 1
+	}
+	return next
+}
+
+// mulDiv computes a*b/c without intermediate overflow, saturating at the maximum value.
+func mulDiv(a, b, c uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	if hi >= c {
+		return math.MaxUint64
+	}
+	quo, _ := bits.Div64(hi, lo, c)
+	return quo
+}
+
+// averageComplexity returns the mean complexity of the given blocks without intermediate overflow.
+func averageComplexity(blocks []Block) uint64 {
+	var hi, lo, carry uint64
+	for _, b := range blocks {
+		lo, carry = bits.Add64(lo, b.Complexity, 0)
+		hi += carry
+	}
+	if len(blocks) == 0 {
+		return 0
+	}
+	quo, _ := bits.Div64(hi, lo, uint64(len(blocks)))
+	return quo
+}
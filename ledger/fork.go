@@ -0,0 +1,223 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger/block"
+	"github.com/lnsp/txledger/ledger/transaction"
+)
+
+var (
+	// MaxForks is the maximum number of fork blocks kept as candidates.
+	MaxForks = 256
+	// ForkDepth is how many blocks below the tip fork blocks are kept before being evicted.
+	ForkDepth uint64 = 64
+)
+
+// TryAppend appends the block if it extends the tip. Otherwise a block extending an earlier
+// block of the chain or a known fork is kept as a fork candidate until Reorg switches to it.
+// Fork blocks are only checked against their parent history, their transactions are verified
+// by Reorg. Forks more than ForkDepth blocks below the tip are evicted and at most MaxForks
+// fork blocks are kept.
+func (l *Ledger) TryAppend(b block.Block) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if last, ok := l.last(); !ok || b.Index == l.size() && bytes.Equal(b.PreviousHash, last.Hash()) {
+		if err := l.appendBlock(b); err != nil {
+			return err
+		}
+		l.evictForks()
+		return nil
+	}
+	l.evictForks()
+	hash := string(b.Hash())
+	if _, ok := l.forks[hash]; ok {
+		return errors.Wrap(ErrStaleBlock, "Block already known as fork")
+	}
+	if b.Index < l.size() && bytes.Equal(b.Hash(), l.blockHash(b.Index)) {
+		return errors.Wrap(ErrStaleBlock, "Block already in chain")
+	}
+	if b.Index+ForkDepth < l.size() {
+		return errors.Wrapf(ErrStaleBlock, "Fork block %d is too far below the tip", b.Index)
+	}
+	parent, ok := l.parent(b)
+	if !ok {
+		return errors.Errorf("Parent %s of block %d is unknown", hex.EncodeToString(b.PreviousHash), b.Index)
+	}
+	if err := b.SuccessorOfHeader(parent, b.PreviousHash); err != nil {
+		return err
+	}
+	history, ok := l.history(b)
+	if !ok {
+		return errors.Errorf("Fork of block %d does not branch off the chain", b.Index)
+	}
+	if expected := block.ExpectedComplexity(history); b.Complexity != expected {
+		return fmt.Errorf("%w: Complexity should be %d", block.ErrNotSuccessor, expected)
+	}
+	if err := block.CheckTimestamp(b, history); err != nil {
+		return errors.Wrap(err, "Block has invalid timestamp")
+	}
+	if !b.Compliant() {
+		return errors.New("Block does not satisfy proof of work")
+	}
+	if len(l.forks) >= MaxForks {
+		return errors.Errorf("Already keeping %d fork blocks", len(l.forks))
+	}
+	if l.forks == nil {
+		l.forks = make(map[string]block.Block)
+	}
+	l.forks[hash] = b
+	return nil
+}
+
+// Tips returns copies of the chain tip followed by the tips of all known forks.
+func (l *Ledger) Tips() []block.Block {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var tips []block.Block
+	if last, ok := l.last(); ok {
+		tips = append(tips, last.Clone())
+	}
+	for _, tip := range l.forkTips() {
+		tips = append(tips, tip.Clone())
+	}
+	return tips
+}
+
+// Reorg switches to the fork with the most accumulated complexity if it exceeds the chain's.
+// The address tree is recomputed by replaying the chain up to the fork point followed by the
+// fork. A fork failing verification is dropped and reported, the chain is left untouched.
+// Forks branching off below the pruning checkpoint are ignored. Transactions of the displaced
+// blocks that are not included in the fork are kept until TakeDisplaced is called.
+func (l *Ledger) Reorg() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	best, bestWork := []block.Block(nil), accumulatedComplexity(l.Blocks)
+	for _, tip := range l.forkTips() {
+		branch := l.branch(tip)
+		forkPoint := branch[0].Index
+		if forkPoint == 0 || forkPoint < l.checkpoint.height || forkPoint > l.size() || !bytes.Equal(branch[0].PreviousHash, l.blockHash(forkPoint-1)) {
+			continue
+		}
+		if work := accumulatedComplexity(l.Blocks[:forkPoint]) + accumulatedComplexity(branch); work > bestWork {
+			best, bestWork = branch, work
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	forkPoint := best[0].Index
+	replay := l.fromCheckpoint()
+	for _, b := range append(append([]block.Block{}, l.Blocks[l.checkpoint.height:forkPoint]...), best...) {
+		if err := replay.appendBlock(b); err != nil {
+			for _, invalid := range best[b.Index-forkPoint:] {
+				delete(l.forks, string(invalid.Hash()))
+			}
+			return errors.Wrapf(err, "Could not switch to fork at block %d", b.Index)
+		}
+	}
+	for _, b := range best {
+		delete(l.forks, string(b.Hash()))
+	}
+	for _, b := range l.Blocks[forkPoint:] {
+		l.forks[string(b.Hash())] = b
+		for _, tx := range b.Data {
+			if _, ok := replay.txs[hex.EncodeToString(tx.Hash())]; !ok && tx.Type != transaction.TypeCoinbase {
+				l.displaced = append(l.displaced, tx.Clone())
+			}
+		}
+	}
+	l.Blocks = replay.Blocks
+	l.hashes = replay.hashes
+	l.txs = replay.txs
+	l.addressTxs = replay.addressTxs
+	l.Addresses = replay.Addresses
+	l.AddressHistory = replay.AddressHistory
+	return nil
+}
+
+// TakeDisplaced returns and forgets the transactions displaced by reorgs since the last call,
+// in the order they appeared in the chain. Callers should re-submit them to their mempool.
+func (l *Ledger) TakeDisplaced() []transaction.TX {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	displaced := l.displaced
+	l.displaced = nil
+	return displaced
+}
+
+// parent looks up the block preceding b in the chain or the known forks.
+func (l *Ledger) parent(b block.Block) (block.Block, bool) {
+	if b.Index > 0 && b.Index <= l.size() && bytes.Equal(b.PreviousHash, l.blockHash(b.Index-1)) {
+		return l.Blocks[b.Index-1], true
+	}
+	parent, ok := l.forks[string(b.PreviousHash)]
+	return parent, ok
+}
+
+// history returns the blocks from genesis up to the parent of b, which is either part
+// of the chain or of a fork branching off the chain.
+func (l *Ledger) history(b block.Block) ([]block.Block, bool) {
+	if b.Index > 0 && b.Index <= l.size() && bytes.Equal(b.PreviousHash, l.blockHash(b.Index-1)) {
+		return l.Blocks[:b.Index], true
+	}
+	parent, ok := l.forks[string(b.PreviousHash)]
+	if !ok {
+		return nil, false
+	}
+	branch := l.branch(parent)
+	forkPoint := branch[0].Index
+	if forkPoint == 0 || forkPoint > l.size() || !bytes.Equal(branch[0].PreviousHash, l.blockHash(forkPoint-1)) {
+		return nil, false
+	}
+	return append(append([]block.Block{}, l.Blocks[:forkPoint]...), branch...), true
+}
+
+// evictForks forgets fork blocks more than ForkDepth blocks below the tip.
+func (l *Ledger) evictForks() {
+	for hash, b := range l.forks {
+		if b.Index+ForkDepth < l.size() {
+			delete(l.forks, hash)
+		}
+	}
+}
+
+// forkTips returns the fork blocks no other fork block builds upon.
+func (l *Ledger) forkTips() []block.Block {
+	extended := make(map[string]bool, len(l.forks))
+	for _, b := range l.forks {
+		extended[string(b.PreviousHash)] = true
+	}
+	var tips []block.Block
+	for hash, b := range l.forks {
+		if !extended[hash] {
+			tips = append(tips, b)
+		}
+	}
+	return tips
+}
+
+// branch returns the fork blocks leading from the chain to the given fork tip.
+func (l *Ledger) branch(tip block.Block) []block.Block {
+	branch := []block.Block{tip}
+	for {
+		parent, ok := l.forks[string(branch[0].PreviousHash)]
+		if !ok {
+			return branch
+		}
+		branch = append([]block.Block{parent}, branch...)
+	}
+}
+
+// accumulatedComplexity sums up the complexity of the given blocks.
+func accumulatedComplexity(blocks []block.Block) uint64 {
+	var sum uint64
+	for _, b := range blocks {
+		sum += b.Complexity
+	}
+	return sum
+}
@@ -0,0 +1,104 @@
+package ledger
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger/account"
+	"github.com/lnsp/txledger/ledger/block"
+	"github.com/lnsp/txledger/ledger/transaction"
+)
+
+// GenesisConfig pins the parameters of a chain at genesis.
+type GenesisConfig struct {
+	// Chain is the unique chain identifier.
+	Chain uint64 `json:"chain"`
+	// Complexity is the complexity of the genesis block.
+	Complexity uint64 `json:"complexity"`
+	// TargetInterval is the desired number of seconds between two blocks.
+	TargetInterval uint64 `json:"targetInterval"`
+	// RewardBase is the subsidy per unit of hash quality.
+	RewardBase uint64 `json:"rewardBase"`
+	// HalvingInterval is the number of blocks after which the subsidy halves, zero keeps the default.
+	HalvingInterval uint64 `json:"halvingInterval,omitempty"`
+	// GenesisMaturity is the number of blocks during which the genesis coinbase is locked.
+	GenesisMaturity uint64 `json:"genesisMaturity,omitempty"`
+	// FeeSchedule is the fee policy, chains without one use the default schedule.
+	FeeSchedule *transaction.FeeSchedule `json:"feeSchedule,omitempty"`
+}
+
+var (
+	// configMu guards configs and GenesisMaturities.
+	configMu sync.Mutex
+	configs  = map[uint64]GenesisConfig{}
+)
+
+// ReadGenesisConfig decodes and validates a JSON genesis config. Unknown fields are rejected.
+func ReadGenesisConfig(r io.Reader) (GenesisConfig, error) {
+	var cfg GenesisConfig
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&cfg); err != nil {
+		return cfg, errors.Wrap(err, "Could not decode genesis config")
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate checks that all required parameters are set.
+func (cfg GenesisConfig) Validate() error {
+	switch {
+	case cfg.Chain == 0:
+		return errors.New("Genesis config requires a chain")
+	case cfg.TargetInterval == 0:
+		return errors.New("Genesis config requires a target interval")
+	case cfg.RewardBase == 0:
+		return errors.New("Genesis config requires a reward base")
+	}
+	return nil
+}
+
+// Register records the chain parameters so that blocks of the chain are verified with them.
+// A chain can not be registered again with different parameters.
+func (cfg GenesisConfig) Register() error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	configMu.Lock()
+	defer configMu.Unlock()
+	if known, ok := configs[cfg.Chain]; ok && !reflect.DeepEqual(known, cfg) {
+		return errors.Errorf("Chain %d is already configured with different parameters", cfg.Chain)
+	}
+	if cfg.FeeSchedule != nil {
+		if err := transaction.RegisterFeeSchedule(cfg.Chain, *cfg.FeeSchedule); err != nil {
+			return err
+		}
+	}
+	configs[cfg.Chain] = cfg
+	block.SetChainParams(cfg.Chain, cfg.TargetInterval, cfg.RewardBase, cfg.HalvingInterval)
+	GenesisMaturities[cfg.Chain] = cfg.GenesisMaturity
+	return nil
+}
+
+// ConfigFor returns the genesis config registered for the chain.
+func ConfigFor(chain uint64) (GenesisConfig, bool) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	cfg, ok := configs[chain]
+	return cfg, ok
+}
+
+// InitFromConfig registers the config and creates a ledger holding the genesis block of the chain.
+func InitFromConfig(cfg GenesisConfig, creator *account.Private) (*Ledger, error) {
+	if err := cfg.Register(); err != nil {
+		return nil, err
+	}
+	l := New(cfg.Chain)
+	if err := l.Init(cfg.Complexity, creator); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -11,6 +11,7 @@ func New() Hasher {
 	return Hasher{a, b}
 }
 
+// Hasher computes the double SHA-256 digest of the written data. It implements hash.Hash.
 type Hasher struct {
 	a, b hash.Hash
 }
@@ -19,7 +20,25 @@ func (h Hasher) Write(b []byte) (int, error) {
 	return h.a.Write(b)
 }
 
-func (h Hasher) Sum() []byte {
-	h.b.Write(h.a.Sum([]byte{}))
-	return h.b.Sum([]byte{})
+// Sum appends SHA-256(SHA-256(data)) to b. It does not change the hasher's state.
+func (h Hasher) Sum(b []byte) []byte {
+	h.b.Reset()
+	h.b.Write(h.a.Sum(nil))
+	return h.b.Sum(b)
+}
+
+// Reset discards the written data.
+func (h Hasher) Reset() {
+	h.a.Reset()
+	h.b.Reset()
+}
+
+// Size returns the digest length in bytes.
+func (h Hasher) Size() int {
+	return sha256.Size
+}
+
+// BlockSize returns the block size of the underlying SHA-256.
+func (h Hasher) BlockSize() int {
+	return h.a.BlockSize()
 }
@@ -0,0 +1,42 @@
+package hash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	stdhash "hash"
+	"testing"
+)
+
+var _ stdhash.Hash = New()
+
+func TestSum(t *testing.T) {
+	h := New()
+	h.Write([]byte("txledger"))
+	inner := sha256.Sum256([]byte("txledger"))
+	outer := sha256.Sum256(inner[:])
+	if !bytes.Equal(h.Sum(nil), outer[:]) {
+		t.Error("Sum should be the double SHA-256 digest")
+	}
+	if !bytes.Equal(h.Sum(nil), outer[:]) {
+		t.Error("Sum should not change the hasher's state")
+	}
+	if sum := h.Sum([]byte{1}); sum[0] != 1 || !bytes.Equal(sum[1:], outer[:]) {
+		t.Error("Sum should append the digest to its argument")
+	}
+	if h.Size() != len(outer) {
+		t.Errorf("Size should be %d, got %d", len(outer), h.Size())
+	}
+}
+
+func TestReset(t *testing.T) {
+	h := New()
+	h.Write([]byte("first"))
+	h.Sum(nil)
+	h.Reset()
+	h.Write([]byte("second"))
+	fresh := New()
+	fresh.Write([]byte("second"))
+	if !bytes.Equal(h.Sum(nil), fresh.Sum(nil)) {
+		t.Error("Reset hasher should produce the same digest as a fresh one")
+	}
+}
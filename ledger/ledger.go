@@ -1,83 +1,986 @@
 package ledger
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/google/btree"
 	"github.com/lnsp/txledger/ledger/account"
 	"github.com/lnsp/txledger/ledger/block"
+	"github.com/lnsp/txledger/ledger/storage"
+	"github.com/lnsp/txledger/ledger/transaction"
 	"github.com/pkg/errors"
 )
 
+const (
+	GrowthWindow = 16
+)
+
+// GenesisMaturities holds the number of blocks per chain during which the genesis coinbase is locked.
+// Chains without an entry treat the genesis coinbase as a premine that is immediately spendable.
+var GenesisMaturities = map[uint64]uint64{}
+
+func GenesisMaturity(chain uint64) uint64 {
+	configMu.Lock()
+	defer configMu.Unlock()
+	return GenesisMaturities[chain]
+}
+
+// ErrStaleBlock is returned by Append for blocks at an index the chain has already surpassed.
+var ErrStaleBlock = errors.New("Block is stale")
+
+// Ledger is safe for concurrent use through its methods. Append, Init, Verify and the
+// Read methods take the write lock, all other methods share the read lock. Accessing the
+// fields directly bypasses the lock.
 type Ledger struct {
-	Chain     uint64
-	Blocks    []block.Block
-	Addresses *btree.BTree
+	Chain          uint64
+	Blocks         []block.Block
+	Addresses      *btree.BTree
+	AddressHistory []uint64
+	// ForkHandler evaluates blocks below the tip that extend an earlier block of the chain.
+	// Without a handler such blocks are rejected as stale. It is called with the write lock
+	// held and must not call back into the ledger.
+	ForkHandler func(block.Block) error
+
+	mu         sync.RWMutex
+	forks      map[string]block.Block
+	displaced  []transaction.TX
+	hashes     map[string]int
+	txs        map[string]txLocation
+	addressTxs map[string][]txLocation
+	checkpoint checkpoint
+}
+
+// txLocation is the position of a transaction within the chain.
+type txLocation struct {
+	block, offset int
+}
+
+type Progress struct {
+	Processed uint64
+	Total     uint64
+	ETA       time.Duration
+}
+
+type Stats struct {
+	Height        uint64
+	Addresses     uint64
+	AddressGrowth uint64
 }
 
 func New(chain uint64) *Ledger {
 	return &Ledger{
-		Chain:     chain,
-		Blocks:    []block.Block{},
-		Addresses: btree.New(2),
+		Chain:          chain,
+		Blocks:         []block.Block{},
+		Addresses:      btree.New(2),
+		AddressHistory: []uint64{},
 	}
 }
 
 func (l *Ledger) Size() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.size()
+}
+
+func (l *Ledger) size() uint64 {
 	return uint64(len(l.Blocks))
 }
 
-func (l *Ledger) Last() block.Block {
-	size := l.Size()
+// Last returns the tip block, sharing its transactions with the ledger, or false if the ledger is empty.
+//
+// Deprecated: Use Tip, which returns a copy that is safe to modify.
+func (l *Ledger) Last() (block.Block, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.last()
+}
+
+func (l *Ledger) last() (block.Block, bool) {
+	size := l.size()
 	if size < 1 {
-		panic(errors.New("Ledger is empty"))
+		return block.Block{}, false
 	}
-	return l.Blocks[size-1]
+	return l.Blocks[size-1], true
+}
+
+// String summarizes the chain id, height, tip, account count and total supply.
+func (l *Ledger) String() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	tip, ok := l.last()
+	if !ok {
+		return fmt.Sprintf("Chain %d: height 0, no tip", l.Chain)
+	}
+	return fmt.Sprintf("Chain %d: height %d, tip %s, complexity %d, %d accounts, total supply %d",
+		l.Chain, l.size(), tip.Fingerprint(), tip.Complexity, l.Addresses.Len(), l.totalSupply())
+}
+
+// Tip returns a copy of the block at the tip of the chain, or false if the ledger is empty.
+func (l *Ledger) Tip() (block.Block, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.size() < 1 {
+		return block.Block{}, false
+	}
+	return l.Blocks[l.size()-1].Clone(), true
+}
+
+// BlockTx returns copies of up to limit transactions of the block at index, starting at txOffset.
+func (l *Ledger) BlockTx(index uint64, txOffset, limit int) ([]transaction.TX, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if index >= l.size() {
+		return nil, errors.Errorf("Block %d does not exist, chain height is %d", index, l.size())
+	}
+	data := l.Blocks[index].Data
+	if txOffset < 0 || txOffset > len(data) {
+		return nil, errors.Errorf("Transaction offset %d out of range, block has %d transactions", txOffset, len(data))
+	}
+	if limit < 1 {
+		return nil, errors.Errorf("Limit %d should be positive", limit)
+	}
+	end := len(data)
+	if limit < end-txOffset {
+		end = txOffset + limit
+	}
+	txs := make([]transaction.TX, 0, end-txOffset)
+	for _, tx := range data[txOffset:end] {
+		txs = append(txs, tx.Clone())
+	}
+	return txs, nil
 }
 
 func (l *Ledger) Append(b block.Block) error {
-	if l.Size() > 0 {
-		if err := b.SuccessorOf(l.Last()); err != nil {
-			return errors.Wrap(err, "Block not successor")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.appendBlock(b)
+}
+
+func (l *Ledger) appendBlock(b block.Block) error {
+	if l.size() > 0 && b.Index < l.size() {
+		return l.appendStale(b)
+	}
+	addresses, err := l.verifySuccessor(b)
+	if err != nil {
+		return err
+	}
+	l.commitBlock(b, addresses)
+	return nil
+}
+
+// verifySuccessor checks that the block extends the tip and returns the resulting address tree
+// without modifying the ledger.
+func (l *Ledger) verifySuccessor(b block.Block) (*btree.BTree, error) {
+	if last, ok := l.last(); ok {
+		if err := b.SuccessorOf(last); err != nil {
+			return nil, err
 		}
+		if expected := block.ExpectedComplexity(l.Blocks); b.Complexity != expected {
+			return nil, fmt.Errorf("%w: Complexity should be %d", block.ErrNotSuccessor, expected)
+		}
+	}
+	if err := block.CheckTimestamp(b, l.Blocks); err != nil {
+		return nil, errors.Wrap(err, "Block has invalid timestamp")
 	}
 	addresses, err := b.Verify(l.Addresses)
 	if err != nil {
-		return errors.Wrap(err, "Block can not be verified")
+		return nil, errors.Wrap(err, "Block can not be verified")
 	}
+	if l.size() > 0 {
+		if err := checkGenesisLock(l.Blocks[0], b.Index, addresses); err != nil {
+			return nil, err
+		}
+		if err := checkScheme(l.Blocks[0], b); err != nil {
+			return nil, err
+		}
+	}
+	return addresses, nil
+}
+
+// commitBlock appends a verified block and its address tree to the chain.
+func (l *Ledger) commitBlock(b block.Block, addresses *btree.BTree) {
 	l.Addresses = addresses
+	l.pushBlock(b)
+	l.AddressHistory = append(l.AddressHistory, uint64(addresses.Len()))
+}
+
+// pushBlock appends the block to the chain and indexes its hash and transactions.
+func (l *Ledger) pushBlock(b block.Block) {
+	if l.hashes == nil || len(l.Blocks) == 0 {
+		l.hashes = make(map[string]int)
+		l.txs = make(map[string]txLocation)
+		l.addressTxs = make(map[string][]txLocation)
+		l.checkpoint = checkpoint{}
+	}
+	l.hashes[b.HashString()] = len(l.Blocks)
+	for i, tx := range b.Data {
+		location := txLocation{block: len(l.Blocks), offset: i}
+		l.txs[hex.EncodeToString(tx.Hash())] = location
+		l.addressTxs[string(tx.Sender)] = append(l.addressTxs[string(tx.Sender)], location)
+		if !bytes.Equal(tx.Sender, tx.Recipient) {
+			l.addressTxs[string(tx.Recipient)] = append(l.addressTxs[string(tx.Recipient)], location)
+		}
+	}
 	l.Blocks = append(l.Blocks, b)
+}
+
+// FindTransaction returns a copy of the transaction with the given hash and the index of its block.
+func (l *Ledger) FindTransaction(hash []byte) (transaction.TX, uint64, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	location, ok := l.txs[hex.EncodeToString(hash)]
+	if !ok {
+		return transaction.TX{}, 0, false
+	}
+	return l.Blocks[location.block].Data[location.offset].Clone(), uint64(location.block), true
+}
+
+// Iterate calls fn with a copy of each block in chain order until fn returns false.
+// The ledger is read-locked during the iteration, fn must not modify it.
+func (l *Ledger) Iterate(fn func(b block.Block) bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, b := range l.Blocks {
+		if !fn(b.Clone()) {
+			return
+		}
+	}
+}
+
+// IterateTransactions calls fn with each transaction and its block in chain order until fn returns false.
+// The ledger is read-locked during the iteration, fn must not modify it.
+func (l *Ledger) IterateTransactions(fn func(b block.Block, tx transaction.TX) bool) {
+	l.Iterate(func(b block.Block) bool {
+		for _, tx := range b.Data {
+			if !fn(b, tx) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// TransactionsFor returns copies of all transactions sent or received by the address in chain order.
+func (l *Ledger) TransactionsFor(address []byte) []transaction.TX {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	locations := l.addressTxs[string(address)]
+	txs := make([]transaction.TX, 0, len(locations))
+	for _, location := range locations {
+		txs = append(txs, l.Blocks[location.block].Data[location.offset].Clone())
+	}
+	return txs
+}
+
+// GetBlockByHash returns a copy of the chain's block with the given hash.
+func (l *Ledger) GetBlockByHash(hash []byte) (block.Block, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	i, ok := l.hashes[hex.EncodeToString(hash)]
+	if !ok || i >= len(l.Blocks) {
+		return block.Block{}, false
+	}
+	return l.Blocks[i].Clone(), true
+}
+
+// GetBlockByIndex returns a copy of the chain's block at the given index.
+func (l *Ledger) GetBlockByIndex(i uint64) (block.Block, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if i >= l.size() {
+		return block.Block{}, false
+	}
+	return l.Blocks[i].Clone(), true
+}
+
+// appendStale handles a block whose index has already been surpassed by the chain.
+// Duplicates and blocks not connecting to the chain are stale, fork candidates are
+// handed to the fork handler.
+func (l *Ledger) appendStale(b block.Block) error {
+	if bytes.Equal(b.Hash(), l.blockHash(b.Index)) {
+		return errors.Wrap(ErrStaleBlock, "Block already in chain")
+	}
+	if b.Index == 0 || l.ForkHandler == nil || b.SuccessorOfHeader(l.Blocks[b.Index-1], l.blockHash(b.Index-1)) != nil {
+		return errors.Wrapf(ErrStaleBlock, "Block %d is below tip %d", b.Index, l.size()-1)
+	}
+	return l.ForkHandler(b)
+}
+
+// checkGenesisLock ensures that the genesis recipient keeps at least the genesis coinbase amount
+// until the chain's genesis maturity has been reached.
+func checkGenesisLock(genesis block.Block, index uint64, addresses *btree.BTree) error {
+	maturity := GenesisMaturity(genesis.Chain)
+	if index >= maturity {
+		return nil
+	}
+	coinbase, ok := genesis.Coinbase()
+	if !ok {
+		return nil
+	}
+	item := addresses.Get(account.AddressTreeItem{Address: coinbase.Recipient})
+	if item == nil || item.(account.AddressTreeItem).Funds < coinbase.Amount {
+		return errors.Errorf("Genesis funds are locked until block %d", maturity)
+	}
 	return nil
 }
 
+// checkScheme ensures that all keys announced in the block use the signature scheme
+// of the genesis coinbase key.
+func checkScheme(genesis block.Block, b block.Block) error {
+	coinbase, ok := genesis.Coinbase()
+	if !ok {
+		return nil
+	}
+	scheme := account.KeyTypeOf(coinbase.Data)
+	for i, tx := range b.Data {
+		if tx.Type != transaction.TypeCoinbase && tx.Type != transaction.TypeAccount {
+			continue
+		}
+		if kind := account.KeyTypeOf(tx.Data); kind != scheme {
+			return errors.Errorf("TX %d uses key type %d instead of the chain's %d", i, kind, scheme)
+		}
+	}
+	return nil
+}
+
+func (l *Ledger) AddressCount() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return uint64(l.Addresses.Len())
+}
+
+func (l *Ledger) AddressGrowth(window uint64) uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.addressGrowth(window)
+}
+
+func (l *Ledger) addressGrowth(window uint64) uint64 {
+	size := uint64(len(l.AddressHistory))
+	if size < 1 {
+		return 0
+	}
+	if window >= size {
+		return l.AddressHistory[size-1]
+	}
+	return l.AddressHistory[size-1] - l.AddressHistory[size-1-window]
+}
+
+func (l *Ledger) Stats() Stats {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return Stats{
+		Height:        l.size(),
+		Addresses:     uint64(l.Addresses.Len()),
+		AddressGrowth: l.addressGrowth(GrowthWindow),
+	}
+}
+
 func (l *Ledger) Init(complexity uint64, creator *account.Private) error {
+	genesis := block.Find(block.Genesis(l.Chain, complexity, creator))
+	if err := block.IsGenesis(genesis); err != nil {
+		return err
+	}
+	if genesis.Complexity != complexity {
+		return errors.Errorf("Genesis complexity should be %d, got %d", complexity, genesis.Complexity)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.Blocks = []block.Block{}
-	genesis := block.Genesis(l.Chain, complexity, creator)
-	return l.Append(block.Find(genesis))
+	l.Addresses = account.NewAddressTree()
+	l.AddressHistory = []uint64{}
+	l.forks = nil
+	l.displaced = nil
+	return l.appendBlock(genesis)
 }
 
 func (l *Ledger) ReadFrom(r io.Reader) error {
-	var size uint64
+	return l.ReadFromContext(context.Background(), r, nil)
+}
+
+func (l *Ledger) ReadFromContext(ctx context.Context, r io.Reader, progress func(Progress)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	if err := h.params.register(h.chain); err != nil {
+		return err
+	}
+	l.Chain = h.chain
 	l.Addresses = account.NewAddressTree()
-	binary.Read(r, binary.LittleEndian, &l.Chain)
-	binary.Read(r, binary.LittleEndian, &size)
 	l.Blocks = make([]block.Block, 0)
-	for i := uint64(0); i < size; i++ {
-		b := block.New().SetBytesFrom(r)
-		err := l.Append(b)
+	l.AddressHistory = make([]uint64, 0)
+	l.forks = nil
+	l.displaced = nil
+	start := time.Now()
+	for i := uint64(0); i < h.size; i++ {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrapf(err, "Import stopped before block %d", i)
+		}
+		b, err := readBlock(r, h, i)
+		if err != nil {
+			return err
+		}
+		err = l.appendBlock(b)
 		if err != nil {
 			return errors.Wrapf(err, "Could not read block %d", i)
 		}
+		if progress != nil {
+			processed := i + 1
+			elapsed := time.Since(start)
+			progress(Progress{
+				Processed: processed,
+				Total:     h.size,
+				ETA:       elapsed / time.Duration(processed) * time.Duration(h.size-processed),
+			})
+		}
+	}
+	return nil
+}
+
+// minBlockSize is the size of a serialized block without transactions.
+const minBlockSize = 6*8 + block.HashSize
+
+const (
+	// fileMagic starts ledger files holding the chain parameters and length-prefixed blocks.
+	fileMagic uint64 = 0x0247444c5854 // "TXLDG\x02"
+	// fileMagicV1 starts ledger files holding length-prefixed blocks without chain parameters.
+	// Legacy files start directly with the chain ID and hold unprefixed blocks.
+	fileMagicV1 uint64 = 0x0147444c5854 // "TXLDG\x01"
+	// countOffset is the position of the block count in a ledger file.
+	countOffset = 16
+	// maxParamsBytes bounds the encoded chain parameters of a ledger file.
+	maxParamsBytes = 1 << 16
+)
+
+// fileHeader describes the layout of a ledger file.
+type fileHeader struct {
+	chain, size uint64
+	// legacy is set for files without length prefixes.
+	legacy bool
+	params chainParams
+}
+
+// chainParams are the parameters of a chain stored in the ledger file header.
+type chainParams struct {
+	// Genesis is the config the chain was initialized from, if any.
+	Genesis *GenesisConfig `json:"genesis,omitempty"`
+	// FeeSchedule is the fee policy of chains without a genesis config.
+	FeeSchedule *transaction.FeeSchedule `json:"feeSchedule,omitempty"`
+}
+
+// params returns the parameters registered for the chain of the ledger.
+func (l *Ledger) params() chainParams {
+	var p chainParams
+	if cfg, ok := ConfigFor(l.Chain); ok {
+		p.Genesis = &cfg
+	} else if schedule, ok := transaction.RegisteredFeeSchedule(l.Chain); ok {
+		p.FeeSchedule = &schedule
+	}
+	return p
+}
+
+// register records the parameters of the chain so that its blocks are verified with them.
+// Parameters conflicting with the ones already registered are rejected.
+func (p chainParams) register(chain uint64) error {
+	if p.Genesis != nil {
+		if p.Genesis.Chain != chain {
+			return errors.Errorf("Ledger file holds the genesis config of chain %d instead of %d", p.Genesis.Chain, chain)
+		}
+		if err := p.Genesis.Register(); err != nil {
+			return err
+		}
+	}
+	if p.FeeSchedule != nil {
+		return errors.Wrap(transaction.RegisterFeeSchedule(chain, *p.FeeSchedule), "Ledger file fee schedule conflicts")
+	}
+	return nil
+}
+
+// readHeader reads the chain ID and block count of a ledger file. Block counts that can not
+// possibly fit into the remaining input are rejected.
+func readHeader(r io.Reader) (fileHeader, error) {
+	var h fileHeader
+	var magic uint64
+	if err := binary.Read(r, binary.LittleEndian, &magic); err != nil {
+		return h, errors.Wrap(err, "Ledger file truncated, could not read chain")
+	}
+	if magic == fileMagic || magic == fileMagicV1 {
+		if err := binary.Read(r, binary.LittleEndian, &h.chain); err != nil {
+			return h, errors.Wrap(err, "Ledger file truncated, could not read chain")
+		}
+	} else {
+		h.chain, h.legacy = magic, true
+	}
+	if err := binary.Read(r, binary.LittleEndian, &h.size); err != nil {
+		return h, errors.Wrap(err, "Ledger file truncated, could not read block count")
+	}
+	if magic == fileMagic {
+		var paramsSize uint64
+		if err := binary.Read(r, binary.LittleEndian, &paramsSize); err != nil {
+			return h, errors.Wrap(err, "Ledger file truncated, could not read chain parameters")
+		}
+		if paramsSize > maxParamsBytes {
+			return h, errors.Errorf("Chain parameters exceed %d bytes", maxParamsBytes)
+		}
+		params := make([]byte, paramsSize)
+		if _, err := io.ReadFull(r, params); err != nil {
+			return h, errors.Wrap(err, "Ledger file truncated, could not read chain parameters")
+		}
+		if err := json.Unmarshal(params, &h.params); err != nil {
+			return h, errors.Wrap(err, "Could not decode chain parameters")
+		}
+	}
+	blockSize := uint64(8 + minBlockSize)
+	if h.legacy {
+		blockSize = minBlockSize
+	}
+	if sized, ok := r.(interface{ Len() int }); ok && h.size > uint64(sized.Len())/blockSize {
+		return h, errors.Errorf("Ledger file truncated, %d blocks can not fit into %d bytes", h.size, sized.Len())
+	}
+	return h, nil
+}
+
+// readBlock decodes block i of a ledger file.
+func readBlock(r io.Reader, h fileHeader, i uint64) (block.Block, error) {
+	var (
+		b   block.Block
+		err error
+	)
+	if h.legacy {
+		b, err = block.New().SetBytesFrom(r)
+	} else {
+		b, err = readPrefixedBlock(r)
+	}
+	if cause := errors.Cause(err); cause == io.EOF || cause == io.ErrUnexpectedEOF {
+		return b, errors.Wrapf(err, "Ledger file truncated at block %d", i)
+	} else if err != nil {
+		return b, errors.Wrapf(err, "Could not decode block %d", i)
+	}
+	return b, nil
+}
+
+// readPrefixedBlock decodes a block preceded by its length.
+func readPrefixedBlock(r io.Reader) (block.Block, error) {
+	var size uint64
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return block.Block{}, err
+	}
+	if size > block.MaxBlockBytes {
+		return block.Block{}, errors.Errorf("Block exceeds %d bytes", block.MaxBlockBytes)
+	}
+	encoded := make([]byte, size)
+	if _, err := io.ReadFull(r, encoded); err != nil {
+		return block.Block{}, err
+	}
+	reader := bytes.NewReader(encoded)
+	b, err := block.New().SetBytesFrom(reader)
+	if err != nil {
+		return b, err
+	}
+	if reader.Len() > 0 {
+		return b, errors.Errorf("Block has %d trailing bytes", reader.Len())
+	}
+	return b, nil
+}
+
+// writePrefixedBlock writes the block preceded by its length.
+func writePrefixedBlock(w io.Writer, b block.Block) (int64, error) {
+	prefix := make([]byte, 8)
+	binary.LittleEndian.PutUint64(prefix, b.Size())
+	n, err := w.Write(prefix)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := b.WriteTo(w)
+	return int64(n) + m, err
+}
+
+// AppendToFile appends the block to the ledger and to the ledger file at the given path,
+// see AppendToStorage.
+func (l *Ledger) AppendToFile(path string, b block.Block) error {
+	return l.AppendToStorage(storage.Dir(""), path, b)
+}
+
+// AppendToStorage appends the block to the ledger and to the ledger stored under the given name,
+// which must hold the blocks preceding it. Only the new block and the block count are written.
+// The block is added to the ledger once it is synced to the storage, a failed write is rolled back.
+func (l *Ledger) AppendToStorage(s storage.Storage, name string, b block.Block) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := s.Open(name)
+	if err != nil {
+		return errors.Wrap(err, "Could not open ledger file")
+	}
+	defer file.Close()
+	return l.appendToFile(file, b)
+}
+
+func (l *Ledger) appendToFile(file storage.File, b block.Block) error {
+	h, err := readHeader(file)
+	if err != nil {
+		return err
+	}
+	switch {
+	case h.legacy:
+		return errors.New("Ledger file uses the legacy format and must be rewritten")
+	case h.chain != l.Chain:
+		return errors.Errorf("Ledger file belongs to chain %d instead of %d", h.chain, l.Chain)
+	case h.size != l.size():
+		return errors.Errorf("Ledger file holds %d blocks instead of %d", h.size, l.size())
+	case b.Index != l.size():
+		return errors.Errorf("Block %d does not extend the chain of height %d", b.Index, l.size())
+	}
+	addresses, err := l.verifySuccessor(b)
+	if err != nil {
+		return err
+	}
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrap(err, "Could not seek to end of ledger file")
+	}
+	if err := writeAppended(file, b, h.size+1); err != nil {
+		return rollbackAppend(file, end, h.size, err)
+	}
+	l.commitBlock(b, addresses)
+	return nil
+}
+
+// writeAppended writes the block at the current offset, updates the block count and syncs the file.
+func writeAppended(file storage.File, b block.Block, count uint64) error {
+	if _, err := writePrefixedBlock(file, b); err != nil {
+		return errors.Wrapf(err, "Could not write block %d", b.Index)
+	}
+	if err := writeCount(file, count); err != nil {
+		return err
+	}
+	return errors.Wrap(file.Sync(), "Could not sync ledger file")
+}
+
+// rollbackAppend restores the block count and truncates the file to its previous size.
+func rollbackAppend(file storage.File, end int64, count uint64, cause error) error {
+	if err := writeCount(file, count); err != nil {
+		return errors.Wrapf(cause, "Could not roll back ledger file (%v)", err)
+	}
+	if err := file.Truncate(end); err != nil {
+		return errors.Wrapf(cause, "Could not roll back ledger file (%v)", err)
+	}
+	return cause
+}
+
+func writeCount(file io.WriterAt, count uint64) error {
+	buffer := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buffer, count)
+	if _, err := file.WriteAt(buffer, countOffset); err != nil {
+		return errors.Wrap(err, "Could not update block count")
+	}
+	return nil
+}
+
+func (l *Ledger) ReadUnverified(r io.Reader) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	if err := h.params.register(h.chain); err != nil {
+		return err
+	}
+	l.Chain = h.chain
+	l.Addresses = account.NewAddressTree()
+	l.Blocks = make([]block.Block, 0)
+	l.AddressHistory = make([]uint64, 0)
+	l.forks = nil
+	l.displaced = nil
+	for i := uint64(0); i < h.size; i++ {
+		b, err := readBlock(r, h, i)
+		if err != nil {
+			return err
+		}
+		l.pushBlock(b)
+	}
+	return nil
+}
+
+// VerificationError reports the block at which a verification stopped.
+type VerificationError struct {
+	// Index is the failed block, all blocks below it have been verified.
+	Index uint64
+	Err   error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("Verification stopped at block %d: %v", e.Index, e.Err)
+}
+
+// Cause returns the reason the verification stopped.
+func (e *VerificationError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the reason the verification stopped.
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+func (l *Ledger) Verify() (uint64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.verify(context.Background(), nil)
+}
+
+// VerifyAll re-verifies the chain from genesis like Verify, calling progress after each verified
+// block. It stops early when the context is done. Failures are reported as *VerificationError.
+func (l *Ledger) VerifyAll(ctx context.Context, progress func(index uint64)) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if index, err := l.verify(ctx, progress); err != nil {
+		return &VerificationError{Index: index, Err: err}
 	}
 	return nil
 }
 
-func (l *Ledger) WriteTo(w io.Writer) {
-	size := uint64(len(l.Blocks))
-	binary.Write(w, binary.LittleEndian, &l.Chain)
-	binary.Write(w, binary.LittleEndian, size)
+func (l *Ledger) verify(ctx context.Context, progress func(index uint64)) (uint64, error) {
+	addresses := account.NewAddressTree()
+	history := make([]uint64, 0, len(l.Blocks))
+	for i, b := range l.Blocks {
+		if err := ctx.Err(); err != nil {
+			return uint64(i), err
+		}
+		if b.Chain != l.Chain {
+			return uint64(i), errors.Errorf("Block chain %d does not match ledger chain %d", b.Chain, l.Chain)
+		}
+		if i > 0 {
+			if err := b.SuccessorOfHeader(l.Blocks[i-1], l.blockHash(uint64(i-1))); err != nil {
+				return uint64(i), err
+			}
+			if expected := block.ExpectedComplexity(l.Blocks[:i]); b.Complexity != expected {
+				return uint64(i), fmt.Errorf("%w: Complexity should be %d", block.ErrNotSuccessor, expected)
+			}
+		}
+		if err := block.CheckTimestamp(b, l.Blocks[:i]); err != nil {
+			return uint64(i), errors.Wrap(err, "Block has invalid timestamp")
+		}
+		if uint64(i) < l.checkpoint.height {
+			// Pruned blocks only keep their headers, their transactions are covered by the checkpoint.
+			addresses = l.checkpoint.addresses
+			history = append(history, l.AddressHistory[i])
+			if progress != nil {
+				progress(uint64(i))
+			}
+			continue
+		}
+		if !b.Compliant() {
+			return uint64(i), block.ErrNotCompliant
+		}
+		next, err := b.Verify(addresses)
+		if err != nil {
+			return uint64(i), errors.Wrap(err, "Block can not be verified")
+		}
+		if i > 0 {
+			if err := checkGenesisLock(l.Blocks[0], b.Index, next); err != nil {
+				return uint64(i), err
+			}
+			if err := checkScheme(l.Blocks[0], b); err != nil {
+				return uint64(i), err
+			}
+		}
+		addresses = next
+		history = append(history, uint64(addresses.Len()))
+		if progress != nil {
+			progress(uint64(i))
+		}
+	}
+	l.Addresses = addresses
+	l.AddressHistory = history
+	return l.size(), nil
+}
+
+func (l *Ledger) NonCompliant() []uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	failed := []uint64{}
+	for i, b := range l.Blocks {
+		if uint64(i) >= l.checkpoint.height && !b.Compliant() {
+			failed = append(failed, uint64(i))
+		}
+	}
+	return failed
+}
+
+// Balance returns the funds of the given address and whether the address is known to the chain.
+func (l *Ledger) Balance(address []byte) (uint64, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	item := l.Addresses.Get(account.AddressTreeItem{Address: address})
+	if item == nil {
+		return 0, false
+	}
+	return item.(account.AddressTreeItem).Funds, true
+}
+
+// Simulate checks whether the transaction would be accepted in the next block without
+// modifying the ledger, and returns the reason if not.
+func (l *Ledger) Simulate(tx transaction.TX) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if tx.Chain != l.Chain {
+		return errors.Errorf("TX belongs to chain %d instead of %d", tx.Chain, l.Chain)
+	}
+	if tx.Type == transaction.TypeCoinbase {
+		return errors.New("Coinbase can not be simulated")
+	}
+	if _, ok := l.txs[hex.EncodeToString(tx.Hash())]; ok {
+		return errors.New("TX is already part of the chain")
+	}
+	tree := l.Addresses.Clone()
+	if err := tx.Validate(tree, 0, block.ExpectedComplexity(l.Blocks)); err != nil {
+		return err
+	}
+	if tx.Type == transaction.TypeTransfer || tx.Type == transaction.TypeBurn {
+		sender := tree.Get(account.AddressTreeItem{Address: tx.Sender}).(account.AddressTreeItem)
+		if total, ok := transaction.AddAmounts(tx.Amount, tx.Fee); !ok || sender.Funds < total {
+			return fmt.Errorf("%w: Sender holds %d, TX requires %d", block.ErrInsufficientFunds, sender.Funds, total)
+		}
+		if tx.Sequenced() && tx.Nonce != sender.Nonce+1 {
+			return errors.Errorf("Nonce should be %d, got %d", sender.Nonce+1, tx.Nonce)
+		}
+	}
+	if !tx.Apply(tree) {
+		return errors.New("TX can not be applied")
+	}
+	return nil
+}
+
+// Accounts returns a snapshot of all known accounts sorted by address.
+func (l *Ledger) Accounts() []account.AddressTreeItem {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	accounts := make([]account.AddressTreeItem, 0, l.Addresses.Len())
+	l.Addresses.Ascend(func(item btree.Item) bool {
+		accounts = append(accounts, item.(account.AddressTreeItem))
+		return true
+	})
+	return accounts
+}
+
+// AddressTree returns a copy of the address tree at the tip. Cloning modifies the tree's
+// copy-on-write context, so it takes the write lock.
+func (l *Ledger) AddressTree() *btree.BTree {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.Addresses.Clone()
+}
+
+// ExpectedComplexity returns the complexity required of the next block.
+func (l *Ledger) ExpectedComplexity() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return block.ExpectedComplexity(l.Blocks)
+}
+
+// Next returns an unsolved successor of the tip using the expected complexity.
+func (l *Ledger) Next() block.Block {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return block.NextWithHistory(l.Blocks)
+}
+
+// SubsidyAt returns the subsidy per unit of hash quality paid to the block at the given height.
+func (l *Ledger) SubsidyAt(height uint64) uint64 {
+	return block.Subsidy(l.Chain, height)
+}
+
+// TotalSupply sums up the funds of all accounts. It equals the issued rewards minus the burned amounts.
+func (l *Ledger) TotalSupply() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.totalSupply()
+}
+
+func (l *Ledger) totalSupply() uint64 {
+	var supply uint64
+	l.Addresses.Ascend(func(item btree.Item) bool {
+		supply += item.(account.AddressTreeItem).Funds
+		return true
+	})
+	return supply
+}
+
+// Burned sums up the value destroyed by burn transactions, which no longer counts towards TotalSupply.
+func (l *Ledger) Burned() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	burned := l.checkpoint.burned
+	for _, b := range l.Blocks {
+		for _, tx := range b.Data {
+			if tx.Type == transaction.TypeBurn {
+				burned += tx.Amount
+			}
+		}
+	}
+	return burned
+}
+
+// ReadFromStorage reads and verifies the ledger stored under the given name.
+func (l *Ledger) ReadFromStorage(s storage.Storage, name string) error {
+	r, err := s.Read(name)
+	if err != nil {
+		return errors.Wrap(err, "Could not open ledger")
+	}
+	defer r.Close()
+	return l.ReadFrom(r)
+}
+
+// WriteToStorage stores the ledger under the given name.
+func (l *Ledger) WriteToStorage(s storage.Storage, name string) error {
+	w, err := s.Write(name)
+	if err != nil {
+		return errors.Wrap(err, "Could not create ledger")
+	}
+	if _, err := l.WriteTo(w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// WriteTo streams the file header with chain id, block count and chain parameters followed by the
+// length-prefixed blocks to w. It returns the number of bytes written and stops at the first write error.
+func (l *Ledger) WriteTo(w io.Writer) (int64, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	params, err := json.Marshal(l.params())
+	if err != nil {
+		return 0, errors.Wrap(err, "Could not encode chain parameters")
+	}
+	header := make([]byte, countOffset+16, countOffset+16+len(params))
+	binary.LittleEndian.PutUint64(header, fileMagic)
+	binary.LittleEndian.PutUint64(header[8:], l.Chain)
+	binary.LittleEndian.PutUint64(header[countOffset:], uint64(len(l.Blocks)))
+	binary.LittleEndian.PutUint64(header[countOffset+8:], uint64(len(params)))
+	n, err := w.Write(append(header, params...))
+	written := int64(n)
+	if err != nil {
+		return written, errors.Wrap(err, "Could not write ledger header")
+	}
 	for i := range l.Blocks {
-		w.Write(l.Blocks[i].Bytes())
+		n, err := writePrefixedBlock(w, l.Blocks[i])
+		written += n
+		if err != nil {
+			return written, errors.Wrapf(err, "Could not write block %d", i)
+		}
 	}
+	return written, nil
 }
@@ -0,0 +1,1293 @@
+package ledger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lnsp/txledger/ledger/account"
+	"github.com/lnsp/txledger/ledger/account/container"
+	"github.com/lnsp/txledger/ledger/block"
+	"github.com/lnsp/txledger/ledger/storage"
+	"github.com/lnsp/txledger/ledger/transaction"
+	"github.com/lnsp/txledger/mempool"
+	"github.com/pkg/errors"
+)
+
+func mine(t *testing.T, l *Ledger, miner *account.Private, txs ...transaction.TX) block.Block {
+	next := l.Next()
+	reward, err := block.BlockReward(next.Chain, next.Index, next.Complexity, txs)
+	if err != nil {
+		t.Fatal("Could not compute block reward:", err)
+	}
+	next = next.Append(transaction.NewCoinbase(l.Chain, miner, reward))
+	for _, tx := range txs {
+		next = next.Append(tx)
+	}
+	next = block.Find(next)
+	if err := l.Append(next); err != nil {
+		t.Fatal("Could not append block:", err)
+	}
+	return next
+}
+
+func TestAddressGrowth(t *testing.T) {
+	l := New(1)
+	if err := l.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	for i := 0; i < 4; i++ {
+		mine(t, l, account.NewPrivate(), transaction.NewAccount(l.Chain, account.NewPrivate()))
+		if l.AddressHistory[len(l.AddressHistory)-1] != l.AddressCount() {
+			t.Error("Tracked address count should match AddressCount")
+		}
+	}
+	if l.AddressCount() != 9 {
+		t.Errorf("AddressCount should be 9, got %d", l.AddressCount())
+	}
+	if growth := l.AddressGrowth(2); growth != 4 {
+		t.Errorf("AddressGrowth over 2 blocks should be 4, got %d", growth)
+	}
+	stats := l.Stats()
+	if stats.Height != 5 || stats.Addresses != 9 || stats.AddressGrowth != 9 {
+		t.Errorf("Stats should match ledger state, got %+v", stats)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	l := New(1)
+	miner := account.NewPrivate()
+	if err := l.Init(16, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	for i := 0; i < 3; i++ {
+		mine(t, l, miner)
+	}
+	if index, err := l.Verify(); err != nil {
+		t.Fatalf("Verify should pass, failed at block %d: %v", index, err)
+	}
+	if reward, _ := block.BlockReward(l.Chain, 0, 16, nil); l.TotalSupply() != 4*reward {
+		t.Errorf("TotalSupply should be sum of rewards, got %d", l.TotalSupply())
+	}
+	l.Blocks[2].Timestamp = 0
+	if index, err := l.Verify(); err == nil || index != 2 {
+		t.Errorf("Verify should fail at block 2, got %d: %v", index, err)
+	}
+}
+
+func TestReadFromContext(t *testing.T) {
+	l := New(1)
+	miner := account.NewPrivate()
+	if err := l.Init(16, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	for i := 0; i < 5; i++ {
+		mine(t, l, miner)
+	}
+	buffer := bytes.NewBuffer([]byte{})
+	l.WriteTo(buffer)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	imported := New(0)
+	var reports []Progress
+	err := imported.ReadFromContext(ctx, bytes.NewReader(buffer.Bytes()), func(p Progress) {
+		reports = append(reports, p)
+		if p.Processed == 3 {
+			cancel()
+		}
+	})
+	if errors.Cause(err) != context.Canceled {
+		t.Fatal("ReadFromContext should stop on cancellation, got", err)
+	}
+	if imported.Size() != 3 || len(reports) != 3 {
+		t.Errorf("Import should stop after 3 blocks, got %d", imported.Size())
+	}
+	if reports[2].Total != 6 {
+		t.Errorf("Progress should report total of 6 blocks, got %d", reports[2].Total)
+	}
+	if index, err := imported.Verify(); err != nil {
+		t.Errorf("Partial ledger should verify, failed at block %d: %v", index, err)
+	}
+}
+
+func TestGenesisMaturity(t *testing.T) {
+	for _, maturity := range []uint64{0, 10} {
+		GenesisMaturities[2] = maturity
+		l := New(2)
+		creator, recipient := account.NewPrivate(), account.NewPrivate()
+		if err := l.Init(16, creator); err != nil {
+			t.Fatal("Could not init ledger:", err)
+		}
+		genesis, _ := l.Blocks[0].Coinbase()
+		item := l.Addresses.Get(account.AddressTreeItem{Address: creator.Address()}).(account.AddressTreeItem)
+		item.Funds += 20000
+		l.Addresses.ReplaceOrInsert(item)
+		l.Addresses.ReplaceOrInsert(account.AddressTreeItem{
+			Address: recipient.Address(),
+			Account: account.NewPublic(recipient.PublicKeyBytes()),
+		})
+
+		fee := transaction.EstimateFee(0, 17)
+		amount := item.Funds - fee - genesis.Amount + 1
+		next := block.NextWithHistory(l.Blocks)
+		transfer := transaction.NewTransfer(l.Chain, amount, fee, 1, creator, recipient)
+		next = next.Append(transaction.NewCoinbase(l.Chain, recipient, 0)).Append(transfer)
+		err := l.Append(block.Find(next))
+		if maturity == 0 && err != nil {
+			t.Error("Genesis funds should be spendable without maturity:", err)
+		} else if maturity > 0 && err == nil {
+			t.Error("Genesis funds should be locked during maturity")
+		}
+	}
+	delete(GenesisMaturities, 2)
+}
+
+const sampleGenesis = `{
+	"chain": 42,
+	"complexity": 16,
+	"targetInterval": 30,
+	"rewardBase": 100,
+	"halvingInterval": 1000,
+	"genesisMaturity": 2,
+	"feeSchedule": {"Base": 2, "SizeScalar": 1, "ComplexityScalar": 1, "Epoch": 16}
+}`
+
+func TestInitFromConfig(t *testing.T) {
+	cfg, err := ReadGenesisConfig(strings.NewReader(sampleGenesis))
+	if err != nil {
+		t.Fatal("Could not read sample config:", err)
+	}
+	forget := func() {
+		delete(configs, cfg.Chain)
+		delete(block.ChainTargetIntervals, cfg.Chain)
+		delete(block.ChainRewardBases, cfg.Chain)
+		delete(block.ChainHalvingIntervals, cfg.Chain)
+		delete(GenesisMaturities, cfg.Chain)
+		delete(transaction.FeeSchedules, cfg.Chain)
+	}
+	defer forget()
+	miner := account.NewPrivate()
+	l, err := InitFromConfig(cfg, miner)
+	if err != nil {
+		t.Fatal("Could not init ledger from config:", err)
+	}
+	coinbase, _ := l.Blocks[0].Coinbase()
+	if l.Chain != 42 || coinbase.Amount != block.HashQuality(16)*100 {
+		t.Errorf("Genesis should use the configured chain and reward base, got chain %d and reward %d", l.Chain, coinbase.Amount)
+	}
+	if block.TargetIntervalFor(42) != 30 || GenesisMaturity(42) != 2 || transaction.FeeScheduleFor(42).Base != 2 {
+		t.Error("Config should register the chain parameters")
+	}
+	mine(t, l, miner)
+	if _, err := l.Verify(); err != nil {
+		t.Error("Configured chain should verify:", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			block.Subsidy(42, uint64(i))
+			GenesisMaturity(42)
+			transaction.FeeScheduleFor(42)
+		}
+	}()
+	if _, err := InitFromConfig(cfg, miner); err != nil {
+		t.Error("Registering the same config again should succeed:", err)
+	}
+	<-done
+	changed := cfg
+	changed.RewardBase = 1
+	if _, err := InitFromConfig(changed, miner); err == nil {
+		t.Error("Registering different parameters for a known chain should fail")
+	}
+
+	var buffer bytes.Buffer
+	l.WriteTo(&buffer)
+	forget()
+	reloaded := New(0)
+	if err := reloaded.ReadFrom(bytes.NewReader(buffer.Bytes())); err != nil {
+		t.Fatal("Ledger should be read with the config it records:", err)
+	}
+	if known, ok := ConfigFor(42); !ok || !reflect.DeepEqual(known, cfg) {
+		t.Error("Reading a ledger should register its genesis config")
+	}
+	if _, err := reloaded.Verify(); err != nil {
+		t.Error("Reloaded chain should verify:", err)
+	}
+
+	invalid := map[string]string{
+		"missing chain":           `{"targetInterval": 30, "rewardBase": 100}`,
+		"missing target interval": `{"chain": 43, "rewardBase": 100}`,
+		"missing reward base":     `{"chain": 43, "targetInterval": 30}`,
+		"unknown field":           `{"chain": 43, "targetInterval": 30, "rewardBase": 100, "halving": 1}`,
+		"malformed":               `{"chain": "43"}`,
+	}
+	for name, config := range invalid {
+		if _, err := ReadGenesisConfig(strings.NewReader(config)); err == nil {
+			t.Errorf("Config with %s should be rejected", name)
+		}
+	}
+}
+
+func TestTotalSupply(t *testing.T) {
+	l := New(1)
+	miner := account.NewPrivate()
+	if err := l.Init(16*16*4, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	for i := 0; i < 5; i++ {
+		mine(t, l, account.NewPrivate())
+	}
+	var rewards uint64
+	for _, b := range l.Blocks {
+		coinbase, _ := b.Coinbase()
+		rewards += coinbase.Amount
+	}
+	if supply := l.TotalSupply(); supply != rewards || supply == 0 {
+		t.Errorf("TotalSupply should equal the sum of rewards %d, got %d", rewards, supply)
+	}
+}
+
+// failingWriter accepts limit bytes and fails all further writes.
+type failingWriter struct {
+	limit int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n := w.limit
+		w.limit = 0
+		return n, errors.New("Disk full")
+	}
+	w.limit -= len(p)
+	return len(p), nil
+}
+
+func TestWriteTo(t *testing.T) {
+	l := New(1)
+	miner := account.NewPrivate()
+	if err := l.Init(16, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	mine(t, l, miner)
+	var buffer bytes.Buffer
+	n, err := l.WriteTo(&buffer)
+	if err != nil || n != int64(buffer.Len()) {
+		t.Fatalf("WriteTo should report %d bytes, got %d (%v)", buffer.Len(), n, err)
+	}
+	for _, limit := range []int{0, 10, 16, buffer.Len() - 1} {
+		n, err := l.WriteTo(&failingWriter{limit: limit})
+		if err == nil || n != int64(limit) {
+			t.Errorf("WriteTo failing after %d bytes should return the error and %d bytes, got %d (%v)", limit, limit, n, err)
+		}
+	}
+}
+
+func TestAppendToFile(t *testing.T) {
+	l := New(1)
+	miner := account.NewPrivate()
+	if err := l.Init(0, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	path := filepath.Join(t.TempDir(), "ledger")
+	var buffer bytes.Buffer
+	if _, err := l.WriteTo(&buffer); err != nil {
+		t.Fatal("Could not write ledger:", err)
+	}
+	if err := ioutil.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		t.Fatal("Could not create ledger file:", err)
+	}
+	for i := 0; i < 100; i++ {
+		next := block.NextWithHistory(l.Blocks)
+		reward, _ := block.BlockReward(next.Chain, next.Index, next.Complexity, nil)
+		next = next.Append(transaction.NewCoinbase(l.Chain, miner, reward))
+		// The complexity stays low enough to search the variance without Find's worker chunks.
+		for !next.Compliant() {
+			next.Variance++
+		}
+		if err := l.AppendToFile(path, next); err != nil {
+			t.Fatalf("Could not append block %d: %v", i+1, err)
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal("Could not open ledger file:", err)
+	}
+	defer file.Close()
+	reloaded := New(0)
+	if err := reloaded.ReadFrom(file); err != nil {
+		t.Fatal("Could not reload ledger:", err)
+	}
+	if reloaded.Size() != 101 || !bytes.Equal(tipHash(reloaded), tipHash(l)) {
+		t.Errorf("Reloaded ledger should hold 101 blocks ending in the tip, got %d", reloaded.Size())
+	}
+	stale := block.NextWithHistory(l.Blocks[:50])
+	if err := New(2).AppendToFile(path, stale); err == nil {
+		t.Error("Appending to the file of another chain should fail")
+	}
+}
+
+// failingFile writes through to the file until its writer fails, optionally failing Sync.
+type failingFile struct {
+	*os.File
+	writer   failingWriter
+	failSync bool
+}
+
+func (f *failingFile) Write(p []byte) (int, error) {
+	n, err := f.writer.Write(p)
+	if _, werr := f.File.Write(p[:n]); werr != nil {
+		return 0, werr
+	}
+	return n, err
+}
+
+func (f *failingFile) Sync() error {
+	if f.failSync {
+		return errors.New("Sync failed")
+	}
+	return f.File.Sync()
+}
+
+func TestAppendToFileRollback(t *testing.T) {
+	l := New(1)
+	miner := account.NewPrivate()
+	if err := l.Init(0, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	path := filepath.Join(t.TempDir(), "ledger")
+	var buffer bytes.Buffer
+	if _, err := l.WriteTo(&buffer); err != nil {
+		t.Fatal("Could not write ledger:", err)
+	}
+	if err := ioutil.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+		t.Fatal("Could not create ledger file:", err)
+	}
+	next := l.Next()
+	reward, _ := block.BlockReward(next.Chain, next.Index, next.Complexity, nil)
+	next = next.Append(transaction.NewCoinbase(l.Chain, miner, reward))
+	for !next.Compliant() {
+		next.Variance++
+	}
+	for _, f := range []*failingFile{{writer: failingWriter{limit: 20}}, {writer: failingWriter{limit: 1 << 20}, failSync: true}} {
+		file, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal("Could not open ledger file:", err)
+		}
+		f.File = file
+		err = l.appendToFile(f, next)
+		file.Close()
+		if err == nil {
+			t.Fatal("Append should fail if the file can not be written")
+		}
+		if l.Size() != 1 {
+			t.Errorf("Failed append should not change the ledger, got height %d", l.Size())
+		}
+		if content, _ := ioutil.ReadFile(path); !bytes.Equal(content, buffer.Bytes()) {
+			t.Error("Failed append should restore the ledger file")
+		}
+	}
+	if err := l.AppendToFile(path, next); err != nil || l.Size() != 2 {
+		t.Error("Append should succeed after a rolled back attempt:", err)
+	}
+}
+
+func TestReadLegacyFile(t *testing.T) {
+	l := New(1)
+	miner := account.NewPrivate()
+	if err := l.Init(16, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	mine(t, l, miner)
+	var legacy bytes.Buffer
+	binary.Write(&legacy, binary.LittleEndian, []uint64{l.Chain, l.Size()})
+	for _, b := range l.Blocks {
+		legacy.Write(b.Bytes())
+	}
+	reloaded := New(0)
+	if err := reloaded.ReadFrom(bytes.NewReader(legacy.Bytes())); err != nil || reloaded.Size() != 2 {
+		t.Fatal("Legacy ledger file should be readable:", err)
+	}
+	path := filepath.Join(t.TempDir(), "ledger")
+	if err := ioutil.WriteFile(path, legacy.Bytes(), 0644); err != nil {
+		t.Fatal("Could not create ledger file:", err)
+	}
+	if err := reloaded.AppendToFile(path, block.NextWithHistory(l.Blocks)); err == nil {
+		t.Error("Appending to a legacy ledger file should fail")
+	}
+}
+
+func tipHash(l *Ledger) []byte {
+	tip, _ := l.Last()
+	return tip.Hash()
+}
+
+func TestVerifyAll(t *testing.T) {
+	l := New(1)
+	miner := account.NewPrivate()
+	if err := l.Init(0, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	for i := 0; i < 8; i++ {
+		mine(t, l, miner)
+	}
+	var reported []uint64
+	if err := l.VerifyAll(context.Background(), func(index uint64) { reported = append(reported, index) }); err != nil {
+		t.Fatal("VerifyAll should pass:", err)
+	}
+	if len(reported) != 9 || reported[8] != 8 {
+		t.Errorf("Progress should fire once per block, got %v", reported)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var last uint64
+	err := l.VerifyAll(ctx, func(index uint64) {
+		last = index
+		if index == 4 {
+			cancel()
+		}
+	})
+	verr, ok := err.(*VerificationError)
+	if err == nil || !ok {
+		t.Fatal("Cancelled VerifyAll should return a VerificationError, got", err)
+	}
+	if last != 4 || verr.Index != 5 || verr.Err != context.Canceled {
+		t.Errorf("VerifyAll should stop after block 4, got progress %d and %+v", last, verr)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Error("VerificationError should unwrap to its cause")
+	}
+
+	orphan := block.NextWithHistory(l.Blocks).Append(transaction.NewCoinbase(l.Chain, miner, 0))
+	orphan.PreviousHash = l.Blocks[3].Hash()
+	if err := l.Append(block.Find(orphan)); !errors.Is(err, block.ErrNotSuccessor) {
+		t.Error("Append of an orphaned block should fail with ErrNotSuccessor, got", err)
+	}
+}
+
+func TestLast(t *testing.T) {
+	l := New(1)
+	if _, ok := l.Last(); ok {
+		t.Error("Last should report an empty ledger")
+	}
+	if err := l.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	if last, ok := l.Last(); !ok || !bytes.Equal(last.Hash(), l.Blocks[0].Hash()) {
+		t.Error("Last should return the genesis block")
+	}
+}
+
+func TestString(t *testing.T) {
+	l := New(7)
+	if s := l.String(); s != "Chain 7: height 0, no tip" {
+		t.Errorf("Empty ledger should report no tip, got %q", s)
+	}
+	if err := l.Init(16, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	genesis := l.Blocks[0]
+	want := fmt.Sprintf("Chain 7: height 1, tip %s, complexity 16, 1 accounts, total supply %d", genesis.Fingerprint(), l.TotalSupply())
+	if s := l.String(); s != want {
+		t.Errorf("Ledger summary should be %q, got %q", want, s)
+	}
+}
+
+func TestSubsidyAt(t *testing.T) {
+	block.ChainHalvingIntervals[3] = 2
+	defer delete(block.ChainHalvingIntervals, 3)
+	l := New(3)
+	cases := map[uint64]uint64{
+		0:              block.RewardBase,
+		1:              block.RewardBase,
+		2:              block.RewardBase / 2,
+		5:              block.RewardBase / 4,
+		2 * 64:         0,
+		math.MaxUint64: 0,
+	}
+	for height, want := range cases {
+		if subsidy := l.SubsidyAt(height); subsidy != want {
+			t.Errorf("Subsidy at height %d should be %d, got %d", height, want, subsidy)
+		}
+	}
+
+	miner := account.NewPrivate()
+	if err := l.Init(16, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	mine(t, l, miner)
+	next := l.Next()
+	reward, _ := block.BlockReward(l.Chain, 0, next.Complexity, nil)
+	if err := l.Append(block.Find(next.Append(transaction.NewCoinbase(l.Chain, miner, reward)))); err == nil {
+		t.Error("Block after a halving should not claim the full subsidy")
+	}
+	mine(t, l, miner)
+	if _, err := l.Verify(); err != nil {
+		t.Error("Chain with halved rewards should verify:", err)
+	}
+}
+
+func TestScheme(t *testing.T) {
+	l := New(1)
+	creator := account.NewPrivateEd25519()
+	if err := l.Init(0, creator); err != nil {
+		t.Fatal("Could not init Ed25519 ledger:", err)
+	}
+	mixed := block.NextWithHistory(l.Blocks).Append(transaction.NewCoinbase(l.Chain, account.NewPrivate(), 0))
+	if err := l.Append(block.Find(mixed)); err == nil {
+		t.Error("Ed25519 chain should reject P-256 keys")
+	}
+	next := block.NextWithHistory(l.Blocks).Append(transaction.NewCoinbase(l.Chain, account.NewPrivateEd25519(), 0))
+	if err := l.Append(block.Find(next)); err != nil {
+		t.Error("Ed25519 chain should accept Ed25519 keys:", err)
+	}
+	if _, err := l.Verify(); err != nil {
+		t.Error("Ed25519 chain should verify:", err)
+	}
+}
+
+func TestNonCompliant(t *testing.T) {
+	l := New(1)
+	miner := account.NewPrivate()
+	if err := l.Init(16*16*4, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	for i := 0; i < 3; i++ {
+		mine(t, l, miner)
+	}
+	if failed := l.NonCompliant(); len(failed) != 0 {
+		t.Errorf("Mined blocks should be compliant, got %v", failed)
+	}
+	tampered := l.Blocks[2]
+	for tampered.Compliant() {
+		tampered.Variance++
+	}
+	l.Blocks[2] = tampered
+	if failed := l.NonCompliant(); len(failed) != 1 || failed[0] != 2 {
+		t.Errorf("Tampered block 2 should be flagged, got %v", failed)
+	}
+}
+
+func TestChainParams(t *testing.T) {
+	schedule := transaction.FeeSchedule{Base: 2 * transaction.BaseFee, SizeScalar: transaction.FeeSizeScalar, Epoch: transaction.FeeEpoch}
+	transaction.FeeSchedules[11] = schedule
+	defer delete(transaction.FeeSchedules, 11)
+	l := New(11)
+	if err := l.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	var buffer bytes.Buffer
+	l.WriteTo(&buffer)
+	delete(transaction.FeeSchedules, 11)
+	if err := New(0).ReadFrom(bytes.NewReader(buffer.Bytes())); err != nil {
+		t.Fatal("Could not read ledger:", err)
+	}
+	if transaction.FeeScheduleFor(11) != schedule {
+		t.Error("Reading a ledger should register its fee schedule")
+	}
+	transaction.FeeSchedules[11] = transaction.DefaultFeeSchedule
+	if err := New(0).ReadFrom(bytes.NewReader(buffer.Bytes())); err == nil {
+		t.Error("Reading a ledger with a conflicting fee schedule should fail")
+	}
+
+	plain := New(1)
+	if err := plain.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	buffer.Reset()
+	plain.WriteTo(&buffer)
+	data := buffer.Bytes()
+	paramsSize := binary.LittleEndian.Uint64(data[countOffset+8:])
+	v1 := append(append([]byte{}, data[:countOffset+8]...), data[countOffset+16+int(paramsSize):]...)
+	binary.LittleEndian.PutUint64(v1, fileMagicV1)
+	if err := New(0).ReadFrom(bytes.NewReader(v1)); err != nil {
+		t.Error("Ledger files without chain parameters should still be read:", err)
+	}
+}
+
+func TestReadFromTruncated(t *testing.T) {
+	l := New(1)
+	miner := account.NewPrivate()
+	if err := l.Init(16, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	mine(t, l, miner)
+	buffer := bytes.NewBuffer([]byte{})
+	l.WriteTo(buffer)
+	data := buffer.Bytes()
+	err := New(0).ReadFrom(bytes.NewReader(data[:len(data)-10]))
+	if err == nil || !strings.Contains(err.Error(), "block 1") {
+		t.Error("ReadFrom should report the truncated block, got", err)
+	}
+	for _, cut := range []int{0, 4, 12, len(data) / 2} {
+		err := New(0).ReadFrom(bytes.NewReader(data[:cut]))
+		if err == nil || !strings.Contains(err.Error(), "Ledger file truncated") {
+			t.Errorf("ReadFrom should report a ledger file truncated to %d bytes, got %v", cut, err)
+		}
+	}
+	binary.LittleEndian.PutUint64(data[countOffset:], 1<<40)
+	if err := New(0).ReadFrom(bytes.NewReader(data)); err == nil || !strings.Contains(err.Error(), "can not fit") {
+		t.Error("ReadFrom should reject absurd block counts, got", err)
+	}
+}
+
+func TestTip(t *testing.T) {
+	l := New(1)
+	if _, ok := l.Tip(); ok {
+		t.Error("Empty ledger should not have a tip")
+	}
+	if err := l.Init(16, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	tip, ok := l.Tip()
+	if !ok || !bytes.Equal(tip.Hash(), l.Blocks[0].Hash()) {
+		t.Fatal("Tip should return the last block")
+	}
+	tip.Data[0].Amount++
+	tip.Data[0].Recipient[0]++
+	tip.PreviousHash[0]++
+	if bytes.Equal(tip.Hash(), l.Blocks[0].Hash()) {
+		t.Error("Mutated tip should differ from stored block")
+	}
+	if index, err := l.Verify(); err != nil {
+		t.Errorf("Mutating the tip should not affect the ledger, failed at %d: %v", index, err)
+	}
+}
+
+func TestAppendStale(t *testing.T) {
+	l := New(1)
+	if err := l.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	mine(t, l, account.NewPrivate())
+	mine(t, l, account.NewPrivate())
+
+	fork := block.NextWithHistory(l.Blocks[:1])
+	fork = block.Find(fork.Append(transaction.NewCoinbase(l.Chain, account.NewPrivate(), 0)))
+	orphan := fork
+	orphan.PreviousHash = make([]byte, block.HashSize)
+
+	var consulted []block.Block
+	for _, b := range []block.Block{l.Blocks[1], fork, orphan} {
+		if err := l.Append(b); errors.Cause(err) != ErrStaleBlock {
+			t.Errorf("Block %d below tip should be stale without fork handler, got %v", b.Index, err)
+		}
+	}
+	l.ForkHandler = func(b block.Block) error {
+		consulted = append(consulted, b)
+		return nil
+	}
+	if err := l.Append(l.Blocks[1]); errors.Cause(err) != ErrStaleBlock {
+		t.Error("Duplicate block should be stale, got", err)
+	}
+	if err := l.Append(orphan); errors.Cause(err) != ErrStaleBlock {
+		t.Error("Block not connecting to the chain should be stale, got", err)
+	}
+	if err := l.Append(fork); err != nil {
+		t.Error("Fork candidate should be handed to the fork handler, got", err)
+	}
+	if len(consulted) != 1 || !bytes.Equal(consulted[0].Hash(), fork.Hash()) {
+		t.Errorf("Fork handler should only be consulted for the fork candidate, got %d calls", len(consulted))
+	}
+	if l.Size() != 3 {
+		t.Errorf("Stale blocks should not extend the chain, got size %d", l.Size())
+	}
+}
+
+func TestBurnSupply(t *testing.T) {
+	l := New(1)
+	creator := account.NewPrivate()
+	if err := l.Init(16, creator); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	item := l.Addresses.Get(account.AddressTreeItem{Address: creator.Address()}).(account.AddressTreeItem)
+	item.Funds += 20000
+	l.Addresses.ReplaceOrInsert(item)
+	supply := l.TotalSupply()
+	fee := transaction.EstimateFee(0, 17)
+	mine(t, l, account.NewPrivate(), transaction.NewBurn(l.Chain, 3000, fee, 1, creator))
+	reward, _ := block.BlockReward(l.Chain, 0, 17, nil)
+	if l.Burned() != 3000 || l.TotalSupply() != supply+reward-3000 {
+		t.Errorf("TotalSupply should drop by the burned amount, got %d burned and supply %d", l.Burned(), l.TotalSupply())
+	}
+}
+
+func TestBlockTx(t *testing.T) {
+	l := New(1)
+	if err := l.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	accounts := make([]transaction.TX, 23)
+	for i := range accounts {
+		accounts[i] = transaction.NewAccount(l.Chain, account.NewPrivate())
+	}
+	b := mine(t, l, account.NewPrivate(), accounts...)
+	var paged []transaction.TX
+	for offset := 0; offset < len(b.Data); offset += 5 {
+		window, err := l.BlockTx(b.Index, offset, 5)
+		if err != nil {
+			t.Fatal("Could not page transactions:", err)
+		}
+		paged = append(paged, window...)
+	}
+	if len(paged) != len(b.Data) {
+		t.Fatalf("Windows should add up to %d transactions, got %d", len(b.Data), len(paged))
+	}
+	for i, tx := range paged {
+		if !bytes.Equal(tx.Hash(), b.Data[i].Hash()) {
+			t.Errorf("Transaction %d should match the block", i)
+		}
+	}
+	if window, err := l.BlockTx(b.Index, len(b.Data), 5); err != nil || len(window) != 0 {
+		t.Error("Window at the end of the block should be empty, got", err)
+	}
+	for _, args := range [][3]int{{2, 0, 5}, {1, -1, 5}, {1, len(b.Data) + 1, 5}, {1, 0, 0}} {
+		if _, err := l.BlockTx(uint64(args[0]), args[1], args[2]); err == nil {
+			t.Errorf("BlockTx(%d, %d, %d) should fail", args[0], args[1], args[2])
+		}
+	}
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	source := New(1)
+	if err := source.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	for i := 0; i < 4; i++ {
+		mine(t, source, account.NewPrivate())
+	}
+
+	l := New(1)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for _, b := range source.Blocks {
+				l.Append(b)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 64; i++ {
+				l.Tip()
+				l.Size()
+				l.Stats()
+				l.TotalSupply()
+				l.WriteTo(&bytes.Buffer{})
+			}
+		}()
+	}
+	wg.Wait()
+	if l.Size() != source.Size() || l.TotalSupply() != source.TotalSupply() {
+		t.Errorf("Concurrent appends should build the full chain, got height %d", l.Size())
+	}
+}
+
+func TestBalance(t *testing.T) {
+	creator := account.NewPrivate()
+	l := New(1)
+	if err := l.Init(0, creator); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	miner := account.NewPrivate()
+	mine(t, l, miner)
+	genesis, _ := l.Blocks[0].Coinbase()
+	if funds, ok := l.Balance(creator.Address()); !ok || funds != genesis.Amount {
+		t.Errorf("Balance of the creator should be %d, got %d", genesis.Amount, funds)
+	}
+	if _, ok := l.Balance(account.NewPrivate().Address()); ok {
+		t.Error("Balance should report unknown accounts")
+	}
+	accounts := l.Accounts()
+	if len(accounts) != 2 || bytes.Compare(accounts[0].Address, accounts[1].Address) >= 0 {
+		t.Fatalf("Accounts should list both accounts sorted by address, got %d", len(accounts))
+	}
+	for _, item := range accounts {
+		if funds, _ := l.Balance(item.Address); funds != item.Funds {
+			t.Error("Accounts should match Balance")
+		}
+	}
+}
+
+func TestReadFast(t *testing.T) {
+	creator := account.NewPrivate()
+	l := New(1)
+	if err := l.Init(0, creator); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	mine(t, l, account.NewPrivate(), transaction.NewAccount(l.Chain, account.NewPrivate()))
+	var snapshot bytes.Buffer
+	if err := l.WriteSnapshot(&snapshot); err != nil {
+		t.Fatal("Could not write snapshot:", err)
+	}
+	mine(t, l, account.NewPrivate(), transaction.NewAccount(l.Chain, account.NewPrivate()))
+	var buffer bytes.Buffer
+	l.WriteTo(&buffer)
+
+	replayed, fast := New(0), New(0)
+	if err := replayed.ReadFrom(bytes.NewReader(buffer.Bytes())); err != nil {
+		t.Fatal("Could not replay ledger:", err)
+	}
+	if err := fast.ReadFast(bytes.NewReader(buffer.Bytes()), bytes.NewReader(snapshot.Bytes())); err != nil {
+		t.Fatal("Could not read ledger from snapshot:", err)
+	}
+	expected, got := replayed.Accounts(), fast.Accounts()
+	if len(expected) != len(got) || fast.Size() != replayed.Size() {
+		t.Fatalf("Fast path should restore %d accounts, got %d", len(expected), len(got))
+	}
+	for i := range expected {
+		if !bytes.Equal(expected[i].Address, got[i].Address) || expected[i].Funds != got[i].Funds || expected[i].Nonce != got[i].Nonce ||
+			!bytes.Equal(expected[i].Account.PublicKeyBytes(), got[i].Account.PublicKeyBytes()) {
+			t.Errorf("Account %d should match the full replay", i)
+		}
+	}
+	if replayed.Stats() != fast.Stats() {
+		t.Error("Fast path should restore the address history")
+	}
+
+	other := New(1)
+	if err := other.Init(0, creator); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	snapshot.Reset()
+	other.WriteSnapshot(&snapshot)
+	if err := New(0).ReadFast(bytes.NewReader(buffer.Bytes()), bytes.NewReader(snapshot.Bytes())); err == nil {
+		t.Error("ReadFast should reject a snapshot of a different tip")
+	}
+}
+
+func TestReorg(t *testing.T) {
+	creator := account.NewPrivate()
+	l := New(1)
+	if err := l.Init(0, creator); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	short := New(1)
+	if err := short.Append(l.Blocks[0]); err != nil {
+		t.Fatal("Could not append genesis:", err)
+	}
+	mine(t, l, account.NewPrivate())
+	mine(t, l, account.NewPrivate())
+	long := New(1)
+	if err := long.Append(l.Blocks[0]); err != nil {
+		t.Fatal("Could not append genesis:", err)
+	}
+	mine(t, short, account.NewPrivate())
+	winner := account.NewPrivate()
+	for i := 0; i < 3; i++ {
+		mine(t, long, winner)
+	}
+
+	for _, b := range short.Blocks[1:] {
+		if err := l.TryAppend(b); err != nil {
+			t.Fatal("Fork block should be accepted:", err)
+		}
+	}
+	if err := l.Reorg(); err != nil || l.Size() != 3 {
+		t.Fatalf("Shorter fork should lose, got height %d (%v)", l.Size(), err)
+	}
+	orphan := long.Blocks[2]
+	if err := l.TryAppend(orphan); err == nil {
+		t.Error("Block with unknown parent should be rejected")
+	}
+	for _, b := range long.Blocks[1:] {
+		if err := l.TryAppend(b); err != nil {
+			t.Fatal("Fork block should be accepted:", err)
+		}
+	}
+	if tips := l.Tips(); len(tips) != 3 {
+		t.Fatalf("Ledger should track 3 tips, got %d", len(tips))
+	}
+	if err := l.Reorg(); err != nil {
+		t.Fatal("Reorg should switch to the longer fork:", err)
+	}
+	tip, _ := l.Tip()
+	if l.Size() != 4 || !bytes.Equal(tip.Hash(), long.Blocks[3].Hash()) {
+		t.Fatalf("Ledger should follow the longer fork, got height %d", l.Size())
+	}
+	if funds, _ := l.Balance(winner.Address()); funds != long.TotalSupply()-l.Blocks[0].Data[0].Amount {
+		t.Errorf("Addresses should be recomputed from the fork point, got %d", funds)
+	}
+	if tips := l.Tips(); len(tips) != 3 {
+		t.Errorf("Replaced blocks should be kept as fork, got %d tips", len(tips))
+	}
+	if index, err := l.Verify(); err != nil {
+		t.Errorf("Reorganized chain should verify, failed at %d: %v", index, err)
+	}
+}
+
+func TestForkLimits(t *testing.T) {
+	defer func(forks int, depth uint64) { MaxForks, ForkDepth = forks, depth }(MaxForks, ForkDepth)
+	MaxForks, ForkDepth = 1, 1
+	l := New(1)
+	if err := l.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	mine(t, l, account.NewPrivate())
+	var forks [2]*Ledger
+	for i := range forks {
+		forks[i] = New(1)
+		if err := forks[i].Append(l.Blocks[0]); err != nil {
+			t.Fatal("Could not append genesis:", err)
+		}
+		mine(t, forks[i], account.NewPrivate())
+		mine(t, forks[i], account.NewPrivate())
+	}
+
+	free := block.NextWithHistory(l.Blocks[:1])
+	free.Complexity = 0
+	free = block.Find(free.Append(transaction.NewCoinbase(l.Chain, account.NewPrivate(), 0)))
+	if err := l.TryAppend(free); err == nil {
+		t.Error("Fork block below the expected complexity should be rejected")
+	}
+	if err := l.TryAppend(forks[0].Blocks[1]); err != nil {
+		t.Fatal("Fork block should be accepted:", err)
+	}
+	if err := l.TryAppend(forks[1].Blocks[1]); err == nil {
+		t.Error("Fork blocks beyond MaxForks should be rejected")
+	}
+	mine(t, l, account.NewPrivate())
+	mine(t, l, account.NewPrivate())
+	if err := l.TryAppend(forks[0].Blocks[2]); err == nil {
+		t.Error("Fork blocks more than ForkDepth below the tip should be rejected")
+	}
+	if tips := l.Tips(); len(tips) != 1 {
+		t.Errorf("Forks below the tip should be evicted, got %d tips", len(tips))
+	}
+}
+
+func TestReorgDisplaced(t *testing.T) {
+	block.ChainRewardBases[3] = 1 << 20
+	defer delete(block.ChainRewardBases, 3)
+	creator, recipient, other := account.NewPrivate(), account.NewPrivate(), account.NewPrivate()
+	l := New(3)
+	if err := l.Init(16, creator); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	mine(t, l, creator, transaction.NewAccount(l.Chain, recipient))
+	long := New(3)
+	for _, b := range l.Blocks {
+		if err := long.Append(b); err != nil {
+			t.Fatal("Could not append shared block:", err)
+		}
+	}
+	fee := transaction.EstimateFee(0, block.ExpectedComplexity(l.Blocks))
+	first := transaction.NewTransfer(l.Chain, 1, fee, 1, creator, recipient)
+	second := transaction.NewTransfer(l.Chain, 1, fee, 2, creator, recipient)
+	announce := transaction.NewAccount(l.Chain, other)
+	mine(t, l, account.NewPrivate(), first, second, announce)
+	mine(t, long, account.NewPrivate(), announce)
+	mine(t, long, account.NewPrivate())
+
+	for _, b := range long.Blocks[2:] {
+		if err := l.TryAppend(b); err != nil {
+			t.Fatal("Fork block should be accepted:", err)
+		}
+	}
+	if err := l.Reorg(); err != nil || l.Size() != 4 {
+		t.Fatalf("Reorg should switch to the longer fork, got height %d (%v)", l.Size(), err)
+	}
+	displaced := l.TakeDisplaced()
+	if len(displaced) != 2 || !bytes.Equal(displaced[0].Hash(), first.Hash()) || !bytes.Equal(displaced[1].Hash(), second.Hash()) {
+		t.Fatalf("Reorg should displace both transfers but not the coinbase or included transactions, got %d", len(displaced))
+	}
+	if len(l.TakeDisplaced()) != 0 {
+		t.Error("TakeDisplaced should forget returned transactions")
+	}
+
+	pool := mempool.New(l.Addresses, block.ExpectedComplexity(l.Blocks))
+	if admitted := pool.Reinject([]transaction.TX{second, first}); admitted != 2 {
+		t.Fatalf("Pool should admit both displaced transfers, got %d", admitted)
+	}
+	taken := pool.Take(block.MaxBlockBytes, 16)
+	if len(taken) != 2 || taken[0].Nonce != 1 || taken[1].Nonce != 2 {
+		t.Errorf("Reinjected transfers should be taken in nonce order, got %d", len(taken))
+	}
+}
+
+func TestGetBlock(t *testing.T) {
+	l := New(1)
+	if err := l.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	mine(t, l, account.NewPrivate())
+	tip := mine(t, l, account.NewPrivate())
+	for i, expected := range []block.Block{l.Blocks[0], tip} {
+		b, ok := l.GetBlockByHash(expected.Hash())
+		if !ok || !bytes.Equal(b.Hash(), expected.Hash()) {
+			t.Errorf("GetBlockByHash should find block %d", expected.Index)
+		}
+		if b, ok := l.GetBlockByIndex(expected.Index); !ok || !bytes.Equal(b.Hash(), expected.Hash()) {
+			t.Errorf("GetBlockByIndex should find block %d", i)
+		}
+	}
+	if _, ok := l.GetBlockByHash(make([]byte, block.HashSize)); ok {
+		t.Error("GetBlockByHash should not find unknown hashes")
+	}
+	if _, ok := l.GetBlockByIndex(3); ok {
+		t.Error("GetBlockByIndex should not find blocks beyond the tip")
+	}
+
+	var buffer bytes.Buffer
+	l.WriteTo(&buffer)
+	for _, read := range []func(*Ledger) error{
+		func(r *Ledger) error { return r.ReadFrom(bytes.NewReader(buffer.Bytes())) },
+		func(r *Ledger) error { return r.ReadUnverified(bytes.NewReader(buffer.Bytes())) },
+	} {
+		reloaded := New(0)
+		if err := read(reloaded); err != nil {
+			t.Fatal("Could not read ledger:", err)
+		}
+		if b, ok := reloaded.GetBlockByHash(tip.Hash()); !ok || b.Index != tip.Index {
+			t.Error("Reading a ledger should index its blocks")
+		}
+	}
+
+	fork := New(1)
+	fork.Append(l.Blocks[0])
+	for i := 0; i < 3; i++ {
+		mine(t, fork, account.NewPrivate())
+	}
+	for _, b := range fork.Blocks[1:] {
+		if err := l.TryAppend(b); err != nil {
+			t.Fatal("Fork block should be accepted:", err)
+		}
+	}
+	if err := l.Reorg(); err != nil {
+		t.Fatal("Reorg should switch to the longer fork:", err)
+	}
+	if _, ok := l.GetBlockByHash(tip.Hash()); ok {
+		t.Error("Replaced blocks should no longer be indexed")
+	}
+	if b, ok := l.GetBlockByHash(fork.Blocks[3].Hash()); !ok || b.Index != 3 {
+		t.Error("Blocks of the new chain should be indexed after a reorg")
+	}
+}
+
+func TestFindTransaction(t *testing.T) {
+	creator, recipient := account.NewPrivate(), account.NewPrivate()
+	l := New(1)
+	if err := l.Init(0, creator); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	announce := transaction.NewAccount(l.Chain, recipient)
+	mine(t, l, creator, announce)
+	for _, acc := range []*account.Private{creator, recipient} {
+		item := l.Addresses.Get(account.AddressTreeItem{Address: acc.Address()}).(account.AddressTreeItem)
+		item.Funds += 20000
+		l.Addresses.ReplaceOrInsert(item)
+	}
+	fee := transaction.EstimateFee(0, block.ExpectedComplexity(l.Blocks))
+	first := transaction.NewTransfer(l.Chain, 10, fee, 1, creator, recipient)
+	mine(t, l, creator, first)
+	fee = transaction.EstimateFee(0, block.ExpectedComplexity(l.Blocks))
+	second := transaction.NewTransfer(l.Chain, 5, fee, 1, recipient, creator)
+	mine(t, l, account.NewPrivate(), second)
+
+	for index, tx := range map[uint64]transaction.TX{1: announce, 2: first, 3: second} {
+		found, at, ok := l.FindTransaction(tx.Hash())
+		if !ok || at != index || !bytes.Equal(found.Hash(), tx.Hash()) {
+			t.Errorf("FindTransaction should locate the transaction in block %d, got %d", index, at)
+		}
+	}
+	if _, _, ok := l.FindTransaction(make([]byte, transaction.AddressSize)); ok {
+		t.Error("FindTransaction should not find unknown hashes")
+	}
+	txs := l.TransactionsFor(recipient.Address())
+	if len(txs) != 3 {
+		t.Fatalf("TransactionsFor should return 3 transactions, got %d", len(txs))
+	}
+	for i, expected := range []transaction.TX{announce, first, second} {
+		if !bytes.Equal(txs[i].Hash(), expected.Hash()) {
+			t.Errorf("Transaction %d should be returned in chain order", i)
+		}
+	}
+}
+
+func TestIterate(t *testing.T) {
+	l := New(1)
+	miner := account.NewPrivate()
+	if err := l.Init(0, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	announce := transaction.NewAccount(l.Chain, account.NewPrivate())
+	mine(t, l, miner)
+	mine(t, l, miner, announce)
+	mine(t, l, miner)
+
+	var indices []uint64
+	l.Iterate(func(b block.Block) bool {
+		indices = append(indices, b.Index)
+		return true
+	})
+	if !reflect.DeepEqual(indices, []uint64{0, 1, 2, 3}) {
+		t.Errorf("Iterate should visit all blocks in chain order, got %v", indices)
+	}
+	visited := 0
+	l.Iterate(func(b block.Block) bool {
+		visited++
+		return b.Index < 1
+	})
+	if visited != 2 {
+		t.Errorf("Iterate should stop when fn returns false, visited %d blocks", visited)
+	}
+
+	var found uint64
+	count := 0
+	l.IterateTransactions(func(b block.Block, tx transaction.TX) bool {
+		count++
+		if bytes.Equal(tx.Hash(), announce.Hash()) {
+			found = b.Index
+			return false
+		}
+		return true
+	})
+	if found != 2 || count != 4 {
+		t.Errorf("IterateTransactions should stop at the announcement in block 2, got block %d after %d transactions", found, count)
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	l := New(1)
+	creator, recipient := account.NewPrivate(), account.NewPrivate()
+	if err := l.Init(0, creator); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	mine(t, l, creator, transaction.NewAccount(l.Chain, recipient))
+	item := l.Addresses.Get(account.AddressTreeItem{Address: creator.Address()}).(account.AddressTreeItem)
+	item.Funds = 20000
+	l.Addresses.ReplaceOrInsert(item)
+	fee := transaction.EstimateFee(0, block.ExpectedComplexity(l.Blocks))
+
+	if err := l.Simulate(transaction.NewTransfer(l.Chain, 10, fee, 1, creator, recipient)); err != nil {
+		t.Error("Funded transfer should pass simulation:", err)
+	}
+	if err := l.Simulate(transaction.NewTransfer(l.Chain, 20000, fee, 1, creator, recipient)); !errors.Is(err, block.ErrInsufficientFunds) {
+		t.Error("Underfunded transfer should fail with ErrInsufficientFunds, got", err)
+	}
+	forged := transaction.NewTransfer(l.Chain, 10, fee, 1, creator, recipient)
+	forged.Proof = transaction.NewTransfer(l.Chain, 11, fee, 1, creator, recipient).Proof
+	if err := l.Simulate(forged); !errors.Is(err, transaction.ErrBadProof) {
+		t.Error("Transfer with bad signature should fail with ErrBadProof, got", err)
+	}
+	if balance, _ := l.Balance(creator.Address()); balance != 20000 {
+		t.Errorf("Simulate should not modify the ledger, balance is %d", balance)
+	}
+}
+
+func TestStorage(t *testing.T) {
+	store := storage.NewMemory()
+	creator := account.NewPrivate()
+	sealed, err := container.New([]byte("passphrase"), creator)
+	if err != nil {
+		t.Fatal("Could not seal account:", err)
+	}
+	if err := container.WriteToStorage(sealed, store, "account.json"); err != nil {
+		t.Fatal("Could not store account:", err)
+	}
+	loaded, err := container.ReadFromStorage(store, "account.json")
+	if err != nil {
+		t.Fatal("Could not load account:", err)
+	}
+	unlocked, err := loaded.Unlock([]byte("passphrase"))
+	if err != nil || !account.Equal(unlocked, creator) {
+		t.Fatal("Stored account should unlock to the original key:", err)
+	}
+
+	l := New(1)
+	if err := l.Init(0, unlocked); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	mine(t, l, unlocked)
+	if err := l.WriteToStorage(store, "ledger"); err != nil {
+		t.Fatal("Could not store ledger:", err)
+	}
+	restored := New(0)
+	if err := restored.ReadFromStorage(store, "ledger"); err != nil {
+		t.Fatal("Could not load ledger:", err)
+	}
+	if restored.Chain != l.Chain || restored.Size() != l.Size() || !bytes.Equal(tipHash(restored), tipHash(l)) {
+		t.Error("Stored ledger should round-trip")
+	}
+	next := block.NextWithHistory(restored.Blocks)
+	next = next.Append(transaction.NewCoinbase(restored.Chain, unlocked, 0))
+	for !next.Compliant() {
+		next.Variance++
+	}
+	if err := restored.AppendToStorage(store, "ledger", next); err != nil {
+		t.Fatal("Could not append block to stored ledger:", err)
+	}
+	appended := New(0)
+	if err := appended.ReadFromStorage(store, "ledger"); err != nil || appended.Size() != 3 {
+		t.Errorf("Appended block should be stored, got height %d (%v)", appended.Size(), err)
+	}
+
+	keystore := container.OpenStorage(store, "accounts")
+	if _, err := keystore.Save(creator, []byte("passphrase")); err != nil {
+		t.Fatal("Could not save account to keystore:", err)
+	}
+	if listed := keystore.List(); len(listed) != 1 || listed[0] != creator.String() {
+		t.Errorf("Keystore should list the stored account, got %v", listed)
+	}
+	if err := New(0).ReadFromStorage(store, "missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Reading an unknown ledger should fail with os.ErrNotExist, got", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	l := New(1)
+	if err := l.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	announce := transaction.NewAccount(l.Chain, account.NewPrivate())
+	mine(t, l, account.NewPrivate(), announce)
+	mine(t, l, account.NewPrivate(), transaction.NewAccount(l.Chain, account.NewPrivate()))
+	mine(t, l, account.NewPrivate())
+	accounts, supply := len(l.Accounts()), l.TotalSupply()
+
+	if err := l.Prune(2); err != nil {
+		t.Fatal("Could not prune ledger:", err)
+	}
+	height, addresses := l.Checkpoint()
+	if height != 2 || l.Size() != 4 {
+		t.Fatalf("Blocks below height 2 should be pruned, got checkpoint %d", height)
+	}
+	if addresses.Len() != 3 {
+		t.Errorf("Checkpoint should hold the 3 accounts known after block 1, got %d", addresses.Len())
+	}
+	for i, b := range l.Blocks {
+		if pruned := len(b.Data) == 0; pruned != (uint64(i) < height) {
+			t.Errorf("Block %d should be pruned: %v", i, !pruned)
+		}
+	}
+	if _, _, ok := l.FindTransaction(announce.Hash()); ok {
+		t.Error("Pruned transactions should no longer be found")
+	}
+	if index, err := l.Verify(); err != nil {
+		t.Fatalf("Pruned ledger should verify, failed at block %d: %v", index, err)
+	}
+	if len(l.Accounts()) != accounts || l.TotalSupply() != supply {
+		t.Error("Verifying a pruned ledger should restore the same address tree")
+	}
+	mine(t, l, account.NewPrivate())
+	if err := l.Prune(0); err != nil {
+		t.Fatal("Could not prune ledger:", err)
+	}
+	if height, _ := l.Checkpoint(); height != 4 {
+		t.Errorf("Pruning should keep at least the tip, got checkpoint %d", height)
+	}
+	if index, err := l.Verify(); err != nil {
+		t.Errorf("Pruned ledger should verify, failed at block %d: %v", index, err)
+	}
+}
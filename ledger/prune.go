@@ -0,0 +1,113 @@
+package ledger
+
+import (
+	"encoding/hex"
+
+	"github.com/google/btree"
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger/account"
+	"github.com/lnsp/txledger/ledger/block"
+	"github.com/lnsp/txledger/ledger/transaction"
+)
+
+// checkpoint holds what is needed to continue a chain whose blocks below height are pruned.
+type checkpoint struct {
+	height    uint64
+	hashes    [][]byte
+	addresses *btree.BTree
+	burned    uint64
+}
+
+// Prune drops the transactions of all but the most recent keepLast blocks, keeping their headers.
+// At least the tip is kept. The hashes of pruned blocks and the address tree below the remaining
+// blocks are recorded, so the chain can still be verified and extended. Pruned ledgers can not
+// serve historical transactions and can not be replayed from a file written by WriteTo.
+func (l *Ledger) Prune(keepLast uint64) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if keepLast < 1 {
+		keepLast = 1
+	}
+	if l.size() <= keepLast || l.size()-keepLast <= l.checkpoint.height {
+		return nil
+	}
+	height := l.size() - keepLast
+	addresses := l.checkpoint.addresses
+	if addresses == nil {
+		addresses = account.NewAddressTree()
+	}
+	for i := l.checkpoint.height; i < height; i++ {
+		var err error
+		if addresses, err = l.Blocks[i].Verify(addresses); err != nil {
+			return errors.Wrapf(err, "Could not replay block %d", i)
+		}
+	}
+	for i := l.checkpoint.height; i < height; i++ {
+		b := l.Blocks[i]
+		l.checkpoint.hashes = append(l.checkpoint.hashes, b.Hash())
+		for offset, tx := range b.Data {
+			if tx.Type == transaction.TypeBurn {
+				l.checkpoint.burned += tx.Amount
+			}
+			delete(l.txs, hex.EncodeToString(tx.Hash()))
+			l.dropAddressTx(tx.Sender, txLocation{block: int(i), offset: offset})
+			l.dropAddressTx(tx.Recipient, txLocation{block: int(i), offset: offset})
+		}
+		b.Data = []transaction.TX{}
+		l.Blocks[i] = b
+	}
+	l.checkpoint.height = height
+	l.checkpoint.addresses = addresses
+	return nil
+}
+
+// Checkpoint returns the height below which blocks are pruned and a copy of the address tree
+// after the last pruned block. Without pruning the height is zero and the tree empty.
+func (l *Ledger) Checkpoint() (uint64, *btree.BTree) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.checkpoint.addresses == nil {
+		return 0, account.NewAddressTree()
+	}
+	return l.checkpoint.height, l.checkpoint.addresses.Clone()
+}
+
+// blockHash returns the hash of block i, which is recorded for pruned blocks.
+func (l *Ledger) blockHash(i uint64) []byte {
+	if i < l.checkpoint.height {
+		return l.checkpoint.hashes[i]
+	}
+	return l.Blocks[i].Hash()
+}
+
+// fromCheckpoint creates an unlocked copy of the ledger truncated to the checkpoint,
+// ready to replay the blocks above it.
+func (l *Ledger) fromCheckpoint() *Ledger {
+	replay := New(l.Chain)
+	if l.checkpoint.height == 0 {
+		return replay
+	}
+	replay.checkpoint = l.checkpoint
+	replay.Blocks = append([]block.Block{}, l.Blocks[:l.checkpoint.height]...)
+	replay.Addresses = l.checkpoint.addresses.Clone()
+	replay.AddressHistory = append([]uint64{}, l.AddressHistory[:l.checkpoint.height]...)
+	replay.hashes = make(map[string]int, len(l.checkpoint.hashes))
+	for i, hash := range l.checkpoint.hashes {
+		replay.hashes[hex.EncodeToString(hash)] = i
+	}
+	replay.txs = make(map[string]txLocation)
+	replay.addressTxs = make(map[string][]txLocation)
+	return replay
+}
+
+// dropAddressTx removes the location from the address's transaction index.
+func (l *Ledger) dropAddressTx(address []byte, location txLocation) {
+	locations := l.addressTxs[string(address)]
+	for i := range locations {
+		if locations[i] == location {
+			l.addressTxs[string(address)] = append(locations[:i], locations[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package ledger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/google/btree"
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger/account"
+	"github.com/lnsp/txledger/ledger/block"
+	"github.com/lnsp/txledger/ledger/transaction"
+)
+
+// snapshotItem is the fixed-size encoding of an address tree item.
+type snapshotItem struct {
+	Address [transaction.AddressSize]byte
+	// KeySize is the length of the key stored in PublicKey, shorter keys are zero-padded.
+	KeySize   uint8
+	PublicKey [account.PublicKeySize]byte
+	Funds     uint64
+	Nonce     uint64
+}
+
+// WriteSnapshot writes the address tree and address history together with the height
+// and hash of the tip they belong to.
+func (l *Ledger) WriteSnapshot(w io.Writer) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	tip, ok := l.last()
+	if !ok {
+		return errors.New("Ledger is empty")
+	}
+	header := []interface{}{l.Chain, l.size(), tip.Hash(), uint64(l.Addresses.Len()), l.AddressHistory}
+	for _, field := range header {
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return errors.Wrap(err, "Could not write snapshot header")
+		}
+	}
+	var err error
+	l.Addresses.Ascend(func(i btree.Item) bool {
+		item := i.(account.AddressTreeItem)
+		encoded := snapshotItem{Funds: item.Funds, Nonce: item.Nonce}
+		copy(encoded.Address[:], item.Address)
+		encoded.KeySize = uint8(copy(encoded.PublicKey[:], item.Account.PublicKeyBytes()))
+		err = binary.Write(w, binary.LittleEndian, &encoded)
+		return err == nil
+	})
+	return errors.Wrap(err, "Could not write snapshot item")
+}
+
+// ReadFast reads the chain like ReadFrom, but restores the address tree from the snapshot
+// instead of replaying the blocks it covers. Only blocks newer than the snapshot are verified.
+// The snapshot is rejected unless it belongs to the chain and block hash found in the ledger.
+func (l *Ledger) ReadFast(r, snapshot io.Reader) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var chain, height, count uint64
+	tipHash := make([]byte, block.HashSize)
+	for _, field := range []interface{}{&chain, &height, tipHash, &count} {
+		if err := binary.Read(snapshot, binary.LittleEndian, field); err != nil {
+			return errors.Wrap(err, "Could not read snapshot header")
+		}
+	}
+	if height < 1 {
+		return errors.New("Snapshot is empty")
+	}
+	history := make([]uint64, 0)
+	for i := uint64(0); i < height; i++ {
+		var known uint64
+		if err := binary.Read(snapshot, binary.LittleEndian, &known); err != nil {
+			return errors.Wrap(err, "Could not read snapshot address history")
+		}
+		history = append(history, known)
+	}
+	addresses := account.NewAddressTree()
+	for i := uint64(0); i < count; i++ {
+		var encoded snapshotItem
+		if err := binary.Read(snapshot, binary.LittleEndian, &encoded); err != nil {
+			return errors.Wrapf(err, "Could not read snapshot item %d", i)
+		}
+		if int(encoded.KeySize) > len(encoded.PublicKey) {
+			return errors.Errorf("Invalid snapshot item %d", i)
+		}
+		key, err := account.NewPublicE(encoded.PublicKey[:encoded.KeySize])
+		if err != nil {
+			return errors.Wrapf(err, "Invalid snapshot item %d", i)
+		}
+		if !bytes.Equal(key.Address(), encoded.Address[:]) {
+			return errors.Errorf("Snapshot item %d does not match its public key", i)
+		}
+		addresses.ReplaceOrInsert(account.AddressTreeItem{
+			Address: key.Address(),
+			Account: key,
+			Funds:   encoded.Funds,
+			Nonce:   encoded.Nonce,
+		})
+	}
+
+	h, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+	if err := h.params.register(h.chain); err != nil {
+		return err
+	}
+	ledgerChain, size := h.chain, h.size
+	if ledgerChain != chain {
+		return errors.Errorf("Snapshot belongs to chain %d instead of %d", chain, ledgerChain)
+	}
+	l.Chain = chain
+	if size < height {
+		return errors.Errorf("Snapshot height %d exceeds chain height %d", height, size)
+	}
+	l.Blocks = make([]block.Block, 0, height)
+	for i := uint64(0); i < height; i++ {
+		b, err := readBlock(r, h, i)
+		if err != nil {
+			return err
+		}
+		l.pushBlock(b)
+	}
+	if tip, _ := l.last(); !bytes.Equal(tip.Hash(), tipHash) {
+		return errors.Errorf("Snapshot does not match block %d", height-1)
+	}
+	l.Addresses = addresses
+	l.AddressHistory = history
+	l.forks = nil
+	l.displaced = nil
+	for i := height; i < size; i++ {
+		b, err := readBlock(r, h, i)
+		if err != nil {
+			return err
+		}
+		if err := l.appendBlock(b); err != nil {
+			return errors.Wrapf(err, "Could not read block %d", i)
+		}
+	}
+	return nil
+}
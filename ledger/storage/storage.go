@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Storage stores named blobs such as the ledger, its snapshot and account containers.
+// Names are slash-separated, the part before the last slash groups blobs into a directory.
+// Read, Open and Remove return an error matching os.ErrNotExist if the name is unknown.
+// Data written to the writer returned by Write replaces the blob once the writer is closed.
+type Storage interface {
+	Read(name string) (io.ReadCloser, error)
+	Write(name string) (io.WriteCloser, error)
+	// Open opens an existing blob for in-place updates, e.g. to append blocks to the ledger.
+	Open(name string) (File, error)
+	Remove(name string) error
+	// List returns the sorted names of the blobs in the directory, relative to it.
+	// An unknown directory is empty.
+	List(dir string) ([]string, error)
+}
+
+// File is a blob opened for in-place updates. Writes are durable once Sync returns.
+type File interface {
+	io.ReadWriteSeeker
+	io.WriterAt
+	io.Closer
+	Truncate(size int64) error
+	Sync() error
+}
+
+// Dir stores blobs as files in a directory. Names are paths relative to the directory,
+// the empty Dir resolves them relative to the working directory.
+type Dir string
+
+// Read opens the file of the given name.
+func (d Dir) Read(name string) (io.ReadCloser, error) {
+	return os.Open(d.path(name))
+}
+
+// Write creates a temporary file next to the file of the given name, creating its directory if necessary.
+// Closing the writer renames it over the file, so readers never see a partially written file.
+func (d Dir) Write(name string) (io.WriteCloser, error) {
+	dir, base := filepath.Split(d.path(name))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "Could not create directory")
+	}
+	file, err := ioutil.TempFile(dir, "."+base+".*"+tempSuffix)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create temporary file")
+	}
+	return &dirWriter{File: file, path: d.path(name)}, nil
+}
+
+// tempSuffix marks the temporary files of unfinished writes, List skips them.
+const tempSuffix = ".tmp"
+
+// dirWriter writes to a temporary file and moves it into place on Close.
+type dirWriter struct {
+	*os.File
+	path string
+}
+
+// Close syncs and closes the temporary file and renames it to the target path.
+// The temporary file is removed if any step fails.
+func (w *dirWriter) Close() error {
+	err := w.File.Sync()
+	if cerr := w.File.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(w.File.Name(), w.path)
+	}
+	if err != nil {
+		os.Remove(w.File.Name())
+		return errors.Wrap(err, "Could not replace file")
+	}
+	return nil
+}
+
+// Open opens the file of the given name for reading and writing.
+func (d Dir) Open(name string) (File, error) {
+	return os.OpenFile(d.path(name), os.O_RDWR, 0)
+}
+
+// Remove deletes the file of the given name.
+func (d Dir) Remove(name string) error {
+	return os.Remove(d.path(name))
+}
+
+// List returns the names of the regular files in the directory.
+func (d Dir) List(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(d.path(dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if file.Mode().IsRegular() && !isTemp(file.Name()) {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
+
+func isTemp(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempSuffix)
+}
+
+func (d Dir) path(name string) string {
+	return filepath.Join(string(d), filepath.FromSlash(name))
+}
+
+// Memory stores blobs in memory. It is safe for concurrent use.
+type Memory struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+// NewMemory creates an empty in-memory storage.
+func NewMemory() *Memory {
+	return &Memory{blobs: map[string][]byte{}}
+}
+
+// Read returns a reader over a copy of the blob.
+func (m *Memory) Read(name string) (io.ReadCloser, error) {
+	blob, err := m.blob(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not read %s", name)
+	}
+	return ioutil.NopCloser(bytes.NewReader(blob)), nil
+}
+
+// Write returns a writer that stores the blob on Close.
+func (m *Memory) Write(name string) (io.WriteCloser, error) {
+	return &memoryWriter{memory: m, name: name}, nil
+}
+
+// Open returns a copy of the blob that replaces it on Sync and Close.
+func (m *Memory) Open(name string) (File, error) {
+	blob, err := m.blob(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not open %s", name)
+	}
+	return &memoryFile{memory: m, name: name, data: blob}, nil
+}
+
+// Remove deletes the blob.
+func (m *Memory) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.blobs[name]; !ok {
+		return errors.Wrapf(os.ErrNotExist, "Could not remove %s", name)
+	}
+	delete(m.blobs, name)
+	return nil
+}
+
+// List returns the names of the blobs directly below the directory.
+func (m *Memory) List(dir string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for name := range m.blobs {
+		if parent, base := path.Split(name); path.Clean(parent) == path.Clean(dir) {
+			names = append(names, base)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// blob returns a copy of the named blob.
+func (m *Memory) blob(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	blob, ok := m.blobs[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return append([]byte{}, blob...), nil
+}
+
+func (m *Memory) store(name string, blob []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[name] = append([]byte{}, blob...)
+}
+
+type memoryWriter struct {
+	bytes.Buffer
+	memory *Memory
+	name   string
+}
+
+func (w *memoryWriter) Close() error {
+	w.memory.store(w.name, w.Bytes())
+	return nil
+}
+
+type memoryFile struct {
+	memory *Memory
+	name   string
+	data   []byte
+	offset int64
+}
+
+func (f *memoryFile) Read(p []byte) (int, error) {
+	if f.offset >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[f.offset:])
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *memoryFile) Write(p []byte) (int, error) {
+	n, err := f.WriteAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *memoryFile) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("Negative offset")
+	}
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	return copy(f.data[off:], p), nil
+}
+
+func (f *memoryFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += int64(len(f.data))
+	}
+	if offset < 0 {
+		return 0, errors.New("Negative offset")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *memoryFile) Truncate(size int64) error {
+	if size < 0 {
+		return errors.New("Negative size")
+	}
+	if size > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, size-int64(len(f.data)))...)
+	}
+	f.data = f.data[:size]
+	return nil
+}
+
+func (f *memoryFile) Sync() error {
+	f.memory.store(f.name, f.data)
+	return nil
+}
+
+func (f *memoryFile) Close() error {
+	return f.Sync()
+}
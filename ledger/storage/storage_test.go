@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestStorage(t *testing.T) {
+	for name, store := range map[string]Storage{"dir": Dir(t.TempDir()), "memory": NewMemory()} {
+		if _, err := store.Read("missing"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: Read of unknown name should fail with os.ErrNotExist, got %v", name, err)
+		}
+		for _, content := range []string{"first version", "second"} {
+			w, err := store.Write("blob")
+			if err != nil {
+				t.Fatalf("%s: Could not write blob: %v", name, err)
+			}
+			w.Write([]byte(content))
+			if names, err := store.List(""); err != nil || len(names) > 1 {
+				t.Errorf("%s: List should not return unfinished writes, got %v (%v)", name, names, err)
+			}
+			if r, err := store.Read("blob"); err == nil {
+				data, _ := ioutil.ReadAll(r)
+				r.Close()
+				if string(data) == content {
+					t.Errorf("%s: Written data should not be visible before Close", name)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("%s: Could not close blob: %v", name, err)
+			}
+			r, err := store.Read("blob")
+			if err != nil {
+				t.Fatalf("%s: Could not read blob: %v", name, err)
+			}
+			data, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil || string(data) != content {
+				t.Errorf("%s: Read should return %q, got %q (%v)", name, content, data, err)
+			}
+		}
+
+		file, err := store.Open("blob")
+		if err != nil {
+			t.Fatalf("%s: Could not open blob: %v", name, err)
+		}
+		file.Seek(0, io.SeekEnd)
+		file.Write([]byte(" version"))
+		file.WriteAt([]byte("S"), 0)
+		if err := file.Close(); err != nil {
+			t.Fatalf("%s: Could not close opened blob: %v", name, err)
+		}
+		if r, err := store.Read("blob"); err == nil {
+			data, _ := ioutil.ReadAll(r)
+			r.Close()
+			if string(data) != "Second version" {
+				t.Errorf("%s: Updates of an opened blob should be stored, got %q", name, data)
+			}
+		}
+		if _, err := store.Open("missing"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: Open of unknown name should fail with os.ErrNotExist, got %v", name, err)
+		}
+
+		for _, blob := range []string{"dir/b", "dir/a", "dir/nested/c"} {
+			w, err := store.Write(blob)
+			if err != nil {
+				t.Fatalf("%s: Could not write %s: %v", name, blob, err)
+			}
+			w.Close()
+		}
+		if names, err := store.List("dir"); err != nil || !reflect.DeepEqual(names, []string{"a", "b"}) {
+			t.Errorf("%s: List should return the blobs of the directory, got %v (%v)", name, names, err)
+		}
+		if names, err := store.List("missing"); err != nil || len(names) != 0 {
+			t.Errorf("%s: List of unknown directory should be empty, got %v (%v)", name, names, err)
+		}
+		if err := store.Remove("blob"); err != nil {
+			t.Errorf("%s: Could not remove blob: %v", name, err)
+		}
+		if _, err := store.Read("blob"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: Removed blob should not be readable, got %v", name, err)
+		}
+		if err := store.Remove("blob"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("%s: Remove of unknown name should fail with os.ErrNotExist, got %v", name, err)
+		}
+	}
+}
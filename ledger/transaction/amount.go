@@ -0,0 +1,107 @@
+package transaction
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// MaxDecimals is the largest number of decimals representable by a uint64 amount.
+const MaxDecimals = 19
+
+// ChainParams holds the chain parameters concerning transaction amounts.
+type ChainParams struct {
+	// Decimals is the number of fractional digits of one coin, i.e. one coin equals 10^Decimals units.
+	Decimals uint8
+}
+
+// Params are the chain parameters used by FormatAmount and ParseAmount.
+var Params = ChainParams{Decimals: 6}
+
+// FormatAmount formats a raw amount in coins using the configured decimals.
+func FormatAmount(raw uint64) string {
+	return Params.FormatAmount(raw)
+}
+
+// ParseAmount parses an amount in coins into its raw value using the configured decimals.
+func ParseAmount(s string) (uint64, error) {
+	return Params.ParseAmount(s)
+}
+
+// AddAmounts sums up the given amounts, reporting false if the sum overflows.
+func AddAmounts(amounts ...uint64) (uint64, bool) {
+	var sum, carry uint64
+	for _, amount := range amounts {
+		if sum, carry = bits.Add64(sum, amount, 0); carry != 0 {
+			return 0, false
+		}
+	}
+	return sum, true
+}
+
+// Unit returns the raw value of one coin.
+func (p ChainParams) Unit() uint64 {
+	unit := uint64(1)
+	for i := uint8(0); i < p.Decimals && i < MaxDecimals; i++ {
+		unit *= 10
+	}
+	return unit
+}
+
+// FormatAmount formats a raw amount in coins, e.g. 12340000 as "12.340000" with six decimals.
+func (p ChainParams) FormatAmount(raw uint64) string {
+	if p.Decimals == 0 {
+		return strconv.FormatUint(raw, 10)
+	}
+	unit := p.Unit()
+	fraction := strconv.FormatUint(raw%unit, 10)
+	return strconv.FormatUint(raw/unit, 10) + "." + strings.Repeat("0", int(p.Decimals)-len(fraction)) + fraction
+}
+
+// ParseAmount parses an amount in coins into its raw value. Trailing zeros beyond the
+// configured decimals are accepted, any other excess precision is rejected.
+func (p ChainParams) ParseAmount(s string) (uint64, error) {
+	if p.Decimals > MaxDecimals {
+		return 0, errors.Errorf("Decimals exceed %d", MaxDecimals)
+	}
+	whole, fraction := s, ""
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		whole, fraction = s[:dot], s[dot+1:]
+		if fraction == "" {
+			return 0, errors.Errorf("Amount %q has an empty fraction", s)
+		}
+	}
+	if whole == "" && fraction == "" {
+		return 0, errors.New("Amount is empty")
+	}
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > int(p.Decimals) {
+		return 0, errors.Errorf("Amount %q exceeds %d decimals", s, p.Decimals)
+	}
+	fraction += strings.Repeat("0", int(p.Decimals)-len(fraction))
+	for _, part := range []string{whole, fraction} {
+		if strings.Trim(part, "0123456789") != "" {
+			return 0, errors.Errorf("Amount %q is not a decimal number", s)
+		}
+	}
+	var units, subunits uint64
+	var err error
+	if whole != "" {
+		if units, err = strconv.ParseUint(whole, 10, 64); err != nil {
+			return 0, errors.Errorf("Amount %q is out of range", s)
+		}
+	}
+	if fraction != "" {
+		if subunits, err = strconv.ParseUint(fraction, 10, 64); err != nil {
+			return 0, errors.Errorf("Amount %q is out of range", s)
+		}
+	}
+	unit := p.Unit()
+	if units > (math.MaxUint64-subunits)/unit {
+		return 0, errors.Errorf("Amount %q is out of range", s)
+	}
+	return units*unit + subunits, nil
+}
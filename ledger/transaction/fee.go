@@ -0,0 +1,87 @@
+package transaction
+
+import (
+	"math"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger/account"
+)
+
+// FeeSchedule describes the fee policy of a chain.
+type FeeSchedule struct {
+	// Base is the minimum fee paid for each transaction.
+	Base uint64
+	// SizeScalar is charged per byte of the transaction, see TX.Size.
+	SizeScalar uint64
+	// ComplexityScalar scales the fee with the square root of the block complexity in epochs.
+	ComplexityScalar uint64
+	// Epoch is the complexity covered by one epoch.
+	Epoch float64
+}
+
+// DefaultFeeSchedule is the fee policy of chains without an entry in FeeSchedules.
+var DefaultFeeSchedule = FeeSchedule{
+	Base:             BaseFee,
+	SizeScalar:       FeeSizeScalar,
+	ComplexityScalar: FeeComplexityScalar,
+	Epoch:            FeeEpoch,
+}
+
+// FeeSchedules holds the fee policy per chain. Writing it directly is only safe before any
+// ledger is in use, RegisterFeeSchedule may be called at any time.
+var FeeSchedules = map[uint64]FeeSchedule{}
+
+// feeSchedulesMu guards FeeSchedules.
+var feeSchedulesMu sync.RWMutex
+
+// RegisterFeeSchedule sets the fee policy of the given chain. A chain can not be registered
+// again with a different schedule.
+func RegisterFeeSchedule(chain uint64, schedule FeeSchedule) error {
+	feeSchedulesMu.Lock()
+	defer feeSchedulesMu.Unlock()
+	if known, ok := FeeSchedules[chain]; ok && known != schedule {
+		return errors.Errorf("Chain %d already has a different fee schedule", chain)
+	}
+	FeeSchedules[chain] = schedule
+	return nil
+}
+
+// RegisteredFeeSchedule returns the fee policy registered for the given chain, if any.
+func RegisteredFeeSchedule(chain uint64) (FeeSchedule, bool) {
+	feeSchedulesMu.RLock()
+	defer feeSchedulesMu.RUnlock()
+	schedule, ok := FeeSchedules[chain]
+	return schedule, ok
+}
+
+// FeeScheduleFor returns the fee policy of the given chain.
+func FeeScheduleFor(chain uint64) FeeSchedule {
+	if schedule, ok := RegisteredFeeSchedule(chain); ok {
+		return schedule
+	}
+	return DefaultFeeSchedule
+}
+
+// Calculate calculates the fees required for a transaction of the given size in a block of the given complexity.
+func (s FeeSchedule) Calculate(size, complexity uint64) uint64 {
+	epochs := 0.0
+	if s.Epoch > 0 {
+		epochs = float64(complexity) / s.Epoch
+	}
+	return s.Base + s.SizeScalar*size + s.ComplexityScalar*uint64(math.Sqrt(epochs))
+}
+
+// Estimate returns the minimum fee of a transfer carrying dataLen bytes of data in a block of the given complexity.
+func (s FeeSchedule) Estimate(dataLen, complexity uint64) uint64 {
+	tx := TX{
+		Version:   CurrentVersion,
+		Type:      TypeTransfer,
+		Sender:    make([]byte, AddressSize),
+		Recipient: make([]byte, AddressSize),
+		Data:      make([]byte, dataLen),
+		Proof:     make([]byte, account.SignatureSize),
+	}
+	return s.Calculate(tx.feeSize(), complexity)
+}
@@ -0,0 +1,103 @@
+package transaction
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+var typeNames = map[uint64]string{
+	TypeCoinbase: "coinbase",
+	TypeAccount:  "account",
+	TypeTransfer: "transfer",
+	TypeBurn:     "burn",
+}
+
+// TypeName returns the readable name of the transaction type.
+func TypeName(t uint64) (string, bool) {
+	name, ok := typeNames[t]
+	return name, ok
+}
+
+// ParseType returns the transaction type with the given readable name.
+func ParseType(name string) (uint64, bool) {
+	for t, n := range typeNames {
+		if n == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+type txJSON struct {
+	Version   uint8  `json:"version"`
+	Chain     uint64 `json:"chain"`
+	Type      string `json:"type"`
+	Sender    string `json:"sender"`
+	Recipient string `json:"recipient"`
+	Amount    uint64 `json:"amount"`
+	Fee       uint64 `json:"fee"`
+	Timestamp uint64 `json:"timestamp"`
+	Nonce     uint64 `json:"nonce"`
+	Proof     string `json:"proof"`
+	Data      string `json:"data"`
+}
+
+// MarshalJSON encodes the transaction with hex-encoded binary fields and a readable type.
+func (tx TX) MarshalJSON() ([]byte, error) {
+	name, ok := TypeName(tx.Type)
+	if !ok {
+		return nil, errors.Errorf("Unknown transaction type %d", tx.Type)
+	}
+	return json.Marshal(txJSON{
+		Version:   tx.Version,
+		Chain:     tx.Chain,
+		Type:      name,
+		Sender:    hex.EncodeToString(tx.Sender),
+		Recipient: hex.EncodeToString(tx.Recipient),
+		Amount:    tx.Amount,
+		Fee:       tx.Fee,
+		Timestamp: tx.Timestamp,
+		Nonce:     tx.Nonce,
+		Proof:     hex.EncodeToString(tx.Proof),
+		Data:      hex.EncodeToString(tx.Data),
+	})
+}
+
+// UnmarshalJSON decodes a transaction encoded by MarshalJSON.
+func (tx *TX) UnmarshalJSON(data []byte) error {
+	var decoded txJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	t, ok := ParseType(decoded.Type)
+	if !ok {
+		return errors.Errorf("Unknown transaction type %q", decoded.Type)
+	}
+	fields := []struct {
+		name    string
+		encoded string
+		value   *[]byte
+	}{
+		{"sender", decoded.Sender, &tx.Sender},
+		{"recipient", decoded.Recipient, &tx.Recipient},
+		{"proof", decoded.Proof, &tx.Proof},
+		{"data", decoded.Data, &tx.Data},
+	}
+	for _, field := range fields {
+		value, err := hex.DecodeString(field.encoded)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid %s", field.name)
+		}
+		*field.value = value
+	}
+	tx.Version = decoded.Version
+	tx.Chain = decoded.Chain
+	tx.Type = t
+	tx.Amount = decoded.Amount
+	tx.Fee = decoded.Fee
+	tx.Timestamp = decoded.Timestamp
+	tx.Nonce = decoded.Nonce
+	return nil
+}
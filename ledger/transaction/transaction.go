@@ -5,10 +5,10 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
-	"math"
 	"time"
 
 	"github.com/google/btree"
+	"github.com/pkg/errors"
 
 	"github.com/lnsp/txledger/ledger/account"
 	"github.com/lnsp/txledger/ledger/hash"
@@ -27,11 +27,30 @@ const (
 	KeyPairSize = 64
 	// FeeEpoch is the block epoch size
 	FeeEpoch = 64.0
+	// MaxMemoSize is the maximum plaintext size of an encrypted transfer memo
+	MaxMemoSize = 256
+	// MemoOverhead is the size added by marking and encrypting a memo (marker, ephemeral key, nonce and tag)
+	MemoOverhead = 4 + 65 + 12 + 16
+	// MaxTxDataSize is the maximum size of a transfer's or burn's data field, large enough for an encrypted memo
+	MaxTxDataSize = MaxMemoSize + MemoOverhead
 )
 
-// CalculateFee calculates the fees required for a block of the given size and complexity.
+// Now returns the current time as a unix timestamp. It is used for all transaction
+// timestamps and may be replaced, e.g. to reproduce historical transactions.
+var Now = func() uint64 {
+	return uint64(time.Now().Unix())
+}
+
+// CalculateFee calculates the fees required for a block of the given size and complexity
+// under the DefaultFeeSchedule.
 func CalculateFee(size, complexity uint64) uint64 {
-	return BaseFee + FeeSizeScalar*size + FeeComplexityScalar*uint64(math.Sqrt(float64(complexity)/FeeEpoch))
+	return DefaultFeeSchedule.Calculate(size, complexity)
+}
+
+// EstimateFee returns the minimum fee of a transfer carrying dataLen bytes of data
+// in a block of the given complexity under the DefaultFeeSchedule.
+func EstimateFee(dataLen, complexity uint64) uint64 {
+	return DefaultFeeSchedule.Estimate(dataLen, complexity)
 }
 
 const (
@@ -41,19 +60,68 @@ const (
 	TypeAccount
 	// TypeTransfer transfers a specified amount of value from the sender to the receiver
 	TypeTransfer
+	// TypeBurn permanently destroys a specified amount of value owned by the sender
+	TypeBurn
+)
+
+const (
+	// VersionLegacy is the original layout without a version byte and with fixed-size address
+	// and proof fields. Legacy transactions are recognized by their chain, see Decode.
+	VersionLegacy uint8 = 0
+	// Version1 adds the leading version byte to the legacy layout
+	Version1 uint8 = iota
+	// Version2 length-prefixes the variable-size fields
+	Version2
+	// Version3 adds the sender nonce protecting transfers against replay
+	Version3
+	// Version4 prices fees by the full serialized size instead of the data size.
+	// The encoding is unchanged from Version3.
+	Version4
+	// CurrentVersion is the version used for newly created transactions
+	CurrentVersion = Version4
 )
 
+// Validation errors returned by Validate, use errors.Is to match them.
+var (
+	ErrBadFee   = errors.New("Fees are insufficient")
+	ErrBadProof = errors.New("Proof is invalid")
+	// ErrSchemeMismatch is returned for transfers to a recipient whose key uses a different
+	// signature scheme than the sender's, and thus the chain's.
+	ErrSchemeMismatch = errors.New("Recipient key uses a different signature scheme")
+)
+
+// MinVersions holds the minimum accepted transaction version per chain.
+var MinVersions = map[uint64]uint8{}
+
+// MinVersion returns the minimum transaction version accepted on the given chain.
+func MinVersion(chain uint64) uint8 {
+	if version, ok := MinVersions[chain]; ok {
+		return version
+	}
+	return VersionLegacy
+}
+
 // TX is the transaction storage structure
 type TX struct {
+	Version           uint8
 	Chain             uint64
 	Type              uint64
 	Sender, Recipient []byte
 	Amount, Fee       uint64
 	Timestamp         uint64
+	Nonce             uint64
 	Proof             []byte
 	Data              []byte
 }
 
+// memoMarker prefixes data holding an encrypted memo to tell it apart from plain data.
+var memoMarker = []byte("\x00enc")
+
+// HasMemo reports whether the transfer data holds an encrypted memo.
+func (tx TX) HasMemo() bool {
+	return tx.Type == TypeTransfer && len(tx.Data) > len(memoMarker) && bytes.Equal(tx.Data[:len(memoMarker)], memoMarker)
+}
+
 func (tx TX) String() string {
 	switch tx.Type {
 	case TypeCoinbase:
@@ -61,7 +129,15 @@ func (tx TX) String() string {
 	case TypeAccount:
 		return fmt.Sprintf("TX Account [address = %s]", hex.EncodeToString(tx.Sender))
 	case TypeTransfer:
-		return fmt.Sprintf("TX Transfer [from = %s; to = %s; amount = %d; fee = %d]", hex.EncodeToString(tx.Sender), hex.EncodeToString(tx.Recipient), tx.Amount, tx.Fee)
+		memo := ""
+		if tx.HasMemo() {
+			memo = " [encrypted memo]"
+		} else if len(tx.Data) > 0 {
+			memo = fmt.Sprintf(" [%d bytes data]", len(tx.Data))
+		}
+		return fmt.Sprintf("TX Transfer [from = %s; to = %s; amount = %d; fee = %d; nonce = %d]%s", hex.EncodeToString(tx.Sender), hex.EncodeToString(tx.Recipient), tx.Amount, tx.Fee, tx.Nonce, memo)
+	case TypeBurn:
+		return fmt.Sprintf("TX Burn [from = %s; amount = %d; fee = %d; nonce = %d]", hex.EncodeToString(tx.Sender), tx.Amount, tx.Fee, tx.Nonce)
 	}
 	return "TX Unknown"
 }
@@ -70,7 +146,10 @@ func (tx TX) String() string {
 func (tx TX) VerifyProof(addresses *btree.BTree) bool {
 	switch tx.Type {
 	case TypeCoinbase:
-		pub := account.NewPublic(tx.Data)
+		pub, err := account.NewPublicE(tx.Data)
+		if err != nil {
+			return false
+		}
 		if !bytes.Equal(pub.Address(), tx.Recipient) {
 			return false
 		}
@@ -79,7 +158,10 @@ func (tx TX) VerifyProof(addresses *btree.BTree) bool {
 		}
 		return true
 	case TypeAccount:
-		pub := account.NewPublic(tx.Data)
+		pub, err := account.NewPublicE(tx.Data)
+		if err != nil {
+			return false
+		}
 		if !bytes.Equal(pub.Address(), tx.Sender) {
 			return false
 		}
@@ -87,14 +169,14 @@ func (tx TX) VerifyProof(addresses *btree.BTree) bool {
 			return false
 		}
 		return true
-	case TypeTransfer:
+	case TypeTransfer, TypeBurn:
 		item := addresses.Get(account.AddressTreeItem{
 			Address: tx.Sender,
 		})
 		if item == nil {
 			return false
 		}
-		acc := item.(account.Account)
+		acc := item.(account.AddressTreeItem).Account
 		if !bytes.Equal(acc.Address(), tx.Sender) {
 			return false
 		}
@@ -113,12 +195,84 @@ func (tx TX) VerifyFees(reward, complexity uint64) bool {
 		return tx.Amount <= reward
 	case TypeAccount:
 		return true
-	case TypeTransfer:
-		return tx.Fee >= CalculateFee(uint64(len(tx.Data)), complexity)
+	case TypeTransfer, TypeBurn:
+		return tx.Fee >= FeeScheduleFor(tx.Chain).Calculate(tx.feeSize(), complexity)
 	}
 	return false
 }
 
+// Validate runs the structural checks on the transaction followed by proof and fee verification.
+func (tx TX) Validate(addresses *btree.BTree, reward, complexity uint64) error {
+	if tx.Version > CurrentVersion {
+		return errors.Errorf("Unsupported version %d", tx.Version)
+	}
+	if _, ok := AddAmounts(tx.Amount, tx.Fee); !ok {
+		return errors.New("Amount and fee overflow")
+	}
+	if len(tx.Proof) != account.SignatureSize {
+		return errors.Errorf("Proof should be %d bytes, got %d", account.SignatureSize, len(tx.Proof))
+	}
+	switch tx.Type {
+	case TypeCoinbase:
+		if tx.Fee != 0 {
+			return errors.New("Coinbase should not pay a fee")
+		}
+	case TypeAccount:
+		if tx.Amount != 0 || tx.Fee != 0 {
+			return errors.New("Account announcement should not carry value")
+		}
+	case TypeTransfer, TypeBurn:
+	default:
+		return errors.Errorf("Unknown type %d", tx.Type)
+	}
+	// Unused senders and recipients hold zero addresses.
+	if len(tx.Sender) != AddressSize {
+		return errors.Errorf("Sender should be %d bytes, got %d", AddressSize, len(tx.Sender))
+	}
+	if len(tx.Recipient) != AddressSize {
+		return errors.Errorf("Recipient should be %d bytes, got %d", AddressSize, len(tx.Recipient))
+	}
+	switch tx.Type {
+	case TypeCoinbase, TypeAccount:
+		if size := account.KeySize(tx.Data); len(tx.Data) != size {
+			return errors.Errorf("Public key should be %d bytes, got %d", size, len(tx.Data))
+		}
+	case TypeTransfer, TypeBurn:
+		if tx.Amount == 0 {
+			return errors.New("Amount should be positive")
+		}
+		if len(tx.Data) > MaxTxDataSize {
+			return errors.Errorf("Data exceeds %d bytes", MaxTxDataSize)
+		}
+	}
+	if tx.Type == TypeTransfer && !tx.sameScheme(addresses) {
+		return ErrSchemeMismatch
+	}
+	if !tx.VerifyFees(reward, complexity) {
+		return ErrBadFee
+	}
+	if !tx.VerifyProof(addresses) {
+		return ErrBadProof
+	}
+	return nil
+}
+
+// sameScheme reports whether sender and recipient keys known to the address tree use the
+// same signature scheme.
+func (tx TX) sameScheme(addresses *btree.BTree) bool {
+	sender := addresses.Get(account.AddressTreeItem{Address: tx.Sender})
+	recipient := addresses.Get(account.AddressTreeItem{Address: tx.Recipient})
+	if sender == nil || recipient == nil {
+		return true
+	}
+	return sameScheme(sender.(account.AddressTreeItem).Account, recipient.(account.AddressTreeItem).Account)
+}
+
+// sameScheme reports whether both accounts use the same signature scheme.
+func sameScheme(a, b account.Account) bool {
+	return account.KeyTypeOf(a.PublicKeyBytes()) == account.KeyTypeOf(b.PublicKeyBytes())
+}
+
 // Apply applies the transaction to the address database.
 func (tx TX) Apply(addresses *btree.BTree) bool {
 	var (
@@ -127,36 +281,40 @@ func (tx TX) Apply(addresses *btree.BTree) bool {
 	)
 	switch tx.Type {
 	case TypeCoinbase:
+		pub, err := account.NewPublicE(tx.Data)
+		if err != nil {
+			return false
+		}
+		addrItem = account.AddressTreeItem{Address: pub.Address(), Account: pub}
 		if item = addresses.Get(account.AddressTreeItem{
 			Address: tx.Recipient,
 		}); item != nil {
 			addrItem = item.(account.AddressTreeItem)
-		} else {
-			addr := account.NewPublic(tx.Data)
-			addrItem = account.AddressTreeItem{
-				Address: addr.Address(),
-				Account: addr,
-				Funds:   0,
-			}
 		}
-		addrItem.Funds += tx.Amount
+		// Compressed and uncompressed encodings of a key share the same address.
+		if !account.Equal(pub, addrItem.Account) {
+			return false
+		}
+		funds, ok := AddAmounts(addrItem.Funds, tx.Amount)
+		if !ok {
+			return false
+		}
+		addrItem.Funds = funds
 	case TypeAccount:
+		pub, err := account.NewPublicE(tx.Data)
+		if err != nil {
+			return false
+		}
+		addrItem = account.AddressTreeItem{Address: pub.Address(), Account: pub}
 		if item = addresses.Get(account.AddressTreeItem{
 			Address: tx.Sender,
 		}); item != nil {
 			addrItem = item.(account.AddressTreeItem)
-		} else {
-			addr := account.NewPublic(tx.Data)
-			addrItem = account.AddressTreeItem{
-				Address: addr.Address(),
-				Account: addr,
-				Funds:   0,
-			}
 		}
 		if !bytes.Equal(tx.Sender, addrItem.Account.Address()) {
 			return false
 		}
-		if !bytes.Equal(tx.Data, addrItem.Account.PublicKeyBytes()) {
+		if !account.Equal(pub, addrItem.Account) {
 			return false
 		}
 	case TypeTransfer:
@@ -174,72 +332,230 @@ func (tx TX) Apply(addresses *btree.BTree) bool {
 		if recipientItem = addresses.Get(account.AddressTreeItem{
 			Address: tx.Recipient,
 		}); recipientItem != nil {
-			recipientAddrItem = item.(account.AddressTreeItem)
+			recipientAddrItem = recipientItem.(account.AddressTreeItem)
 		} else {
 			return false
 		}
-		if tx.Fee+tx.Amount < tx.Amount {
+		total, ok := AddAmounts(tx.Fee, tx.Amount)
+		if !ok || addrItem.Funds < total {
 			return false
 		}
-		if addrItem.Funds < tx.Fee+tx.Amount {
-			return false
+		if tx.Sequenced() {
+			if tx.Nonce != addrItem.Nonce+1 {
+				return false
+			}
+			addrItem.Nonce = tx.Nonce
 		}
-		addrItem.Funds -= tx.Fee + tx.Amount
-		if recipientAddrItem.Funds+tx.Amount < recipientAddrItem.Funds {
+		addrItem.Funds -= total
+		if bytes.Equal(tx.Sender, tx.Recipient) {
+			// Both items share one tree entry, a self-transfer only pays the fee.
+			addrItem.Funds += tx.Amount
+			break
+		}
+		if recipientAddrItem.Funds, ok = AddAmounts(recipientAddrItem.Funds, tx.Amount); !ok {
 			return false
 		}
-		recipientAddrItem.Funds += tx.Amount
 		addresses.ReplaceOrInsert(recipientAddrItem)
+	case TypeBurn:
+		if item = addresses.Get(account.AddressTreeItem{
+			Address: tx.Sender,
+		}); item != nil {
+			addrItem = item.(account.AddressTreeItem)
+		} else {
+			return false
+		}
+		total, ok := AddAmounts(tx.Fee, tx.Amount)
+		if !ok || addrItem.Funds < total {
+			return false
+		}
+		if tx.Sequenced() {
+			if tx.Nonce != addrItem.Nonce+1 {
+				return false
+			}
+			addrItem.Nonce = tx.Nonce
+		}
+		addrItem.Funds -= total
+	default:
+		return false
 	}
 	addresses.ReplaceOrInsert(addrItem)
 	return true
 }
 
+// Size returns the length of the serialized transaction in bytes.
+func (tx TX) Size() uint64 {
+	return uint64(len(tx.Bytes()))
+}
+
+// feeSize returns the size fees are charged for. Since Version4 this is the full
+// serialized size, older versions only pay for their data.
+func (tx TX) feeSize() uint64 {
+	if tx.Version >= Version4 {
+		return tx.Size()
+	}
+	return uint64(len(tx.Data))
+}
+
+// Sequenced returns true if the transaction consumes the next nonce of its sender.
+func (tx TX) Sequenced() bool {
+	return (tx.Type == TypeTransfer || tx.Type == TypeBurn) && tx.Version >= Version3
+}
+
 // Bytes serializes the transaction to a binary format.
+// The leading version byte determines the layout of the remaining fields.
 func (tx TX) Bytes() []byte {
 	buffer := bytes.NewBuffer([]byte{})
+	if tx.Version != VersionLegacy {
+		buffer.WriteByte(tx.Version)
+	}
 	binary.Write(buffer, binary.LittleEndian, tx.Chain)
 	binary.Write(buffer, binary.LittleEndian, tx.Type)
 	binary.Write(buffer, binary.LittleEndian, tx.Amount)
 	binary.Write(buffer, binary.LittleEndian, tx.Fee)
 	binary.Write(buffer, binary.LittleEndian, tx.Timestamp)
+	if tx.Version >= Version3 {
+		binary.Write(buffer, binary.LittleEndian, tx.Nonce)
+	}
 
-	buffer.Write(tx.Sender)
-	buffer.Write(tx.Recipient)
-	buffer.Write(tx.Proof)
-	buffer.Write(tx.Data)
+	switch tx.Version {
+	case VersionLegacy, Version1:
+		buffer.Write(tx.Sender)
+		buffer.Write(tx.Recipient)
+		buffer.Write(tx.Proof)
+		buffer.Write(tx.Data)
+	default:
+		for _, field := range [][]byte{tx.Sender, tx.Recipient, tx.Proof, tx.Data} {
+			binary.Write(buffer, binary.LittleEndian, uint64(len(field)))
+			buffer.Write(field)
+		}
+	}
 	return buffer.Bytes()
 }
 
-// SetBytes retrieves the transaction from the given binary data.
+// SetBytes retrieves the transaction from the given binary data. It panics if the data
+// is malformed, see SetBytesE.
 func (tx TX) SetBytes(b []byte) TX {
+	tx, err := tx.SetBytesE(b)
+	if err != nil {
+		panic(err)
+	}
+	return tx
+}
+
+// SetBytesE retrieves the transaction from the given binary data. Truncated fields,
+// length prefixes exceeding the data and trailing bytes are rejected.
+func (tx TX) SetBytesE(b []byte) (TX, error) {
 	buffer := bytes.NewBuffer(b)
-	binary.Read(buffer, binary.LittleEndian, &tx.Chain)
-	binary.Read(buffer, binary.LittleEndian, &tx.Type)
-	binary.Read(buffer, binary.LittleEndian, &tx.Amount)
-	binary.Read(buffer, binary.LittleEndian, &tx.Fee)
-	binary.Read(buffer, binary.LittleEndian, &tx.Timestamp)
-
-	buffer.Read(tx.Sender)
-	buffer.Read(tx.Recipient)
-	buffer.Read(tx.Proof)
-	tx.Data = buffer.Bytes()
+	version, err := buffer.ReadByte()
+	if err != nil {
+		return tx, errors.New("Transaction is empty")
+	}
+	if version < Version1 || version > CurrentVersion {
+		return tx, errors.Errorf("Unsupported version %d", version)
+	}
+	tx.Version, tx.Nonce = version, 0
+	fixed := []*uint64{&tx.Chain, &tx.Type, &tx.Amount, &tx.Fee, &tx.Timestamp}
+	if tx.Version >= Version3 {
+		fixed = append(fixed, &tx.Nonce)
+	}
+	for _, field := range fixed {
+		if err := binary.Read(buffer, binary.LittleEndian, field); err != nil {
+			return tx, errors.New("Transaction truncated")
+		}
+	}
+
+	// Fresh slices keep the decoded fields independent of the receiver and of b.
+	switch tx.Version {
+	case Version1:
+		if buffer.Len() < 2*AddressSize+KeyPairSize {
+			return tx, errors.New("Transaction truncated")
+		}
+		tx.Sender = append(make([]byte, 0, AddressSize), buffer.Next(AddressSize)...)
+		tx.Recipient = append(make([]byte, 0, AddressSize), buffer.Next(AddressSize)...)
+		tx.Proof = append(make([]byte, 0, KeyPairSize), buffer.Next(KeyPairSize)...)
+		tx.Data = append([]byte{}, buffer.Bytes()...)
+	default:
+		fields := []*[]byte{&tx.Sender, &tx.Recipient, &tx.Proof, &tx.Data}
+		for _, field := range fields {
+			var size uint64
+			if err := binary.Read(buffer, binary.LittleEndian, &size); err != nil {
+				return tx, errors.New("Transaction truncated")
+			}
+			if size > uint64(buffer.Len()) {
+				return tx, errors.Errorf("Field of %d bytes exceeds the remaining %d bytes", size, buffer.Len())
+			}
+			*field = append([]byte{}, buffer.Next(int(size))...)
+		}
+		if buffer.Len() > 0 {
+			return tx, errors.Errorf("Transaction has %d trailing bytes", buffer.Len())
+		}
+	}
+	return tx, nil
+}
+
+// SetLegacyBytes retrieves the transaction from binary data in the legacy layout.
+func (tx TX) SetLegacyBytes(b []byte) (TX, error) {
+	if len(b) < 5*8+2*AddressSize+KeyPairSize {
+		return tx, errors.Errorf("Legacy transaction should be at least %d bytes, got %d", 5*8+2*AddressSize+KeyPairSize, len(b))
+	}
+	tx.Version = VersionLegacy
+	tx.Nonce = 0
+	for i, field := range []*uint64{&tx.Chain, &tx.Type, &tx.Amount, &tx.Fee, &tx.Timestamp} {
+		*field = binary.LittleEndian.Uint64(b[8*i:])
+	}
+	b = b[5*8:]
+	tx.Sender = append([]byte{}, b[:AddressSize]...)
+	tx.Recipient = append([]byte{}, b[AddressSize:2*AddressSize]...)
+	tx.Proof = append([]byte{}, b[2*AddressSize:2*AddressSize+KeyPairSize]...)
+	tx.Data = append([]byte{}, b[2*AddressSize+KeyPairSize:]...)
+	return tx, nil
+}
+
+// Decode decodes a transaction of the given chain. Transactions that do not decode as a
+// versioned transaction of the chain are decoded in the legacy layout, if it matches the chain.
+func Decode(chain uint64, b []byte) (TX, error) {
+	tx, err := New().SetBytesE(b)
+	if err == nil && tx.Chain == chain {
+		return tx, nil
+	}
+	if legacy, legacyErr := New().SetLegacyBytes(b); legacyErr == nil && legacy.Chain == chain {
+		return legacy, nil
+	}
+	return tx, err
+}
+
+// Clone creates a deep copy of the transaction.
+func (tx TX) Clone() TX {
+	tx.Sender = append([]byte(nil), tx.Sender...)
+	tx.Recipient = append([]byte(nil), tx.Recipient...)
+	tx.Proof = append([]byte(nil), tx.Proof...)
+	tx.Data = append([]byte{}, tx.Data...)
 	return tx
 }
 
 // PartialHash generates a hash excluding the proof data.
 func (tx TX) PartialHash() []byte {
 	hasher := hash.New()
+	if tx.Version != VersionLegacy {
+		hasher.Write([]byte{tx.Version})
+	}
 	binary.Write(hasher, binary.LittleEndian, tx.Chain)
 	binary.Write(hasher, binary.LittleEndian, tx.Type)
 	binary.Write(hasher, binary.LittleEndian, tx.Amount)
 	binary.Write(hasher, binary.LittleEndian, tx.Fee)
 	binary.Write(hasher, binary.LittleEndian, tx.Timestamp)
+	if tx.Version >= Version3 {
+		binary.Write(hasher, binary.LittleEndian, tx.Nonce)
+	}
 
-	hasher.Write(tx.Sender)
-	hasher.Write(tx.Recipient)
-	hasher.Write(tx.Data)
-	return hasher.Sum()
+	// Since Version2 the fields have variable sizes, their lengths bind the field boundaries.
+	for _, field := range [][]byte{tx.Sender, tx.Recipient, tx.Data} {
+		if tx.Version >= Version2 {
+			binary.Write(hasher, binary.LittleEndian, uint64(len(field)))
+		}
+		hasher.Write(field)
+	}
+	return hasher.Sum(nil)
 }
 
 // Hash generates a transaction hash from the partial hash and the proof data.
@@ -247,12 +563,13 @@ func (tx TX) Hash() []byte {
 	hasher := hash.New()
 	hasher.Write(tx.PartialHash())
 	hasher.Write(tx.Proof)
-	return hasher.Sum()
+	return hasher.Sum(nil)
 }
 
 // New creates a new empty transaction.
 func New() TX {
 	return TX{
+		Version:   CurrentVersion,
 		Chain:     0,
 		Type:      0,
 		Amount:    0,
@@ -265,50 +582,150 @@ func New() TX {
 	}
 }
 
-// NewCoinbase creates a new coinbase on the given chain and miner.
+// NewCoinbase creates a new coinbase on the given chain and miner, carrying the miner's compressed
+// public key. It panics if signing fails.
 func NewCoinbase(chain uint64, priv *account.Private, amount uint64) TX {
+	return must(NewCoinbaseE(chain, priv, amount))
+}
+
+// NewCoinbaseE is like NewCoinbase but returns signing errors.
+func NewCoinbaseE(chain uint64, priv *account.Private, amount uint64) (TX, error) {
 	tx := TX{
+		Version:   CurrentVersion,
 		Chain:     chain,
 		Type:      TypeCoinbase,
 		Amount:    amount,
 		Fee:       0,
-		Timestamp: uint64(time.Now().Unix()),
+		Timestamp: Now(),
 		Sender:    make([]byte, AddressSize),
 		Recipient: priv.Address(),
-		Data:      priv.PublicKeyBytes(),
+		Data:      priv.CompressedPublicKeyBytes(),
 	}
-	tx.Proof = priv.Sign(tx.PartialHash())
-	return tx
+	return signed(tx, priv)
 }
 
-// NewAccount announces a new account on the given chain.
+// NewAccount announces a new account on the given chain with its compressed public key.
+// It panics if signing fails.
 func NewAccount(chain uint64, priv *account.Private) TX {
+	return must(NewAccountE(chain, priv))
+}
+
+// NewAccountE is like NewAccount but returns signing errors.
+func NewAccountE(chain uint64, priv *account.Private) (TX, error) {
 	tx := TX{
+		Version:   CurrentVersion,
 		Chain:     chain,
 		Type:      TypeAccount,
 		Amount:    0,
 		Fee:       0,
-		Timestamp: uint64(time.Now().Unix()),
+		Timestamp: Now(),
 		Sender:    priv.Address(),
 		Recipient: make([]byte, AddressSize),
-		Data:      priv.PublicKeyBytes(),
+		Data:      priv.CompressedPublicKeyBytes(),
 	}
-	tx.Proof = priv.Sign(tx.PartialHash())
-	return tx
+	return signed(tx, priv)
 }
 
 // NewTransfer creates a new transfer of the given amount of value.
-func NewTransfer(chain, amount, fee uint64, from *account.Private, to account.Account) TX {
+// The nonce must be one greater than the last nonce used by the sender.
+// It panics if signing fails or the recipient uses a different signature scheme.
+func NewTransfer(chain, amount, fee, nonce uint64, from *account.Private, to account.Account) TX {
+	return must(NewTransferE(chain, amount, fee, nonce, from, to))
+}
+
+// NewTransferE is like NewTransfer but returns signing errors and ErrSchemeMismatch for
+// recipients whose key uses a different signature scheme than the sender's.
+func NewTransferE(chain, amount, fee, nonce uint64, from *account.Private, to account.Account) (TX, error) {
+	if !sameScheme(from, to) {
+		return TX{}, ErrSchemeMismatch
+	}
 	tx := TX{
+		Version:   CurrentVersion,
 		Chain:     chain,
 		Type:      TypeTransfer,
 		Amount:    amount,
 		Fee:       fee,
-		Timestamp: uint64(time.Now().Unix()),
+		Timestamp: Now(),
+		Nonce:     nonce,
 		Sender:    from.Address(),
 		Recipient: to.Address(),
 		Data:      []byte{},
 	}
-	tx.Proof = from.Sign(tx.PartialHash())
+	return signed(tx, from)
+}
+
+// NewBurn creates a transaction destroying the given amount of value owned by the sender.
+// The nonce must be one greater than the last nonce used by the sender. It panics if signing fails.
+func NewBurn(chain, amount, fee, nonce uint64, from *account.Private) TX {
+	return must(NewBurnE(chain, amount, fee, nonce, from))
+}
+
+// NewBurnE is like NewBurn but returns signing errors.
+func NewBurnE(chain, amount, fee, nonce uint64, from *account.Private) (TX, error) {
+	tx := TX{
+		Version:   CurrentVersion,
+		Chain:     chain,
+		Type:      TypeBurn,
+		Amount:    amount,
+		Fee:       fee,
+		Timestamp: Now(),
+		Nonce:     nonce,
+		Sender:    from.Address(),
+		Recipient: make([]byte, AddressSize),
+		Data:      []byte{},
+	}
+	return signed(tx, from)
+}
+
+// NewTransferWithMemo creates a new transfer carrying a memo encrypted to memoKey, the recipient's
+// encryption public key. The memo is part of the data field and therefore covered by the fee and the proof.
+func NewTransferWithMemo(chain, amount, fee, nonce uint64, from *account.Private, to account.Account, memoKey, memo []byte) (TX, error) {
+	if len(memo) > MaxMemoSize {
+		return TX{}, errors.Errorf("Memo exceeds %d bytes", MaxMemoSize)
+	}
+	ciphertext, err := account.EncryptFor(memoKey, memo)
+	if err != nil {
+		return TX{}, errors.Wrap(err, "Could not encrypt memo")
+	}
+	return NewTransferWithData(chain, amount, fee, nonce, from, to, append(append([]byte{}, memoMarker...), ciphertext...))
+}
+
+// NewTransferWithData creates a new transfer carrying the given data payload.
+// The data is covered by the fee and the proof.
+func NewTransferWithData(chain, amount, fee, nonce uint64, from *account.Private, to account.Account, data []byte) (TX, error) {
+	if len(data) > MaxTxDataSize {
+		return TX{}, errors.Errorf("Data exceeds %d bytes", MaxTxDataSize)
+	}
+	tx, err := NewTransferE(chain, amount, fee, nonce, from, to)
+	if err != nil {
+		return TX{}, err
+	}
+	tx.Data = append([]byte{}, data...)
+	return signed(tx, from)
+}
+
+// signed sets the proof of the transaction to its partial hash signed by priv.
+func signed(tx TX, priv *account.Private) (TX, error) {
+	proof, err := priv.SignE(tx.PartialHash())
+	if err != nil {
+		return TX{}, errors.Wrap(err, "Could not sign transaction")
+	}
+	tx.Proof = proof
+	return tx, nil
+}
+
+// must panics on constructor errors.
+func must(tx TX, err error) TX {
+	if err != nil {
+		panic(err)
+	}
 	return tx
 }
+
+// Memo decrypts the transfer memo with the recipient's private key.
+func (tx TX) Memo(priv *account.Private) ([]byte, error) {
+	if !tx.HasMemo() {
+		return nil, errors.New("Transaction has no memo")
+	}
+	return priv.Decrypt(tx.Data[len(memoMarker):])
+}
@@ -1,7 +1,14 @@
 package transaction
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"math"
+	"math/rand"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/lnsp/txledger/ledger/account"
@@ -15,3 +22,567 @@ func TestTransaction(t *testing.T) {
 		t.Error("TX.Bytes not inversible")
 	}
 }
+
+func TestTransactionHashPinned(t *testing.T) {
+	from := account.NewPrivateFromSeed([]byte("from"))
+	to := account.NewPrivateFromSeed([]byte("to"))
+	tx := NewTransfer(12, 100, 1000, 1, from, to)
+	tx.Timestamp = 1500000000
+	tx.Proof = from.Sign(tx.PartialHash())
+	if !from.Verify(tx.PartialHash(), tx.Proof) {
+		t.Error("Deterministic proof should verify")
+	}
+	const pinned = "bf87e80efb842670c07a439eec9cf8478f91ceb12e1e8304ae580e0a2e25b271"
+	if got := hex.EncodeToString(tx.Hash()); got != pinned {
+		t.Errorf("Transfer hash should be pinned, got %s", got)
+	}
+}
+
+func TestMalformedBytes(t *testing.T) {
+	p := account.NewPrivate()
+	encoded := NewTransfer(12, 100, 1000, 1, p, p).Bytes()
+	oversized := append([]byte{}, encoded...)
+	// The sender length prefix follows the version byte and six fixed fields.
+	binary.LittleEndian.PutUint64(oversized[1+6*8:], uint64(len(encoded)))
+	for name, b := range map[string][]byte{
+		"empty":     nil,
+		"truncated": encoded[:len(encoded)-1],
+		"trailing":  append(append([]byte{}, encoded...), 0),
+		"oversized": oversized,
+		"version":   append([]byte{CurrentVersion + 1}, encoded[1:]...),
+	} {
+		if _, err := New().SetBytesE(b); err == nil {
+			t.Errorf("SetBytesE should reject %s transactions", name)
+		}
+	}
+
+	tx := NewTransfer(12, 100, 1000, 1, p, p)
+	tx.Data = []byte{1}
+	shifted := tx
+	shifted.Recipient, shifted.Data = append(append([]byte{}, tx.Recipient...), 1), nil
+	if bytes.Equal(tx.PartialHash(), shifted.PartialHash()) {
+		t.Error("PartialHash should bind the field boundaries")
+	}
+
+	coinbase := NewCoinbase(12, p, 100)
+	coinbase.Sender = nil
+	coinbase.Proof = p.Sign(coinbase.PartialHash())
+	if err := coinbase.Validate(account.NewAddressTree(), 100, 0); err == nil {
+		t.Error("Validate should reject coinbases without a zero sender address")
+	}
+}
+
+func TestApplyTransfer(t *testing.T) {
+	from, to := account.NewPrivate(), account.NewPrivate()
+	addresses := account.NewAddressTree()
+	addresses.ReplaceOrInsert(account.AddressTreeItem{Address: from.Address(), Account: from, Funds: 5000})
+	addresses.ReplaceOrInsert(account.AddressTreeItem{Address: to.Address(), Account: to, Funds: 100})
+	if !NewTransfer(12, 1000, 600, 1, from, to).Apply(addresses) {
+		t.Fatal("Transfer should apply")
+	}
+	funds := func(acc account.Account) uint64 {
+		return addresses.Get(account.AddressTreeItem{Address: acc.Address()}).(account.AddressTreeItem).Funds
+	}
+	if f := funds(from); f != 3400 {
+		t.Errorf("Sender should have 3400 left, got %d", f)
+	}
+	if f := funds(to); f != 1100 {
+		t.Errorf("Recipient should have 1100, got %d", f)
+	}
+}
+
+func TestApplySelfTransfer(t *testing.T) {
+	from := account.NewPrivate()
+	addresses := account.NewAddressTree()
+	addresses.ReplaceOrInsert(account.AddressTreeItem{Address: from.Address(), Account: from, Funds: 5000})
+	if !NewTransfer(12, 1000, 600, 1, from, from).Apply(addresses) {
+		t.Fatal("Self-transfer should apply")
+	}
+	item := addresses.Get(account.AddressTreeItem{Address: from.Address()}).(account.AddressTreeItem)
+	if item.Funds != 4400 || item.Nonce != 1 {
+		t.Errorf("Self-transfer should only pay the fee, got funds %d and nonce %d", item.Funds, item.Nonce)
+	}
+	if NewTransfer(12, 5000, 600, 2, from, from).Apply(addresses) {
+		t.Error("Self-transfer should not exceed the available funds")
+	}
+}
+
+func TestApplyCoinbaseMismatch(t *testing.T) {
+	miner, other := account.NewPrivate(), account.NewPrivate()
+	addresses := account.NewAddressTree()
+	addresses.ReplaceOrInsert(account.AddressTreeItem{Address: miner.Address(), Account: miner, Funds: 10})
+
+	tx := NewCoinbase(0, miner, 50)
+	tx.Data = other.PublicKeyBytes()
+	if tx.Apply(addresses) {
+		t.Error("Coinbase with data not matching the existing account should not apply")
+	}
+	if funds := addresses.Get(account.AddressTreeItem{Address: miner.Address()}).(account.AddressTreeItem).Funds; funds != 10 {
+		t.Errorf("Miner should still hold 10, got %d", funds)
+	}
+	if !NewCoinbase(0, miner, 50).Apply(addresses) {
+		t.Error("Coinbase with matching data should apply")
+	}
+}
+
+func TestTransferReplay(t *testing.T) {
+	from, to := account.NewPrivate(), account.NewPrivate()
+	addresses := account.NewAddressTree()
+	addresses.ReplaceOrInsert(account.AddressTreeItem{Address: from.Address(), Account: from, Funds: 5000})
+	addresses.ReplaceOrInsert(account.AddressTreeItem{Address: to.Address(), Account: to})
+	if NewTransfer(12, 100, 600, 2, from, to).Apply(addresses) {
+		t.Error("Transfer skipping a nonce should not apply")
+	}
+	transfer := NewTransfer(12, 100, 600, 1, from, to)
+	if !transfer.Apply(addresses) {
+		t.Fatal("Transfer should apply")
+	}
+	if transfer.Apply(addresses) {
+		t.Error("Replayed transfer should not apply")
+	}
+	item := addresses.Get(account.AddressTreeItem{Address: from.Address()}).(account.AddressTreeItem)
+	if item.Nonce != 1 || item.Funds != 4300 {
+		t.Errorf("Sender should have nonce 1 and 4300 funds, got %d and %d", item.Nonce, item.Funds)
+	}
+}
+
+func TestBytesRandomLengths(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	field := func(max int) []byte {
+		b := make([]byte, rng.Intn(max+1))
+		rng.Read(b)
+		return b
+	}
+	for i := 0; i < 1000; i++ {
+		tx := TX{
+			Version:   Version3,
+			Chain:     rng.Uint64(),
+			Type:      rng.Uint64(),
+			Amount:    rng.Uint64(),
+			Fee:       rng.Uint64(),
+			Timestamp: rng.Uint64(),
+			Nonce:     rng.Uint64(),
+			Sender:    field(2 * AddressSize),
+			Recipient: field(2 * AddressSize),
+			Proof:     field(2 * KeyPairSize),
+			Data:      field(MaxTxDataSize),
+		}
+		// Decoding into a transaction with differently sized fields must not matter.
+		decoded := TX{Sender: make([]byte, 3), Data: make([]byte, 7)}.SetBytes(tx.Bytes())
+		if !reflect.DeepEqual(tx, decoded) {
+			t.Fatalf("TX with field sizes %d/%d/%d/%d not inversible", len(tx.Sender), len(tx.Recipient), len(tx.Proof), len(tx.Data))
+		}
+	}
+}
+
+func TestVersions(t *testing.T) {
+	p := account.NewPrivate()
+	v1 := NewAccount(12, p)
+	v1.Version = Version1
+	v1.Proof = p.Sign(v1.PartialHash())
+	v2 := NewAccount(12, p)
+	v2.Version = Version2
+	v2.Proof = p.Sign(v2.PartialHash())
+	v3 := NewTransfer(12, 100, 1000, 7, p, p)
+
+	stream := bytes.NewBuffer([]byte{})
+	for _, tx := range []TX{v1, v2, v3} {
+		txBytes := tx.Bytes()
+		binary.Write(stream, binary.LittleEndian, uint64(len(txBytes)))
+		stream.Write(txBytes)
+	}
+	for _, expected := range []TX{v1, v2, v3} {
+		var size uint64
+		binary.Read(stream, binary.LittleEndian, &size)
+		txBytes := make([]byte, size)
+		stream.Read(txBytes)
+		decoded := New().SetBytes(txBytes)
+		if !reflect.DeepEqual(expected, decoded) {
+			t.Errorf("Version %d transaction not inversible", expected.Version)
+		}
+	}
+
+	bumped := v1
+	bumped.Version = Version2
+	if reflect.DeepEqual(v1.PartialHash(), bumped.PartialHash()) {
+		t.Error("PartialHash should cover the version")
+	}
+	replayed := v3
+	replayed.Nonce++
+	if reflect.DeepEqual(v3.PartialHash(), replayed.PartialHash()) {
+		t.Error("PartialHash should cover the nonce")
+	}
+	MinVersions[13] = Version2
+	defer delete(MinVersions, 13)
+	if MinVersion(12) != VersionLegacy || MinVersion(13) != Version2 {
+		t.Error("MinVersion should respect per-chain overrides")
+	}
+}
+
+func TestTransferMemo(t *testing.T) {
+	sender, recipient, other := account.NewPrivate(), account.NewPrivate(), account.NewPrivate()
+	memo := []byte("invoice 42")
+	key, err := recipient.EncryptionPublicKey()
+	if err != nil {
+		t.Fatal("Could not get encryption key:", err)
+	}
+	tx, err := NewTransferWithMemo(12, 100, 1000, 1, sender, account.NewPublic(recipient.PublicKeyBytes()), key, memo)
+	if err != nil {
+		t.Fatal("NewTransferWithMemo should not fail:", err)
+	}
+	if !sender.Verify(tx.PartialHash(), tx.Proof) {
+		t.Error("Proof should cover the memo")
+	}
+	if !strings.HasSuffix(tx.String(), "[encrypted memo]") {
+		t.Error("String should mark the encrypted memo")
+	}
+	decoded := New().SetBytes(tx.Bytes())
+	plaintext, err := decoded.Memo(recipient)
+	if err != nil || !reflect.DeepEqual(plaintext, memo) {
+		t.Error("Recipient should decrypt the memo:", err)
+	}
+	if _, err := decoded.Memo(other); err == nil {
+		t.Error("Third party should not decrypt the memo")
+	}
+	if _, err := NewTransferWithMemo(12, 100, 1000, 1, sender, recipient, key, make([]byte, MaxMemoSize+1)); err == nil {
+		t.Error("NewTransferWithMemo should reject oversized memos")
+	}
+	if _, err := NewTransferWithMemo(12, 100, 1000, 1, sender, recipient, key, make([]byte, MaxMemoSize)); err != nil {
+		t.Error("NewTransferWithMemo should accept memos of the maximum size:", err)
+	}
+	plain, err := NewTransferWithData(12, 100, 1000, 1, sender, recipient, []byte("invoice 42"))
+	if err != nil {
+		t.Fatal("Could not create transfer:", err)
+	}
+	if plain.HasMemo() || strings.Contains(plain.String(), "encrypted memo") {
+		t.Error("Plain data should not be marked as encrypted memo")
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	cases := []struct {
+		decimals uint8
+		raw      uint64
+		text     string
+	}{
+		{6, 12340000, "12.340000"},
+		{6, 0, "0.000000"},
+		{6, 1, "0.000001"},
+		{2, 18446744073709551615, "184467440737095516.15"},
+		{0, 42, "42"},
+	}
+	for _, c := range cases {
+		if text := (ChainParams{Decimals: c.decimals}).FormatAmount(c.raw); text != c.text {
+			t.Errorf("FormatAmount(%d) with %d decimals should be %s, got %s", c.raw, c.decimals, c.text, text)
+		}
+	}
+	if FormatAmount(12340000) != "12.340000" {
+		t.Error("FormatAmount should use the configured decimals")
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	params := ChainParams{Decimals: 6}
+	valid := map[string]uint64{
+		"12.34":          12340000,
+		"12":             12000000,
+		".5":             500000,
+		"0.000001":       1,
+		"1.2300000000":   1230000,
+		"007.000000":     7000000,
+		"18446744073709": 18446744073709000000,
+	}
+	for text, raw := range valid {
+		if parsed, err := params.ParseAmount(text); err != nil || parsed != raw {
+			t.Errorf("ParseAmount(%q) should be %d, got %d (%v)", text, raw, parsed, err)
+		}
+		if parsed, _ := params.ParseAmount(params.FormatAmount(raw)); parsed != raw {
+			t.Errorf("ParseAmount should invert FormatAmount for %d, got %d", raw, parsed)
+		}
+	}
+	for _, text := range []string{"", ".", "1.", "0.0000001", "1.0000015", "-1", "1e6", "1.2.3", "18446744073710", " 1"} {
+		if _, err := params.ParseAmount(text); err == nil {
+			t.Errorf("ParseAmount(%q) should fail", text)
+		}
+	}
+}
+
+func TestBurn(t *testing.T) {
+	owner, other := account.NewPrivate(), account.NewPrivate()
+	fee := EstimateFee(0, 0)
+	addresses := account.NewAddressTree()
+	addresses.ReplaceOrInsert(account.AddressTreeItem{Address: owner.Address(), Account: owner, Funds: 1400 + fee})
+	burn := NewBurn(12, 1000, fee, 1, owner)
+	if !burn.VerifyProof(addresses) || !burn.VerifyFees(0, 0) {
+		t.Fatal("Burn should verify")
+	}
+	forged := NewBurn(12, 1000, fee, 1, other)
+	forged.Sender = owner.Address()
+	if forged.VerifyProof(addresses) {
+		t.Error("Burn signed by another account should not verify")
+	}
+	if NewBurn(12, 1401, fee, 1, owner).Apply(addresses) {
+		t.Error("Burn exceeding the funds should not apply")
+	}
+	if NewBurn(12, 10, fee, 1, other).Apply(addresses) {
+		t.Error("Burn from unknown account should not apply")
+	}
+	if !burn.Apply(addresses) {
+		t.Fatal("Burn should apply")
+	}
+	if burn.Apply(addresses) {
+		t.Error("Replayed burn should not apply")
+	}
+	item := addresses.Get(account.AddressTreeItem{Address: owner.Address()}).(account.AddressTreeItem)
+	if item.Funds != 400 || addresses.Len() != 1 {
+		t.Errorf("Burn should debit the sender without crediting anyone, got %d funds", item.Funds)
+	}
+	if !strings.HasPrefix(burn.String(), "TX Burn") {
+		t.Error("Burn should have a readable string representation")
+	}
+}
+
+func TestFeeSchedule(t *testing.T) {
+	if DefaultFeeSchedule.Calculate(10, 256) != CalculateFee(10, 256) {
+		t.Error("DefaultFeeSchedule should match CalculateFee")
+	}
+	FeeSchedules[9] = FeeSchedule{Base: 4 * BaseFee, SizeScalar: FeeSizeScalar, ComplexityScalar: FeeComplexityScalar, Epoch: FeeEpoch}
+	defer delete(FeeSchedules, 9)
+	p := account.NewPrivate()
+	fee := EstimateFee(0, 0)
+	if !NewTransfer(12, 10, fee, 1, p, p).VerifyFees(0, 0) {
+		t.Error("Default fee should be sufficient on chains with the default schedule")
+	}
+	if NewTransfer(9, 10, fee, 1, p, p).VerifyFees(0, 0) {
+		t.Error("Default fee should be insufficient on chains with a higher base fee")
+	}
+	if !NewTransfer(9, 10, FeeScheduleFor(9).Estimate(0, 0), 1, p, p).VerifyFees(0, 0) {
+		t.Error("Fee calculated from the chain's schedule should be sufficient")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	owner, other := account.NewPrivate(), account.NewPrivate()
+	addresses := account.NewAddressTree()
+	addresses.ReplaceOrInsert(account.AddressTreeItem{Address: owner.Address(), Account: owner, Funds: 5000})
+	fee := EstimateFee(0, 0)
+	cases := []struct {
+		name    string
+		tx      func() TX
+		message string
+	}{
+		{"version", func() TX { tx := NewAccount(12, owner); tx.Version = CurrentVersion + 1; return tx }, "Unsupported version"},
+		{"overflow", func() TX { return NewTransfer(12, math.MaxUint64, fee, 1, owner, other) }, "overflow"},
+		{"proof size", func() TX { tx := NewAccount(12, owner); tx.Proof = tx.Proof[:10]; return tx }, "Proof should be"},
+		{"coinbase fee", func() TX { tx := NewCoinbase(12, owner, 10); tx.Fee = 1; return tx }, "should not pay a fee"},
+		{"account value", func() TX { tx := NewAccount(12, owner); tx.Amount = 1; return tx }, "should not carry value"},
+		{"type", func() TX { tx := NewAccount(12, owner); tx.Type = 42; return tx }, "Unknown type"},
+		{"sender size", func() TX { tx := NewTransfer(12, 10, fee, 1, owner, other); tx.Sender = tx.Sender[:4]; return tx }, "Sender should be"},
+		{"recipient size", func() TX { tx := NewTransfer(12, 10, fee, 1, owner, other); tx.Recipient = nil; return tx }, "Recipient should be"},
+		{"public key size", func() TX { tx := NewCoinbase(12, owner, 10); tx.Data = tx.Data[1:]; return tx }, "Public key should be"},
+		{"zero amount", func() TX { return NewTransfer(12, 0, fee, 1, owner, other) }, "Amount should be positive"},
+		{"data size", func() TX {
+			tx := NewBurn(12, 10, fee, 1, owner)
+			tx.Data = make([]byte, MaxTxDataSize+1)
+			return tx
+		}, "Data exceeds"},
+		{"fees", func() TX { return NewTransfer(12, 10, fee-1, 1, owner, other) }, "Fees are insufficient"},
+		{"reward", func() TX { return NewCoinbase(12, owner, 101) }, "Fees are insufficient"},
+		{"proof", func() TX { tx := NewTransfer(12, 10, fee, 1, owner, other); tx.Amount++; return tx }, "Proof is invalid"},
+		{"valid transfer", func() TX { return NewTransfer(12, 10, fee, 1, owner, other) }, ""},
+		{"valid coinbase", func() TX { return NewCoinbase(12, other, 100) }, ""},
+	}
+	for _, c := range cases {
+		err := c.tx().Validate(addresses, 100, 0)
+		if c.message == "" && err != nil {
+			t.Errorf("%s: Validate should pass, got %v", c.name, err)
+		} else if c.message != "" && (err == nil || !strings.Contains(err.Error(), c.message)) {
+			t.Errorf("%s: Validate should fail with %q, got %v", c.name, c.message, err)
+		}
+	}
+	if err := NewTransfer(12, 10, fee-1, 1, owner, other).Validate(addresses, 100, 0); !errors.Is(err, ErrBadFee) {
+		t.Error("Validate should fail with ErrBadFee, got", err)
+	}
+	forged := NewTransfer(12, 10, fee, 1, owner, other)
+	forged.Amount++
+	if err := forged.Validate(addresses, 100, 0); !errors.Is(err, ErrBadProof) {
+		t.Error("Validate should fail with ErrBadProof, got", err)
+	}
+}
+
+func TestTransferWithData(t *testing.T) {
+	from, to := account.NewPrivate(), account.NewPrivate()
+	addresses := account.NewAddressTree()
+	addresses.ReplaceOrInsert(account.AddressTreeItem{Address: from.Address(), Account: from, Funds: 5000})
+	data := []byte("invoice 2017-0042")
+	tx, err := NewTransferWithData(12, 10, EstimateFee(uint64(len(data)), 0), 1, from, to, data)
+	if err != nil {
+		t.Fatal("NewTransferWithData should not fail:", err)
+	}
+	decoded := New().SetBytes(tx.Bytes())
+	if !reflect.DeepEqual(tx, decoded) || !bytes.Equal(decoded.Data, data) {
+		t.Error("Transfer data should survive Bytes/SetBytes")
+	}
+	if err := decoded.Validate(addresses, 0, 0); err != nil {
+		t.Error("Transfer with data should validate, got", err)
+	}
+	decoded.Data[0]++
+	if err := decoded.Validate(addresses, 0, 0); err == nil {
+		t.Error("Tampered data should invalidate the proof")
+	}
+	if _, err := NewTransferWithData(12, 10, 0, 1, from, to, make([]byte, MaxTxDataSize+1)); err == nil {
+		t.Error("NewTransferWithData should reject oversized data")
+	}
+}
+
+func TestAddAmounts(t *testing.T) {
+	if sum, ok := AddAmounts(math.MaxUint64-3, 1, 2); !ok || sum != math.MaxUint64 {
+		t.Errorf("AddAmounts should sum up to the maximum, got %d", sum)
+	}
+	if _, ok := AddAmounts(math.MaxUint64-3, 2, 2); ok {
+		t.Error("AddAmounts should report overflow")
+	}
+
+	from, to := account.NewPrivate(), account.NewPrivate()
+	addresses := account.NewAddressTree()
+	addresses.ReplaceOrInsert(account.AddressTreeItem{Address: from.Address(), Account: from, Funds: 5000})
+	addresses.ReplaceOrInsert(account.AddressTreeItem{Address: to.Address(), Account: to, Funds: math.MaxUint64 - 10})
+	if NewTransfer(0, 11, 0, 1, from, to).Apply(addresses) {
+		t.Error("Transfer overflowing the recipient's funds should not apply")
+	}
+}
+
+func TestNow(t *testing.T) {
+	prev := Now
+	defer func() { Now = prev }()
+	Now = func() uint64 { return 1500000000 }
+	from, to := account.NewPrivate(), account.NewPrivate()
+	for _, tx := range []TX{NewCoinbase(0, from, 1), NewAccount(0, from), NewTransfer(0, 1, 1, 1, from, to), NewBurn(0, 1, 1, 1, from)} {
+		if tx.Timestamp != 1500000000 {
+			t.Errorf("Transaction should use the injected clock, got %d", tx.Timestamp)
+		}
+	}
+}
+
+func TestSize(t *testing.T) {
+	from, to := account.NewPrivate(), account.NewPrivate()
+	memo, err := NewTransferWithData(0, 10, 0, 1, from, to, []byte("memo"))
+	if err != nil {
+		t.Fatal("Could not create transfer:", err)
+	}
+	for _, tx := range []TX{NewCoinbase(0, from, 1), NewAccount(0, from), NewTransfer(0, 1, 1, 1, from, to), NewBurn(0, 1, 1, 1, from), memo} {
+		if tx.Size() != uint64(len(tx.Bytes())) {
+			t.Errorf("Size should match the encoding, got %d instead of %d", tx.Size(), len(tx.Bytes()))
+		}
+	}
+	if fee := EstimateFee(4, 0); fee != CalculateFee(memo.Size(), 0) {
+		t.Errorf("EstimateFee should price the full transfer size, got %d", fee)
+	}
+
+	legacy := NewTransfer(0, 10, CalculateFee(0, 0), 1, from, to)
+	legacy.Version = Version3
+	if !legacy.VerifyFees(0, 0) {
+		t.Error("Version3 transfers should only pay for their data")
+	}
+	legacy.Version = Version4
+	if legacy.VerifyFees(0, 0) {
+		t.Error("Version4 transfers should pay for their full size")
+	}
+}
+
+func TestConstructorErrors(t *testing.T) {
+	from, to, destroyed := account.NewPrivate(), account.NewPrivate(), account.NewPrivate()
+	destroyed.Destroy()
+	constructors := map[string]func() (TX, error){
+		"coinbase": func() (TX, error) { return NewCoinbaseE(1, destroyed, 10) },
+		"account":  func() (TX, error) { return NewAccountE(1, destroyed) },
+		"transfer": func() (TX, error) { return NewTransferE(1, 10, 1, 1, destroyed, to) },
+		"burn":     func() (TX, error) { return NewBurnE(1, 10, 1, 1, destroyed) },
+		"data":     func() (TX, error) { return NewTransferWithData(1, 10, 1, 1, destroyed, to, []byte("data")) },
+	}
+	for name, constructor := range constructors {
+		if _, err := constructor(); err == nil {
+			t.Errorf("Constructing a %s should fail if signing fails", name)
+		}
+	}
+	addresses := account.NewAddressTree()
+	addresses.ReplaceOrInsert(account.AddressTreeItem{Address: from.Address(), Account: from})
+	tx, err := NewTransferE(1, 10, 1, 1, from, to)
+	if err != nil || !tx.VerifyProof(addresses) {
+		t.Error("NewTransferE should produce a valid transfer:", err)
+	}
+}
+
+func TestVerifyProofMalformedKey(t *testing.T) {
+	acc := account.NewPrivate()
+	for _, data := range [][]byte{nil, {}, acc.PublicKeyBytes()[:10]} {
+		for _, tx := range []TX{NewCoinbase(1, acc, 10), NewAccount(1, acc)} {
+			tx.Data = data
+			if tx.VerifyProof(account.NewAddressTree()) {
+				t.Errorf("Proof with a %d byte key should not verify", len(data))
+			}
+			if tx.Apply(account.NewAddressTree()) {
+				t.Errorf("Transaction with a %d byte key should not apply", len(data))
+			}
+		}
+	}
+}
+
+func TestEd25519Transfer(t *testing.T) {
+	sender, recipient := account.NewPrivateEd25519(), account.NewPrivateEd25519()
+	tree := account.NewAddressTree()
+	for _, tx := range []TX{NewCoinbase(1, sender, 1000), NewAccount(1, recipient)} {
+		if err := tx.Validate(tree, 1000, 0); err != nil {
+			t.Fatal("Ed25519 key announcement should be valid:", err)
+		}
+		if !tx.VerifyProof(tree) || !tx.Apply(tree) {
+			t.Fatal("Ed25519 key announcement should verify and apply")
+		}
+	}
+	transfer := NewTransfer(1, 10, EstimateFee(0, 0), 1, sender, recipient)
+	if !transfer.VerifyProof(tree) {
+		t.Error("Ed25519 transfer should verify")
+	}
+
+	p256 := account.NewPrivate()
+	forged := account.NewAddressTree()
+	forged.ReplaceOrInsert(account.AddressTreeItem{Address: sender.Address(), Account: account.NewPublic(p256.PublicKeyBytes()), Funds: 1000})
+	if transfer.VerifyProof(forged) {
+		t.Error("P-256 verifier should reject an Ed25519 transfer")
+	}
+}
+
+func TestSchemeMismatch(t *testing.T) {
+	sender, ed := account.NewPrivate(), account.NewPrivateEd25519()
+	if _, err := NewTransferE(1, 10, EstimateFee(0, 0), 1, sender, ed); !errors.Is(err, ErrSchemeMismatch) {
+		t.Fatal("Transfer to a recipient with a different scheme should fail, got", err)
+	}
+
+	tree := account.NewAddressTree()
+	for _, tx := range []TX{NewCoinbase(1, sender, 1000), NewAccount(1, ed)} {
+		if err := tx.Validate(tree, 1000, 0); err != nil {
+			t.Fatal("Key announcement should be valid:", err)
+		}
+		tx.Apply(tree)
+	}
+	transfer := NewTransfer(1, 10, EstimateFee(0, 0), 1, sender, account.NewPrivate())
+	transfer.Recipient = ed.Address()
+	transfer.Proof = sender.Sign(transfer.PartialHash())
+	if err := transfer.Validate(tree, 1000, 0); !errors.Is(err, ErrSchemeMismatch) {
+		t.Error("Validate should reject a transfer across signature schemes, got", err)
+	}
+}
+
+func TestMessageSignatureIsNotProof(t *testing.T) {
+	sender, recipient := account.NewPrivate(), account.NewPrivate()
+	tree := account.NewAddressTree()
+	tree.ReplaceOrInsert(account.AddressTreeItem{Address: sender.Address(), Account: sender, Funds: 1000})
+	transfer := NewTransfer(1, 10, EstimateFee(0, 0), 1, sender, recipient)
+	for _, msg := range [][]byte{transfer.PartialHash(), transfer.Bytes()} {
+		forged := transfer
+		forged.Proof = account.SignMessage(sender, msg)
+		if forged.VerifyProof(tree) {
+			t.Error("Message signature should not validate as a transaction proof")
+		}
+	}
+}
@@ -1,15 +1,33 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
-	"path"
+	"os/signal"
+	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/lnsp/txledger/ledger"
 	"github.com/lnsp/txledger/ledger/account"
 	"github.com/lnsp/txledger/ledger/account/container"
+	"github.com/lnsp/txledger/ledger/block"
+	"github.com/lnsp/txledger/ledger/storage"
+	"github.com/lnsp/txledger/ledger/transaction"
+	"github.com/lnsp/txledger/mempool"
+	"github.com/lnsp/txledger/p2p"
+	"github.com/lnsp/txledger/server"
 	"github.com/micro/cli"
+	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
@@ -19,115 +37,867 @@ const (
 	flagAccount    = "account"
 	flagChain      = "chain"
 	flagComplexity = "complexity"
+	flagBlock      = "block"
+	flagJSON       = "json"
+	flagMempool    = "mempool"
+	flagWorkers    = "workers"
+	flagVerifyPoW  = "verify-pow"
+	flagTo         = "to"
+	flagAmount     = "amount"
+	flagFee        = "fee"
+	flagMnemonic   = "mnemonic"
+	flagListen     = "listen"
+	flagPeer       = "peer"
+	flagP2P        = "p2p"
+	flagPublic     = "public"
+	flagEd25519    = "ed25519"
+	flagMessage    = "message"
+	flagSignature  = "signature"
+	flagConfig     = "config"
 
 	fileAccount     = "accounts"
 	fileLedger      = "ledger"
+	fileSnapshot    = "ledger.snapshot"
+	fileMempool     = "mempool"
+	fileGenesis     = "genesis.json"
 	categoryAccount = "Account"
 	categoryChain   = "Blockchain"
 )
 
-func createAccount(c *cli.Context) {
-	accountFolder := path.Join(c.GlobalString(flagDatastore), fileAccount)
-	// Ensure folder exists
-	_, err := os.Stat(accountFolder)
-	if err != nil && os.IsNotExist(err) {
-		err := os.MkdirAll(accountFolder, 0755)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Could not create account folder")
-			os.Exit(1)
+const (
+	// mnemonicChallengeWords is the number of words asked for to confirm a new mnemonic.
+	mnemonicChallengeWords = 3
+	// mnemonicChallengeAttempts is the number of times the challenge is repeated on wrong answers.
+	mnemonicChallengeAttempts = 3
+)
+
+// openStorage returns the storage holding the ledger, its snapshot and the mempool.
+// It defaults to the datastore directory and may be replaced, e.g. by an in-memory storage.
+var openStorage = func(c *cli.Context) storage.Storage {
+	return storage.Dir(c.GlobalString(flagDatastore))
+}
+
+// registerGenesis registers the genesis config stored next to the ledger by earlier versions, if any.
+// Ledger files now record the config themselves.
+func registerGenesis(c *cli.Context) {
+	configFile, err := openStorage(c).Read(fileGenesis)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not open genesis config:", err)
+		os.Exit(1)
+	}
+	defer configFile.Close()
+	cfg, err := ledger.ReadGenesisConfig(configFile)
+	if err == nil {
+		err = cfg.Register()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid genesis config:", err)
+		os.Exit(1)
+	}
+}
+
+func loadLedger(c *cli.Context) *ledger.Ledger {
+	registerGenesis(c)
+	store := openStorage(c)
+	if snapshotFile, err := store.Read(fileSnapshot); err == nil {
+		chain, err := readFast(store, snapshotFile)
+		snapshotFile.Close()
+		if err == nil {
+			return chain
 		}
+		fmt.Fprintln(os.Stderr, "Ignoring address snapshot:", err)
 	}
-	// Request keyphrase
-	fmt.Fprint(os.Stdout, "Please enter a passphrase: ")
+	chain := ledger.New(0)
+	if err := chain.ReadFromStorage(store, fileLedger); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not read ledger:", err)
+		os.Exit(1)
+	}
+	return chain
+}
+
+// readFast reads the stored ledger using the address snapshot instead of replaying all blocks.
+func readFast(store storage.Storage, snapshot io.Reader) (*ledger.Ledger, error) {
+	ledgerFile, err := store.Read(fileLedger)
+	if err != nil {
+		return nil, err
+	}
+	defer ledgerFile.Close()
+	chain := ledger.New(0)
+	return chain, chain.ReadFast(ledgerFile, snapshot)
+}
+
+func parseAddress(s string) ([]byte, error) {
+	return account.ParseAddress(s)
+}
+
+func loadUnverifiedLedger(c *cli.Context) *ledger.Ledger {
+	registerGenesis(c)
+	ledgerFile, err := openStorage(c).Read(fileLedger)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not open ledger file:", err)
+		os.Exit(1)
+	}
+	defer ledgerFile.Close()
+	chain := ledger.New(0)
+	if err := chain.ReadUnverified(ledgerFile); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not read ledger:", err)
+		os.Exit(1)
+	}
+	return chain
+}
+
+func saveLedger(c *cli.Context, chain *ledger.Ledger) {
+	if err := chain.WriteToStorage(openStorage(c), fileLedger); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not save ledger:", err)
+		os.Exit(1)
+	}
+	saveSnapshot(c, chain)
+}
+
+// saveSnapshot replaces the address snapshot stored next to the ledger. The snapshot is
+// encoded in memory first so that a failed encoding leaves the stored snapshot untouched.
+func saveSnapshot(c *cli.Context, chain *ledger.Ledger) {
+	var snapshot bytes.Buffer
+	if err := chain.WriteSnapshot(&snapshot); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not write snapshot:", err)
+		return
+	}
+	snapshotFile, err := openStorage(c).Write(fileSnapshot)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not open snapshot file:", err)
+		os.Exit(1)
+	}
+	_, err = snapshot.WriteTo(snapshotFile)
+	if closeErr := snapshotFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not write snapshot:", err)
+	}
+}
+
+func openKeystore(c *cli.Context) *container.Keystore {
+	return container.OpenStorage(openStorage(c), fileAccount)
+}
+
+func unlockAccount(c *cli.Context, address string) *account.Private {
+	account, err := openKeystore(c).Load(address)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read account container:", err)
+		os.Exit(1)
+	}
+	fmt.Fprint(os.Stdout, "Please enter the passphrase: ")
+	passphrase, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not read passphrase")
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stdout)
+	privateKey, err := account.Unlock(passphrase)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not unlock account:", err)
+		os.Exit(1)
+	}
+	return privateKey
+}
+
+func changePassphrase(c *cli.Context) {
+	keystore := openKeystore(c)
+	cont, err := keystore.Load(c.String(flagAccount))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to read account container:", err)
+		os.Exit(1)
+	}
+	fmt.Fprint(os.Stdout, "Please enter the current passphrase: ")
+	old, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not read passphrase")
+		os.Exit(1)
+	}
+	fmt.Fprint(os.Stdout, "\nPlease enter the new passphrase: ")
 	passphrase, err := terminal.ReadPassword(int(syscall.Stdin))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Could not read passphrase")
 		os.Exit(1)
 	}
-	private := account.NewPrivate()
-	cont, err := container.New(passphrase, private)
+	fmt.Fprintln(os.Stdout)
+	changed, err := cont.ChangePassphrase(old, passphrase)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Could not build container:", err)
+		fmt.Fprintln(os.Stderr, "Could not change passphrase:", err)
 		os.Exit(1)
 	}
-	// Store private key container
-	accountPath := path.Join(accountFolder, private.String()+".json")
-	if err := container.WriteToFile(cont, accountPath); err != nil {
+	if err := keystore.Store(c.String(flagAccount), changed); err != nil {
 		fmt.Fprintln(os.Stderr, "Could not write container:", err)
 		os.Exit(1)
 	}
-	fmt.Fprintln(os.Stdout, "\nCreated account with address", private.String())
+	fmt.Fprintln(os.Stdout, "Changed passphrase of account", c.String(flagAccount))
 }
 
-func showFunds(c *cli.Context) {
+// openMempool returns the storage and name of the mempool, a file given by the mempool flag
+// or the mempool next to the ledger.
+func openMempool(c *cli.Context) (storage.Storage, string) {
+	if mempoolPath := c.String(flagMempool); mempoolPath != "" {
+		return storage.Dir(""), mempoolPath
+	}
+	return openStorage(c), fileMempool
+}
 
+func readMempool(store storage.Storage, name string) ([]transaction.TX, error) {
+	mempoolFile, err := store.Read(name)
+	if err != nil {
+		return nil, err
+	}
+	defer mempoolFile.Close()
+	var txs []transaction.TX
+	for {
+		var txSize uint64
+		if err := binary.Read(mempoolFile, binary.LittleEndian, &txSize); err == io.EOF {
+			return txs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		txBytes := make([]byte, txSize)
+		if _, err := io.ReadFull(mempoolFile, txBytes); err != nil {
+			return nil, err
+		}
+		tx, err := transaction.New().SetBytesE(txBytes)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, tx)
+	}
 }
 
-func transferFunds(c *cli.Context) {
+// mempoolEntry encodes the transaction as read by readMempool.
+func mempoolEntry(tx transaction.TX) []byte {
+	txBytes := tx.Bytes()
+	entry := make([]byte, 8, 8+len(txBytes))
+	binary.LittleEndian.PutUint64(entry, uint64(len(txBytes)))
+	return append(entry, txBytes...)
+}
 
+func appendMempool(store storage.Storage, name string, tx transaction.TX) error {
+	entry := mempoolEntry(tx)
+	mempoolFile, err := store.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return storeBlob(store, name, entry)
+	} else if err != nil {
+		return err
+	}
+	if _, err := mempoolFile.Seek(0, io.SeekEnd); err != nil {
+		mempoolFile.Close()
+		return err
+	}
+	if _, err := mempoolFile.Write(entry); err != nil {
+		mempoolFile.Close()
+		return err
+	}
+	return mempoolFile.Close()
 }
 
-func viewAccountHistory(c *cli.Context) {
+// pruneMempool rewrites the mempool without the transactions of the given hashes, e.g. once
+// they have been mined. A missing mempool is left alone.
+func pruneMempool(store storage.Storage, name string, hashes [][]byte) error {
+	txs, err := readMempool(store, name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	pruned := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		pruned[string(hash)] = true
+	}
+	var raw []byte
+	for _, tx := range txs {
+		if !pruned[string(tx.Hash())] {
+			raw = append(raw, mempoolEntry(tx)...)
+		}
+	}
+	return storeBlob(store, name, raw)
+}
 
+// storeBlob stores the raw data under the given name, e.g. the mempool next to the ledger.
+func storeBlob(store storage.Storage, name string, raw []byte) error {
+	blob, err := store.Write(name)
+	if err != nil {
+		return err
+	}
+	if _, err := blob.Write(raw); err != nil {
+		blob.Close()
+		return err
+	}
+	return blob.Close()
 }
 
-func initializeChain(c *cli.Context) {
-	datapath := c.GlobalString(flagDatastore)
-	_, err := os.Stat(datapath)
-	if err != nil && os.IsNotExist(err) {
-		err := os.MkdirAll(datapath, 0755)
+func createAccount(c *cli.Context) {
+	if c.Bool(flagMnemonic) {
+		if c.Bool(flagEd25519) {
+			fmt.Fprintln(os.Stderr, "Mnemonic accounts always use P-256 keys")
+			os.Exit(1)
+		}
+		createMnemonicAccount(c)
+		return
+	}
+	if c.Bool(flagEd25519) {
+		storeAccount(openKeystore(c), account.NewPrivateEd25519())
+		return
+	}
+	storeAccount(openKeystore(c), account.NewPrivate())
+}
+
+// createMnemonicAccount generates a mnemonic and only stores the derived account once the
+// user has confirmed a few of its words.
+func createMnemonicAccount(c *cli.Context) {
+	keystore := openKeystore(c)
+	mnemonic, err := account.NewMnemonic(account.MnemonicEntropyBits, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not generate mnemonic:", err)
+		os.Exit(1)
+	}
+	challenge, err := account.NewMnemonicChallenge(mnemonic, mnemonicChallengeWords, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not create mnemonic challenge:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stdout, "Write down the following mnemonic, it is required to restore the account:")
+	fmt.Fprintf(os.Stdout, "\n  %s\n\n", mnemonic)
+	stdin := bufio.NewReader(os.Stdin)
+	err = challenge.Confirm(func(position int) (string, error) {
+		fmt.Fprintf(os.Stdout, "Please enter word #%d: ", position+1)
+		return stdin.ReadString('\n')
+	}, mnemonicChallengeAttempts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not confirm mnemonic:", err)
+		os.Exit(1)
+	}
+	fmt.Fprint(os.Stdout, "Please enter the mnemonic passphrase (optional): ")
+	seedPassphrase, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not read mnemonic passphrase")
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stdout)
+	storeAccount(keystore, account.NewPrivateFromMnemonic(mnemonic, string(seedPassphrase)))
+}
+
+func listAccounts(c *cli.Context) {
+	for _, address := range openKeystore(c).List() {
+		fmt.Fprintln(os.Stdout, address)
+	}
+}
+
+func importAccount(c *cli.Context) {
+	if key := c.String(flagPublic); key != "" {
+		pub, err := container.Container{PublicKey: strings.TrimPrefix(key, "0x")}.Public()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid public key:", err)
+			os.Exit(1)
+		}
+		address, err := openKeystore(c).SaveWatchOnly(pub)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "Could not create datapath")
+			fmt.Fprintln(os.Stderr, "Could not store account:", err)
 			os.Exit(1)
 		}
+		fmt.Fprintln(os.Stdout, "Stored watch-only account with address", address)
+		return
 	}
-	ledgerPath := path.Join(datapath, fileLedger)
-	_, err = os.Stat(ledgerPath)
-	if err == nil && !c.Bool(flagForce) {
-		fmt.Fprintf(os.Stderr, "Chain already exists, override with -%s flag\n", flagForce)
+	if !c.Bool(flagMnemonic) {
+		fmt.Fprintf(os.Stderr, "Nothing to import, use the -%s or -%s flag\n", flagMnemonic, flagPublic)
 		os.Exit(1)
 	}
-	accountPath := path.Join(datapath, fileAccount, c.String(flagAccount)+".json")
-	account, err := container.ReadFromFile(accountPath)
+	keystore := openKeystore(c)
+	fmt.Fprint(os.Stdout, "Please enter the mnemonic: ")
+	mnemonic, err := terminal.ReadPassword(int(syscall.Stdin))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to read account container:", err)
+		fmt.Fprintln(os.Stderr, "Could not read mnemonic")
 		os.Exit(1)
 	}
-	fmt.Fprint(os.Stdout, "Please enter the passphrase: ")
+	fmt.Fprint(os.Stdout, "\nPlease enter the mnemonic passphrase (optional): ")
+	seedPassphrase, err := terminal.ReadPassword(int(syscall.Stdin))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not read mnemonic passphrase")
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stdout)
+	storeAccount(keystore, account.NewPrivateFromMnemonic(string(mnemonic), string(seedPassphrase)))
+}
+
+func storeAccount(keystore *container.Keystore, private *account.Private) {
+	// Request keyphrase
+	fmt.Fprint(os.Stdout, "Please enter a passphrase: ")
 	passphrase, err := terminal.ReadPassword(int(syscall.Stdin))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Could not read passphrase")
 		os.Exit(1)
 	}
-	fmt.Fprintln(os.Stdout)
-	privateKey, err := account.Unlock(passphrase)
+	address, err := keystore.Save(private, passphrase)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not store account:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stdout, "\nStored account with address", address)
+}
+
+func showFunds(c *cli.Context) {
+	if c.String(flagAccount) == "" {
+		for _, item := range loadLedger(c).Accounts() {
+			fmt.Fprintf(os.Stdout, "%s %s\n", account.ChecksumAddress(item.Address), transaction.FormatAmount(item.Funds))
+		}
+		return
+	}
+	address, err := parseAddress(c.String(flagAccount))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid account address:", err)
+		os.Exit(1)
+	}
+	funds, ok := loadLedger(c).Balance(address)
+	if !ok {
+		fmt.Fprintln(os.Stderr, "Account is not known to the chain")
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "Funds of %s: %s\n", account.ChecksumAddress(address), transaction.FormatAmount(funds))
+}
+
+func signMessage(c *cli.Context) {
+	priv := unlockAccount(c, c.String(flagAccount))
+	defer priv.Destroy()
+	fmt.Fprintln(os.Stdout, hex.EncodeToString(account.SignMessage(priv, []byte(c.String(flagMessage)))))
+}
+
+func verifyMessage(c *cli.Context) {
+	address, err := parseAddress(c.String(flagAccount))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid address:", err)
+		os.Exit(1)
+	}
+	signature, err := hex.DecodeString(strings.TrimPrefix(c.String(flagSignature), "0x"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid signature:", err)
+		os.Exit(1)
+	}
+	var pub account.Account
+	if key := c.String(flagPublic); key != "" {
+		if pub, err = (container.Container{PublicKey: strings.TrimPrefix(key, "0x")}).Public(); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid public key:", err)
+			os.Exit(1)
+		}
+	} else {
+		item := loadLedger(c).Addresses.Get(account.AddressTreeItem{Address: address})
+		if item == nil {
+			fmt.Fprintf(os.Stderr, "Account is not known to the chain, use the -%s flag\n", flagPublic)
+			os.Exit(1)
+		}
+		pub = item.(account.AddressTreeItem).Account
+	}
+	if !account.VerifyMessage(address, []byte(c.String(flagMessage)), signature, pub) {
+		fmt.Fprintln(os.Stderr, "Signature is invalid")
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stdout, "Signature is valid")
+}
+
+func transferFunds(c *cli.Context) {
+	recipient, err := parseAddress(c.String(flagTo))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid recipient address:", err)
+		os.Exit(1)
+	}
+	amount, err := transaction.ParseAmount(c.String(flagAmount))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid amount:", err)
+		os.Exit(1)
+	}
+	chain := loadLedger(c)
+	fee := block.RecommendFee(chain.Blocks)
+	if c.String(flagFee) != "" {
+		if fee, err = transaction.ParseAmount(c.String(flagFee)); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid fee:", err)
+			os.Exit(1)
+		}
+	}
+	recipientItem := chain.Addresses.Get(account.AddressTreeItem{Address: recipient})
+	if recipientItem == nil {
+		fmt.Fprintln(os.Stderr, "Recipient is not known to the chain")
+		os.Exit(1)
+	}
+	sender := unlockAccount(c, c.String(flagAccount))
+	defer sender.Destroy()
+	senderItem := chain.Addresses.Get(account.AddressTreeItem{Address: sender.Address()})
+	if senderItem == nil {
+		fmt.Fprintln(os.Stderr, "Sender is not known to the chain")
+		os.Exit(1)
+	}
+	nonce := senderItem.(account.AddressTreeItem).Nonce + 1
+	tx, err := transaction.NewTransferE(chain.Chain, amount, fee, nonce, sender, recipientItem.(account.AddressTreeItem).Account)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not create transfer:", err)
+		os.Exit(1)
+	}
+	if err := chain.Simulate(tx); err != nil {
+		fmt.Fprintln(os.Stderr, "Transfer would be rejected:", err)
+		os.Exit(1)
+	}
+	store, name := openMempool(c)
+	if err := appendMempool(store, name, tx); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not write mempool:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "Queued transfer of %s to %s with fee %s\n", transaction.FormatAmount(amount), account.ChecksumAddress(recipient), transaction.FormatAmount(fee))
+}
+
+func viewAccountHistory(c *cli.Context) {
+	address, err := parseAddress(c.String(flagAccount))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Could not unlock account")
+		fmt.Fprintln(os.Stderr, "Invalid account address:", err)
 		os.Exit(1)
 	}
-	id, complexity := uint64(c.Int(flagChain)), uint64(c.Int(flagComplexity))
-	fmt.Fprintf(os.Stdout, "Init chain with ID %d and start complexity %d\n", id, complexity)
-	chain := ledger.New(id)
-	chain.Init(complexity, privateKey)
-	ledgerFile, err := os.OpenFile(ledgerPath, os.O_CREATE|os.O_RDWR, 0755)
+	chain := loadLedger(c)
+	var balance uint64
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "BLOCK\tTIME\tTYPE\tCOUNTERPARTY\tAMOUNT\tFEE\tBALANCE")
+	for _, tx := range chain.TransactionsFor(address) {
+		_, index, _ := chain.FindTransaction(tx.Hash())
+		sent, received := bytes.Equal(tx.Sender, address), bytes.Equal(tx.Recipient, address)
+		var (
+			kind, counterparty string
+			amount             string
+		)
+		switch tx.Type {
+		case transaction.TypeCoinbase:
+			if !received {
+				continue
+			}
+			kind, counterparty = "coinbase", "-"
+			amount = "+" + transaction.FormatAmount(tx.Amount)
+			balance += tx.Amount
+		case transaction.TypeAccount:
+			if !sent {
+				continue
+			}
+			kind, counterparty, amount = "account", "-", "-"
+		case transaction.TypeTransfer:
+			if !sent && !received {
+				continue
+			}
+			kind = "transfer"
+			if sent {
+				counterparty = account.ChecksumAddress(tx.Recipient)
+				amount = "-" + transaction.FormatAmount(tx.Amount)
+				balance -= tx.Amount + tx.Fee
+			}
+			if received {
+				counterparty = account.ChecksumAddress(tx.Sender)
+				amount = "+" + transaction.FormatAmount(tx.Amount)
+				balance += tx.Amount
+			}
+			if sent && received {
+				counterparty, amount = "self", transaction.FormatAmount(0)
+			}
+		case transaction.TypeBurn:
+			if !sent {
+				continue
+			}
+			kind, counterparty = "burn", "-"
+			amount = "-" + transaction.FormatAmount(tx.Amount)
+			balance -= tx.Amount + tx.Fee
+		default:
+			continue
+		}
+		fee := "-"
+		if sent && (tx.Type == transaction.TypeTransfer || tx.Type == transaction.TypeBurn) {
+			fee = transaction.FormatAmount(tx.Fee)
+		}
+		timestamp := time.Unix(int64(tx.Timestamp), 0).Format(time.RFC3339)
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", index, timestamp, kind, counterparty, amount, fee, transaction.FormatAmount(balance))
+	}
+	writer.Flush()
+}
+
+func initializeChain(c *cli.Context) {
+	store := openStorage(c)
+	if ledgerFile, err := store.Read(fileLedger); err == nil {
+		ledgerFile.Close()
+		if !c.Bool(flagForce) {
+			fmt.Fprintf(os.Stderr, "Chain already exists, override with -%s flag\n", flagForce)
+			os.Exit(1)
+		}
+	}
+	cfg := ledger.GenesisConfig{
+		Chain:           uint64(c.Int(flagChain)),
+		Complexity:      uint64(c.Int(flagComplexity)),
+		TargetInterval:  block.TargetInterval,
+		RewardBase:      block.RewardBase,
+		HalvingInterval: block.HalvingInterval,
+	}
+	if configPath := c.String(flagConfig); configPath != "" {
+		raw, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not read genesis config:", err)
+			os.Exit(1)
+		}
+		if cfg, err = ledger.ReadGenesisConfig(bytes.NewReader(raw)); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid genesis config:", err)
+			os.Exit(1)
+		}
+	}
+	if err := store.Remove(fileGenesis); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, "Could not remove genesis config:", err)
+		os.Exit(1)
+	}
+	privateKey := unlockAccount(c, c.String(flagAccount))
+	fmt.Fprintf(os.Stdout, "Init chain with ID %d and start complexity %d\n", cfg.Chain, cfg.Complexity)
+	var (
+		chain *ledger.Ledger
+		err   error
+	)
+	if c.String(flagConfig) != "" {
+		chain, err = ledger.InitFromConfig(cfg, privateKey)
+	} else {
+		chain = ledger.New(cfg.Chain)
+		err = chain.Init(cfg.Complexity, privateKey)
+	}
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Could not open ledger file: ", err)
+		fmt.Fprintln(os.Stderr, "Could not initialize chain:", err)
 		os.Exit(1)
 	}
-	chain.WriteTo(ledgerFile)
-	ledgerFile.Close()
+	saveLedger(c, chain)
+}
+
+type blockSummary struct {
+	Index        uint64   `json:"index"`
+	Fingerprint  string   `json:"fingerprint"`
+	Complexity   uint64   `json:"complexity"`
+	Timestamp    uint64   `json:"timestamp"`
+	Transactions int      `json:"transactions"`
+	Reward       uint64   `json:"reward"`
+	Details      []string `json:"details,omitempty"`
+}
+
+func summarizeBlock(b block.Block, details bool) blockSummary {
+	reward, _ := block.BlockReward(b.Chain, b.Index, b.Complexity, b.Data)
+	summary := blockSummary{
+		Index:        b.Index,
+		Fingerprint:  b.Fingerprint(),
+		Complexity:   b.Complexity,
+		Timestamp:    b.Timestamp,
+		Transactions: len(b.Data),
+		Reward:       reward,
+	}
+	if details {
+		summary.Details = make([]string, len(b.Data))
+		for i, tx := range b.Data {
+			summary.Details[i] = tx.String()
+		}
+	}
+	return summary
+}
+
+func inspectProofOfWork(c *cli.Context) {
+	chain := loadUnverifiedLedger(c)
+	failed := chain.NonCompliant()
+	for _, index := range failed {
+		b := chain.Blocks[index]
+		fmt.Fprintf(os.Stdout, "Block %d (%s) does not meet hash quality %d\n", index, b.Fingerprint(), block.HashQuality(b.Complexity))
+	}
+	if len(failed) > 0 {
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stdout, "All %d blocks satisfy their proof of work\n", chain.Size())
 }
 
 func inspectBlocks(c *cli.Context) {
+	if c.Bool(flagVerifyPoW) {
+		inspectProofOfWork(c)
+		return
+	}
+	chain := loadLedger(c)
+	summaries := []blockSummary{}
+	if index := c.Int(flagBlock); index >= 0 {
+		if uint64(index) >= chain.Size() {
+			fmt.Fprintf(os.Stderr, "Block %d does not exist, chain height is %d\n", index, chain.Size())
+			os.Exit(1)
+		}
+		summaries = append(summaries, summarizeBlock(chain.Blocks[index], true))
+	} else {
+		chain.Iterate(func(b block.Block) bool {
+			summaries = append(summaries, summarizeBlock(b, false))
+			return true
+		})
+	}
+	if c.Bool(flagJSON) {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(summaries); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not encode blocks:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "INDEX\tFINGERPRINT\tCOMPLEXITY\tTIME\tTXS\tREWARD")
+	for _, summary := range summaries {
+		timestamp := time.Unix(int64(summary.Timestamp), 0).Format(time.RFC3339)
+		fmt.Fprintf(writer, "%d\t%s\t%d\t%s\t%d\t%s\n", summary.Index, summary.Fingerprint, summary.Complexity, timestamp, summary.Transactions, transaction.FormatAmount(summary.Reward))
+	}
+	writer.Flush()
+	for _, summary := range summaries {
+		for _, detail := range summary.Details {
+			fmt.Fprintln(os.Stdout, detail)
+		}
+	}
+	fmt.Fprintln(os.Stdout, chain)
+}
 
+func serveLedger(c *cli.Context) {
+	chain := loadLedger(c)
+	if addr := c.String(flagP2P); addr != "" {
+		go func() {
+			if err := p2p.ListenAndServe(addr, chain, nil, nil); err != nil {
+				fmt.Fprintln(os.Stderr, "Could not serve peers:", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	fmt.Fprintln(os.Stdout, "Serving ledger on", c.String(flagListen))
+	if err := server.Serve(chain, nil, c.String(flagListen)); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not serve ledger:", err)
+		os.Exit(1)
+	}
+}
+
+func syncLedger(c *cli.Context) {
+	chain := loadLedger(c)
+	if err := p2p.Sync(c.String(flagPeer), chain); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not sync ledger:", err)
+		os.Exit(1)
+	}
+	saveLedger(c, chain)
+	tip, _ := chain.Tip()
+	fmt.Fprintf(os.Stdout, "Synced to height %d, tip %s\n", chain.Size(), tip.Fingerprint())
 }
 
 func mineBlocks(c *cli.Context) {
+	workers := c.Int(flagWorkers)
+	if workers < 0 {
+		fmt.Fprintln(os.Stderr, "Number of workers must be at least 1, or 0 for one per CPU")
+		os.Exit(1)
+	}
+	chain := loadLedger(c)
+	miner := unlockAccount(c, c.String(flagAccount))
+	pool := mempool.New(chain.AddressTree(), chain.ExpectedComplexity())
+	mempoolStore, mempoolName := openMempool(c)
+	txs, err := readMempool(mempoolStore, mempoolName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, "Could not read mempool:", err)
+		os.Exit(1)
+	}
+	for _, tx := range txs {
+		if err := pool.Add(tx); err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping transaction %x: %v\n", tx.Hash(), err)
+		}
+	}
+	if addr := c.String(flagListen); addr != "" {
+		go func() {
+			if err := server.Serve(chain, pool, addr); err != nil {
+				fmt.Fprintln(os.Stderr, "Could not serve ledger:", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	broadcaster := p2p.NewBroadcaster(chain)
+	if peers := c.String(flagPeer); peers != "" {
+		for _, peer := range strings.Split(peers, ",") {
+			if err := broadcaster.Connect(peer); err != nil {
+				fmt.Fprintf(os.Stderr, "Skipping peer %s: %v\n", peer, err)
+			}
+		}
+	}
+	// Rewrite the ledger once so that legacy files can be appended to block by block.
+	saveLedger(c, chain)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	for {
+		next := chain.Next()
+		next = next.Append(transaction.NewCoinbase(chain.Chain, miner, 0))
+		var included [][]byte
+		for _, tx := range pool.Take(block.MaxBlockBytes, block.MaxTxPerBlockFor(chain.Chain)-1) {
+			if !next.Fits(tx) {
+				break
+			}
+			next = next.Append(tx)
+			included = append(included, tx.Hash())
+		}
+		reward, err := block.BlockReward(next.Chain, next.Index, next.Complexity, next.Data[1:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not compute block reward:", err)
+			os.Exit(1)
+		}
+		if next.Data[0], err = transaction.NewCoinbaseE(chain.Chain, miner, reward); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not create coinbase:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stdout, "Mining block %d with complexity %d\n", next.Index, next.Complexity)
+		solved := make(chan block.Block, 1)
+		start := time.Now()
+		go func() {
+			solved <- block.FindWithProgress(next, workers, func(attempts uint64) {
+				rate := float64(attempts) / time.Since(start).Seconds()
+				fmt.Fprintf(os.Stdout, "\r%d hashes tried, %.0f H/s", attempts, rate)
+			})
+		}()
+		select {
+		case <-interrupt:
+			fmt.Fprintln(os.Stdout, "\nInterrupted, stopped mining")
+			return
+		case b := <-solved:
+			appendBlock(c, chain, b)
+			broadcaster.Publish(b)
+			fmt.Fprintln(os.Stdout, "\nFound", b)
+		}
+		pool.Remove(included)
+		if err := pruneMempool(mempoolStore, mempoolName, included); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not prune mempool:", err)
+		}
+		pool.Update(chain.AddressTree(), chain.ExpectedComplexity())
+	}
+}
 
+// appendBlock appends the block to the chain and persists it by appending to the stored ledger.
+func appendBlock(c *cli.Context, chain *ledger.Ledger, b block.Block) {
+	if err := chain.AppendToStorage(openStorage(c), fileLedger, b); err != nil {
+		fmt.Fprintln(os.Stderr, "Could not append block:", err)
+		os.Exit(1)
+	}
+	saveSnapshot(c, chain)
 }
 
 func verifyChain(c *cli.Context) {
-
+	chain := loadUnverifiedLedger(c)
+	if chain.Size() < 1 {
+		fmt.Fprintln(os.Stderr, "Ledger is empty")
+		os.Exit(1)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+	size := chain.Size()
+	err := chain.VerifyAll(ctx, func(index uint64) {
+		fmt.Fprintf(os.Stdout, "\rVerified %d of %d blocks", index+1, size)
+	})
+	fmt.Fprintln(os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stdout, "Verified", chain)
 }
 
 func main() {
@@ -148,24 +918,142 @@ func main() {
 			Category: categoryAccount,
 			Usage:    "create a new account",
 			Action:   createAccount,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  flagEd25519,
+					Usage: "use an Ed25519 key instead of P-256",
+				},
+				cli.BoolFlag{
+					Name:  flagMnemonic,
+					Usage: "derive the account from a new mnemonic, confirmed before the account is stored",
+				},
+			},
+		},
+		{
+			Name:     "list",
+			Category: categoryAccount,
+			Usage:    "list stored accounts",
+			Action:   listAccounts,
+		},
+		{
+			Name:     "import",
+			Category: categoryAccount,
+			Usage:    "import an existing account",
+			Action:   importAccount,
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  flagMnemonic,
+					Usage: "derive the account from a mnemonic and optional passphrase",
+				},
+				cli.StringFlag{
+					Name:  flagPublic,
+					Usage: "hex-encoded public key to import as a watch-only account",
+				},
+			},
+		},
+		{
+			Name:     "passwd",
+			Category: categoryAccount,
+			Usage:    "change the passphrase of an account",
+			Action:   changePassphrase,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  flagAccount,
+					Usage: "account address to change the passphrase of",
+				},
+			},
 		},
 		{
 			Name:     "funds",
 			Category: categoryAccount,
 			Usage:    "display funds associated with your accounts",
 			Action:   showFunds,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  flagAccount,
+					Usage: "account address to show funds of, lists all accounts if omitted",
+				},
+			},
 		},
 		{
 			Name:     "transfer",
 			Category: categoryAccount,
 			Usage:    "transfer funds from your account",
 			Action:   transferFunds,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  flagAccount,
+					Usage: "private account sending the funds",
+				},
+				cli.StringFlag{
+					Name:  flagTo,
+					Usage: "recipient account address",
+				},
+				cli.StringFlag{
+					Name:  flagAmount,
+					Usage: "amount to transfer, e.g. 12.34",
+				},
+				cli.StringFlag{
+					Name:  flagFee,
+					Usage: "fee to pay, defaults to a fee recommended by recent blocks",
+				},
+				cli.StringFlag{
+					Name:  flagMempool,
+					Usage: "file of pending transactions to append to",
+				},
+			},
+		},
+		{
+			Name:     "sign",
+			Category: categoryAccount,
+			Usage:    "sign a message to prove control of your account",
+			Action:   signMessage,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  flagAccount,
+					Usage: "private account signing the message",
+				},
+				cli.StringFlag{
+					Name:  flagMessage,
+					Usage: "message to sign",
+				},
+			},
+		},
+		{
+			Name:     "verify-msg",
+			Category: categoryAccount,
+			Usage:    "verify a signed message",
+			Action:   verifyMessage,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  flagAccount,
+					Usage: "address of the signing account",
+				},
+				cli.StringFlag{
+					Name:  flagMessage,
+					Usage: "signed message",
+				},
+				cli.StringFlag{
+					Name:  flagSignature,
+					Usage: "hex-encoded message signature",
+				},
+				cli.StringFlag{
+					Name:  flagPublic,
+					Usage: "hex-encoded public key, defaults to the key known to the chain",
+				},
+			},
 		},
 		{
 			Name:     "book",
 			Category: categoryAccount,
 			Usage:    "view transaction history",
 			Action:   viewAccountHistory,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  flagAccount,
+					Usage: "account address to list transactions for",
+				},
+			},
 		},
 		{
 			Name:     "init",
@@ -189,6 +1077,10 @@ func main() {
 					Name:  flagComplexity,
 					Usage: "starting complexity for genesis block",
 				},
+				cli.StringFlag{
+					Name:  flagConfig,
+					Usage: "genesis config file pinning the chain parameters, overrides chain and complexity",
+				},
 			},
 		},
 		{
@@ -196,6 +1088,21 @@ func main() {
 			Category: categoryChain,
 			Usage:    "view chain state",
 			Action:   inspectBlocks,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  flagBlock,
+					Usage: "inspect a single block in detail",
+					Value: -1,
+				},
+				cli.BoolFlag{
+					Name:  flagJSON,
+					Usage: "emit structured JSON output",
+				},
+				cli.BoolFlag{
+					Name:  flagVerifyPoW,
+					Usage: "only check the proof of work of every block",
+				},
+			},
 		},
 		{
 			Name:     "verify",
@@ -208,6 +1115,57 @@ func main() {
 			Category: categoryChain,
 			Usage:    "find new blocks and get rewarded",
 			Action:   mineBlocks,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  flagAccount,
+					Usage: "private account receiving the block rewards",
+				},
+				cli.StringFlag{
+					Name:  flagMempool,
+					Usage: "file of pending transactions to include, defaults to the mempool in the datastore",
+				},
+				cli.IntFlag{
+					Name:  flagWorkers,
+					Usage: "number of mining workers, 0 for one per CPU",
+				},
+				cli.StringFlag{
+					Name:  flagListen,
+					Usage: "address to serve the ledger on, accepting submitted transactions",
+				},
+				cli.StringFlag{
+					Name:  flagPeer,
+					Usage: "peers to publish mined blocks to, comma-separated",
+				},
+			},
+		},
+		{
+			Name:     "serve",
+			Category: categoryChain,
+			Usage:    "serve the ledger over a JSON HTTP API",
+			Action:   serveLedger,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  flagListen,
+					Usage: "address to listen on",
+					Value: ":8080",
+				},
+				cli.StringFlag{
+					Name:  flagP2P,
+					Usage: "address to serve blocks to syncing peers on",
+				},
+			},
+		},
+		{
+			Name:     "sync",
+			Category: categoryChain,
+			Usage:    "download new blocks from a peer",
+			Action:   syncLedger,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  flagPeer,
+					Usage: "peer address, e.g. localhost:9090",
+				},
+			},
 		},
 	}
 	app.Run(os.Args)
@@ -0,0 +1,252 @@
+package mempool
+
+import (
+	"bytes"
+	"math/bits"
+	"sort"
+	"sync"
+
+	"github.com/google/btree"
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger/account"
+	"github.com/lnsp/txledger/ledger/transaction"
+)
+
+const (
+	// TxOverhead is the number of bytes a transaction occupies in a block in addition to its own encoding.
+	TxOverhead = 8
+	// SubscriptionBuffer is the number of admitted transactions buffered per subscriber.
+	// Further transactions are dropped until the subscriber catches up.
+	SubscriptionBuffer = 64
+)
+
+// admissible lists the transaction types users may submit. Coinbases are created by the miner
+// during block assembly and never enter the pool.
+var admissible = map[uint64]bool{
+	transaction.TypeAccount:  true,
+	transaction.TypeTransfer: true,
+	transaction.TypeBurn:     true,
+}
+
+// Pool holds pending transactions ordered by fee per byte. Transactions are validated
+// against a snapshot of the address tree taken when the pool is created.
+type Pool struct {
+	mu         sync.Mutex
+	addresses  *btree.BTree
+	complexity uint64
+	entries    map[string]poolItem
+	order      *btree.BTree
+	subs       map[int]chan transaction.TX
+	nextSub    int
+}
+
+type poolItem struct {
+	hash []byte
+	size uint64
+	tx   transaction.TX
+}
+
+// Less orders items by descending fee per byte, breaking ties by hash.
+func (item poolItem) Less(than btree.Item) bool {
+	other := than.(poolItem)
+	hi, lo := bits.Mul64(item.tx.Fee, other.size)
+	otherHi, otherLo := bits.Mul64(other.tx.Fee, item.size)
+	if hi != otherHi || lo != otherLo {
+		return hi > otherHi || (hi == otherHi && lo > otherLo)
+	}
+	return bytes.Compare(item.hash, other.hash) < 0
+}
+
+// New creates an empty pool validating against the given address tree and block complexity.
+func New(addresses *btree.BTree, complexity uint64) *Pool {
+	return &Pool{
+		addresses:  addresses.Clone(),
+		complexity: complexity,
+		entries:    make(map[string]poolItem),
+		order:      btree.New(2),
+		subs:       make(map[int]chan transaction.TX),
+	}
+}
+
+// Add validates the transaction's proof and fees and adds it to the pool.
+func (p *Pool) Add(tx transaction.TX) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !admissible[tx.Type] {
+		name, _ := transaction.TypeName(tx.Type)
+		return errors.Errorf("Transactions of type %q can not be submitted", name)
+	}
+	hash := tx.Hash()
+	if _, ok := p.entries[string(hash)]; ok {
+		return errors.New("Transaction already in pool")
+	}
+	if err := p.valid(tx); err != nil {
+		return err
+	}
+	item := poolItem{hash: hash, size: tx.Size() + TxOverhead, tx: tx}
+	p.entries[string(hash)] = item
+	p.order.ReplaceOrInsert(item)
+	for _, sub := range p.subs {
+		select {
+		case sub <- tx:
+		default:
+		}
+	}
+	return nil
+}
+
+// Subscribe returns a channel receiving each transaction admitted to the pool and a function
+// cancelling the subscription. Admission never blocks on slow subscribers, transactions
+// exceeding the subscriber's buffer are dropped.
+func (p *Pool) Subscribe() (<-chan transaction.TX, func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	id := p.nextSub
+	p.nextSub++
+	sub := make(chan transaction.TX, SubscriptionBuffer)
+	p.subs[id] = sub
+	var once sync.Once
+	return sub, func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			delete(p.subs, id)
+			close(sub)
+		})
+	}
+}
+
+// Take returns at most maxCount transactions with the highest fee per byte that fit into maxBytes.
+// Transfers from the same sender are only returned in nonce order and as long as the sender's
+// funds cover all of them. The transactions remain in the pool until they are removed.
+func (p *Pool) Take(maxBytes uint64, maxCount int) []transaction.TX {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var (
+		txs   []transaction.TX
+		size  uint64
+		taken = make(map[string]bool)
+		next  = make(map[string]uint64)
+		spent = make(map[string]uint64)
+	)
+	for progress := true; progress; {
+		progress = false
+		p.order.Ascend(func(i btree.Item) bool {
+			if len(txs) >= maxCount {
+				return false
+			}
+			item := i.(poolItem)
+			if taken[string(item.hash)] || size+item.size > maxBytes {
+				return true
+			}
+			sender := string(item.tx.Sender)
+			if item.tx.Sequenced() {
+				if _, ok := next[sender]; !ok {
+					next[sender] = p.nonce(item.tx.Sender) + 1
+				}
+				if item.tx.Nonce != next[sender] {
+					return true
+				}
+			}
+			outflow := spent[sender]
+			if item.tx.Type == transaction.TypeTransfer || item.tx.Type == transaction.TypeBurn {
+				var ok bool
+				if outflow, ok = transaction.AddAmounts(outflow, item.tx.Amount, item.tx.Fee); !ok || outflow > p.funds(item.tx.Sender) {
+					return true
+				}
+			}
+			if item.tx.Sequenced() {
+				next[sender]++
+			}
+			spent[sender] = outflow
+			taken[string(item.hash)] = true
+			txs = append(txs, item.tx)
+			size += item.size
+			progress = true
+			return true
+		})
+	}
+	return txs
+}
+
+// Remove drops the transactions with the given hashes, e.g. after they have been mined.
+func (p *Pool) Remove(hashes [][]byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, hash := range hashes {
+		if item, ok := p.entries[string(hash)]; ok {
+			p.order.Delete(item)
+			delete(p.entries, string(hash))
+		}
+	}
+}
+
+// Reinject re-submits transactions displaced from the chain, e.g. by a reorg, ordered by nonce
+// within each sender. Transactions that no longer verify are dropped. It returns the number of
+// transactions admitted to the pool.
+func (p *Pool) Reinject(txs []transaction.TX) int {
+	sorted := append([]transaction.TX{}, txs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if c := bytes.Compare(sorted[i].Sender, sorted[j].Sender); c != 0 {
+			return c < 0
+		}
+		return sorted[i].Nonce < sorted[j].Nonce
+	})
+	admitted := 0
+	for _, tx := range sorted {
+		if p.Add(tx) == nil {
+			admitted++
+		}
+	}
+	return admitted
+}
+
+// Update replaces the snapshot the pool validates against, e.g. after a block has been appended.
+// Transactions that no longer verify against the new snapshot are dropped.
+func (p *Pool) Update(addresses *btree.BTree, complexity uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addresses = addresses.Clone()
+	p.complexity = complexity
+	for hash, item := range p.entries {
+		if p.valid(item.tx) != nil {
+			p.order.Delete(item)
+			delete(p.entries, hash)
+		}
+	}
+}
+
+// Len returns the number of pending transactions.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entries)
+}
+
+// valid checks the transaction against the snapshot.
+func (p *Pool) valid(tx transaction.TX) error {
+	if err := tx.Validate(p.addresses, 0, p.complexity); err != nil {
+		return errors.Wrap(err, "Transaction is invalid")
+	}
+	if tx.Sequenced() && tx.Nonce <= p.nonce(tx.Sender) {
+		return errors.Errorf("Transaction nonce %d has already been used", tx.Nonce)
+	}
+	return nil
+}
+
+// funds returns the sender's funds according to the snapshot.
+func (p *Pool) funds(sender []byte) uint64 {
+	if item := p.addresses.Get(account.AddressTreeItem{Address: sender}); item != nil {
+		return item.(account.AddressTreeItem).Funds
+	}
+	return 0
+}
+
+// nonce returns the last nonce used by the sender according to the snapshot.
+func (p *Pool) nonce(sender []byte) uint64 {
+	if item := p.addresses.Get(account.AddressTreeItem{Address: sender}); item != nil {
+		return item.(account.AddressTreeItem).Nonce
+	}
+	return 0
+}
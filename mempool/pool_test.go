@@ -0,0 +1,177 @@
+package mempool
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/btree"
+
+	"github.com/lnsp/txledger/ledger/account"
+	"github.com/lnsp/txledger/ledger/transaction"
+)
+
+func fundedTree(accounts ...*account.Private) *btree.BTree {
+	tree := account.NewAddressTree()
+	for _, acc := range accounts {
+		tree.ReplaceOrInsert(account.AddressTreeItem{Address: acc.Address(), Account: acc, Funds: 1 << 20})
+	}
+	return tree
+}
+
+func TestPool(t *testing.T) {
+	alice, bob, carol := account.NewPrivate(), account.NewPrivate(), account.NewPrivate()
+	pool := New(fundedTree(alice, bob, carol), 0)
+	fee := transaction.EstimateFee(0, 0)
+
+	low := transaction.NewTransfer(0, 10, fee, 1, alice, carol)
+	high := transaction.NewTransfer(0, 10, 3*fee, 1, bob, carol)
+	mid := transaction.NewTransfer(0, 10, 2*fee, 1, carol, alice)
+	for _, tx := range []transaction.TX{low, high, mid} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal("Could not add transaction:", err)
+		}
+	}
+	if err := pool.Add(high); err == nil {
+		t.Error("Pool should reject duplicate transactions")
+	}
+	if err := pool.Add(transaction.NewTransfer(0, 10, fee-1, 2, alice, carol)); err == nil {
+		t.Error("Pool should reject transactions with insufficient fees")
+	}
+	forged := transaction.NewTransfer(0, 10, fee, 2, alice, carol)
+	forged.Amount++
+	if err := pool.Add(forged); err == nil {
+		t.Error("Pool should reject transactions with invalid proofs")
+	}
+
+	taken := pool.Take(1<<20, 16)
+	if len(taken) != 3 {
+		t.Fatalf("Take should return all 3 transactions, got %d", len(taken))
+	}
+	for i, expected := range []transaction.TX{high, mid, low} {
+		if !bytes.Equal(taken[i].Hash(), expected.Hash()) {
+			t.Errorf("Transaction %d should have fee %d, got %d", i, expected.Fee, taken[i].Fee)
+		}
+	}
+	size := high.Size() + TxOverhead
+	if taken := pool.Take(size, 16); len(taken) != 1 || !bytes.Equal(taken[0].Hash(), high.Hash()) {
+		t.Error("Take should respect the byte budget")
+	}
+
+	if taken := pool.Take(1<<20, 2); len(taken) != 2 || !bytes.Equal(taken[1].Hash(), mid.Hash()) {
+		t.Error("Take should respect the transaction limit")
+	}
+
+	pool.Remove([][]byte{high.Hash(), mid.Hash()})
+	if pool.Len() != 1 {
+		t.Errorf("Pool should hold 1 transaction after removal, got %d", pool.Len())
+	}
+}
+
+func TestPoolNonceOrder(t *testing.T) {
+	alice, bob := account.NewPrivate(), account.NewPrivate()
+	tree := fundedTree(alice, bob)
+	pool := New(tree, 0)
+	fee := transaction.EstimateFee(0, 0)
+	first := transaction.NewTransfer(0, 10, fee, 1, alice, bob)
+	second := transaction.NewTransfer(0, 10, 4*fee, 2, alice, bob)
+	orphan := transaction.NewTransfer(0, 10, 4*fee, 4, alice, bob)
+	for _, tx := range []transaction.TX{first, second, orphan} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal("Could not add transaction:", err)
+		}
+	}
+	taken := pool.Take(1<<20, 16)
+	if len(taken) != 2 || taken[0].Nonce != 1 || taken[1].Nonce != 2 {
+		t.Fatalf("Take should return transfers of a sender in nonce order without gaps, got %d", len(taken))
+	}
+
+	if !first.Apply(tree) {
+		t.Fatal("Transfer should apply")
+	}
+	pool.Update(tree, 0)
+	if pool.Len() != 2 {
+		t.Errorf("Update should drop transactions with used nonces, got %d", pool.Len())
+	}
+	if err := pool.Add(first); err == nil {
+		t.Error("Pool should reject transactions with used nonces")
+	}
+}
+
+func TestPoolChainedOverspend(t *testing.T) {
+	alice, bob := account.NewPrivate(), account.NewPrivate()
+	pool := New(fundedTree(alice, bob), 0)
+	fee := transaction.EstimateFee(0, 0)
+	first := transaction.NewTransfer(0, 700000, fee, 1, alice, bob)
+	second := transaction.NewTransfer(0, 700000, fee, 2, alice, bob)
+	third := transaction.NewTransfer(0, 10, fee, 3, alice, bob)
+	other := transaction.NewTransfer(0, 700000, fee, 1, bob, alice)
+	for _, tx := range []transaction.TX{first, second, third, other} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal("Could not add transaction:", err)
+		}
+	}
+	taken := pool.Take(1<<20, 16)
+	if len(taken) != 2 {
+		t.Fatalf("Take should skip transfers exceeding the sender's funds and their successors, got %d", len(taken))
+	}
+	for _, tx := range taken {
+		if bytes.Equal(tx.Hash(), second.Hash()) || bytes.Equal(tx.Hash(), third.Hash()) {
+			t.Errorf("Take should not return transfer %d over-spending the sender's funds", tx.Nonce)
+		}
+	}
+}
+
+func TestPoolAdmission(t *testing.T) {
+	alice, bob := account.NewPrivate(), account.NewPrivate()
+	pool := New(fundedTree(alice, bob), 0)
+	if err := pool.Add(transaction.NewCoinbase(0, alice, 0)); err == nil {
+		t.Error("Pool should reject coinbase transactions")
+	}
+	if err := pool.Add(transaction.NewTransfer(0, 10, transaction.EstimateFee(0, 0), 1, alice, bob)); err != nil {
+		t.Error("Pool should accept transfers, got", err)
+	}
+	if err := pool.Add(transaction.NewAccount(0, account.NewPrivate())); err != nil {
+		t.Error("Pool should accept account announcements, got", err)
+	}
+}
+
+func TestPoolSubscribe(t *testing.T) {
+	alice, bob := account.NewPrivate(), account.NewPrivate()
+	pool := New(fundedTree(alice, bob), 0)
+	fee := transaction.EstimateFee(0, 0)
+	txs, cancel := pool.Subscribe()
+
+	first := transaction.NewTransfer(0, 10, fee, 1, alice, bob)
+	second := transaction.NewTransfer(0, 10, fee, 1, bob, alice)
+	for _, tx := range []transaction.TX{first, second} {
+		if err := pool.Add(tx); err != nil {
+			t.Fatal("Could not add transaction:", err)
+		}
+	}
+	pool.Add(first)
+	for _, expected := range []transaction.TX{first, second} {
+		if received := <-txs; !bytes.Equal(received.Hash(), expected.Hash()) {
+			t.Error("Subscriber should receive admitted transactions in order")
+		}
+	}
+
+	cancel()
+	cancel()
+	if err := pool.Add(transaction.NewTransfer(0, 10, fee, 2, alice, bob)); err != nil {
+		t.Fatal("Could not add transaction:", err)
+	}
+	if _, ok := <-txs; ok {
+		t.Error("Subscriber should not receive transactions after unsubscribing")
+	}
+
+	slow, cancel := pool.Subscribe()
+	defer cancel()
+	for nonce := uint64(3); nonce < 3+SubscriptionBuffer+8; nonce++ {
+		if err := pool.Add(transaction.NewTransfer(0, 10, fee, nonce, alice, bob)); err != nil {
+			t.Fatal("Admission should not block on slow subscribers:", err)
+		}
+	}
+	if len(slow) != SubscriptionBuffer {
+		t.Errorf("Slow subscriber should have %d buffered transactions, got %d", SubscriptionBuffer, len(slow))
+	}
+}
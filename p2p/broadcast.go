@@ -0,0 +1,90 @@
+package p2p
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger"
+	"github.com/lnsp/txledger/ledger/block"
+)
+
+// PublishTimeout bounds the time a single peer may take to receive a published block.
+const PublishTimeout = 10 * time.Second
+
+// Broadcaster pushes new blocks to a set of peer connections. Connections failing a write
+// are closed and dropped. Each block is published at most once per SeenTTL.
+type Broadcaster struct {
+	mu     sync.Mutex
+	ledger *ledger.Ledger
+	peers  map[net.Conn]bool
+	seen   *SeenCache
+}
+
+// NewBroadcaster creates a broadcaster without peers announcing the given ledger.
+func NewBroadcaster(l *ledger.Ledger) *Broadcaster {
+	return &Broadcaster{
+		ledger: l,
+		peers:  make(map[net.Conn]bool),
+		seen:   NewSeenCache(SeenTTL, SeenSize),
+	}
+}
+
+// Connect dials the peer and adds the connection.
+func (b *Broadcaster) Connect(peerAddr string) error {
+	conn, err := net.Dial("tcp", peerAddr)
+	if err != nil {
+		return errors.Wrap(err, "Could not connect to peer")
+	}
+	if err := b.Add(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	return nil
+}
+
+// Add greets the peer on the connection and adds it. Peers on a different chain are refused.
+func (b *Broadcaster) Add(conn net.Conn) error {
+	if err := writeMessage(conn, msgHello, encode(b.ledger.Chain, b.ledger.Size())); err != nil {
+		return errors.Wrap(err, "Could not greet peer")
+	}
+	var chain, height uint64
+	if err := expect(conn, msgHello, &chain, &height); err != nil {
+		return errors.Wrap(err, "Could not read peer greeting")
+	}
+	if chain != b.ledger.Chain {
+		return errors.Errorf("Peer is on chain %d instead of %d", chain, b.ledger.Chain)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.peers[conn] = true
+	return nil
+}
+
+// Publish sends the block to all peers, dropping peers that can not receive it.
+// It reports false without sending if the block has been published before.
+func (b *Broadcaster) Publish(blk block.Block) bool {
+	if b.seen.Seen(blk.Hash()) {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	payload := blk.Bytes()
+	for conn := range b.peers {
+		conn.SetWriteDeadline(time.Now().Add(PublishTimeout))
+		if err := writeMessage(conn, msgBlock, payload); err != nil {
+			conn.Close()
+			delete(b.peers, conn)
+		}
+	}
+	return true
+}
+
+// Len returns the number of connected peers.
+func (b *Broadcaster) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.peers)
+}
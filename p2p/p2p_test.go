@@ -0,0 +1,344 @@
+package p2p
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnsp/txledger/ledger"
+	"github.com/lnsp/txledger/ledger/account"
+	"github.com/lnsp/txledger/ledger/block"
+	"github.com/lnsp/txledger/ledger/transaction"
+	"github.com/lnsp/txledger/mempool"
+)
+
+func TestRelay(t *testing.T) {
+	var (
+		ledgers      [3]*ledger.Ledger
+		listeners    [3]net.Listener
+		broadcasters [3]*Broadcaster
+	)
+	for i := range ledgers {
+		ledgers[i] = ledger.New(1)
+		if i == 0 {
+			if err := ledgers[0].Init(0, account.NewPrivate()); err != nil {
+				t.Fatal("Could not init ledger:", err)
+			}
+		} else if err := ledgers[i].Append(ledgers[0].Blocks[0]); err != nil {
+			t.Fatal("Could not append genesis:", err)
+		}
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal("Could not listen:", err)
+		}
+		defer ln.Close()
+		listeners[i], broadcasters[i] = ln, NewBroadcaster(ledgers[i])
+		go Serve(ln, ledgers[i], nil, broadcasters[i])
+	}
+	for i, broadcaster := range broadcasters {
+		for j, ln := range listeners {
+			if i == j {
+				continue
+			}
+			if err := broadcaster.Connect(ln.Addr().String()); err != nil {
+				t.Fatal("Could not connect to peer:", err)
+			}
+		}
+	}
+	b := mine(t, ledgers[0])
+	if !broadcasters[0].Publish(b) {
+		t.Fatal("New block should be published")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for i := range broadcasters {
+		for broadcasters[i].seen.Len() == 0 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	for i, broadcaster := range broadcasters {
+		if !bytes.Equal(tipHash(ledgers[i]), b.Hash()) {
+			t.Errorf("Node %d should append the published block, got height %d", i, ledgers[i].Size())
+		}
+		if broadcaster.Publish(b) {
+			t.Errorf("Node %d should relay the block exactly once", i)
+		}
+	}
+}
+
+func TestSeenCacheExpiry(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := NewSeenCache(time.Minute, 2)
+	cache.now = func() time.Time { return now }
+	if cache.Seen([]byte("a")) {
+		t.Error("First sighting should not be seen")
+	}
+	if !cache.Seen([]byte("a")) {
+		t.Error("Second sighting within TTL should be seen")
+	}
+	now = now.Add(2 * time.Minute)
+	if cache.Seen([]byte("a")) {
+		t.Error("Sighting after TTL should not be seen")
+	}
+	cache.Seen([]byte("b"))
+	cache.Seen([]byte("c"))
+	if cache.Len() != 2 {
+		t.Errorf("Cache should be bounded to 2 entries, got %d", cache.Len())
+	}
+	if cache.Seen([]byte("a")) {
+		t.Error("Oldest entry should have been evicted")
+	}
+}
+
+func tipHash(l *ledger.Ledger) []byte {
+	tip, _ := l.Last()
+	return tip.Hash()
+}
+
+func mine(t *testing.T, l *ledger.Ledger, txs ...transaction.TX) block.Block {
+	next := block.NextWithHistory(l.Blocks)
+	reward, err := block.BlockReward(next.Chain, next.Index, next.Complexity, txs)
+	if err != nil {
+		t.Fatal("Could not compute block reward:", err)
+	}
+	next = next.Append(transaction.NewCoinbase(l.Chain, account.NewPrivate(), reward))
+	for _, tx := range txs {
+		next = next.Append(tx)
+	}
+	next = block.Find(next)
+	if err := l.Append(next); err != nil {
+		t.Fatal("Could not append block:", err)
+	}
+	return next
+}
+
+// syncPipe syncs l from peer over an in-memory connection.
+func syncPipe(l, peer *ledger.Ledger) error {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		handle(server, peer, nil, NewSeenCache(SeenTTL, SeenSize), nil)
+	}()
+	return syncPeer(client, l)
+}
+
+func TestSync(t *testing.T) {
+	peer := ledger.New(1)
+	if err := peer.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	for i := 0; i < 3; i++ {
+		mine(t, peer)
+	}
+	l := ledger.New(1)
+	if err := syncPipe(l, peer); err != nil {
+		t.Fatal("Sync into an empty ledger should succeed:", err)
+	}
+	if l.Size() != 4 || !bytes.Equal(tipHash(l), tipHash(peer)) {
+		t.Fatalf("Ledger should match the peer after sync, got height %d", l.Size())
+	}
+	mine(t, peer)
+	if err := syncPipe(l, peer); err != nil {
+		t.Fatal("Sync of a new block should succeed:", err)
+	}
+	if l.Size() != 5 || !bytes.Equal(tipHash(l), tipHash(peer)) {
+		t.Errorf("Ledger should catch up with the peer, got height %d", l.Size())
+	}
+	if err := syncPipe(l, peer); err != nil {
+		t.Error("Sync with a peer at the same height should succeed:", err)
+	}
+}
+
+func TestSyncFork(t *testing.T) {
+	peer := ledger.New(1)
+	if err := peer.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	l := ledger.New(1)
+	if err := l.Append(peer.Blocks[0]); err != nil {
+		t.Fatal("Could not append genesis:", err)
+	}
+	mine(t, l)
+	for i := 0; i < 3; i++ {
+		mine(t, peer)
+	}
+	if err := syncPipe(l, peer); err != nil {
+		t.Fatal("Sync with a heavier fork should succeed:", err)
+	}
+	if l.Size() != 4 || !bytes.Equal(tipHash(l), tipHash(peer)) {
+		t.Errorf("Ledger should switch to the peer's chain, got height %d", l.Size())
+	}
+
+	other := ledger.New(1)
+	if err := other.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	if err := syncPipe(other, peer); err == nil || !strings.Contains(err.Error(), "genesis") {
+		t.Errorf("Sync with a different genesis should fail, got %v", err)
+	}
+}
+
+func TestSyncBatches(t *testing.T) {
+	defer func(batch uint64) { SyncBatch = batch }(SyncBatch)
+	SyncBatch = 2
+	peer := ledger.New(1)
+	if err := peer.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	for i := 0; i < 4; i++ {
+		mine(t, peer)
+	}
+	l := ledger.New(1)
+	if err := syncPipe(l, peer); err != nil {
+		t.Fatal("Sync in batches should succeed:", err)
+	}
+	if l.Size() != 5 || !bytes.Equal(tipHash(l), tipHash(peer)) {
+		t.Fatalf("Ledger should match the peer after sync, got height %d", l.Size())
+	}
+
+	// A peer claiming a height it can not serve only gets its real blocks appended.
+	mine(t, peer)
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		if _, err := readPayload(server, msgHello); err != nil {
+			return
+		}
+		writeMessage(server, msgHello, encode(peer.Chain, ^uint64(0)))
+		for {
+			payload, err := readPayload(server, msgGetBlocks)
+			if err != nil {
+				return
+			}
+			var from, to uint64
+			decode(payload, &from, &to)
+			for i := from; i < to; i++ {
+				b, ok := peer.GetBlockByIndex(i)
+				if !ok {
+					return
+				}
+				writeMessage(server, msgBlock, b.Bytes())
+			}
+		}
+	}()
+	l = ledger.New(1)
+	if err := syncPeer(client, l); err == nil {
+		t.Error("Sync should fail once the peer stops sending blocks")
+	}
+	client.Close()
+	if l.Size() != peer.Size() {
+		t.Errorf("Blocks of completed batches should be appended, got height %d", l.Size())
+	}
+}
+
+func TestSyncChainMismatch(t *testing.T) {
+	peer := ledger.New(1)
+	if err := peer.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	l := ledger.New(2)
+	if err := syncPipe(l, peer); err == nil || !strings.Contains(err.Error(), "chain") {
+		t.Errorf("Sync with a peer on another chain should be refused, got %v", err)
+	}
+	if l.Size() != 0 {
+		t.Error("Refused sync should not append blocks")
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	miner := ledger.New(1)
+	if err := miner.Init(0, account.NewPrivate()); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	peer := ledger.New(1)
+	if err := peer.Append(miner.Blocks[0]); err != nil {
+		t.Fatal("Could not append genesis:", err)
+	}
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		handle(server, peer, nil, NewSeenCache(SeenTTL, SeenSize), nil)
+	}()
+	broadcaster := NewBroadcaster(miner)
+	if err := broadcaster.Add(client); err != nil {
+		t.Fatal("Could not add peer:", err)
+	}
+	for i := 0; i < 3; i++ {
+		broadcaster.Publish(mine(t, miner))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for peer.Size() < miner.Size() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !bytes.Equal(tipHash(peer), tipHash(miner)) {
+		t.Fatalf("Published blocks should propagate to the peer, got height %d", peer.Size())
+	}
+
+	client.Close()
+	broadcaster.Publish(mine(t, miner))
+	if broadcaster.Len() != 0 {
+		t.Error("Dead connections should be dropped on publish")
+	}
+}
+
+func TestBroadcastChainMismatch(t *testing.T) {
+	miner := ledger.New(1)
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		handle(server, ledger.New(2), nil, NewSeenCache(SeenTTL, SeenSize), nil)
+	}()
+	broadcaster := NewBroadcaster(miner)
+	if err := broadcaster.Add(client); err == nil || broadcaster.Len() != 0 {
+		t.Error("Peers on another chain should be refused")
+	}
+}
+
+func TestServeReinject(t *testing.T) {
+	block.ChainRewardBases[3] = 1 << 20
+	defer delete(block.ChainRewardBases, 3)
+	creator, recipient := account.NewPrivate(), account.NewPrivate()
+	l := ledger.New(3)
+	if err := l.Init(16, creator); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	mine(t, l, transaction.NewAccount(l.Chain, recipient))
+	long := ledger.New(3)
+	for _, b := range l.Blocks {
+		if err := long.Append(b); err != nil {
+			t.Fatal("Could not append shared block:", err)
+		}
+	}
+	transfer := transaction.NewTransfer(l.Chain, 1, transaction.EstimateFee(0, l.ExpectedComplexity()), 1, creator, recipient)
+	mine(t, l, transfer)
+	mine(t, long)
+	mine(t, long)
+
+	pool := mempool.New(l.AddressTree(), l.ExpectedComplexity())
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Could not listen:", err)
+	}
+	defer ln.Close()
+	go Serve(ln, l, pool, nil)
+	broadcaster := NewBroadcaster(long)
+	if err := broadcaster.Connect(ln.Addr().String()); err != nil {
+		t.Fatal("Could not connect to peer:", err)
+	}
+	for _, b := range long.Blocks[2:] {
+		broadcaster.Publish(b)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !bytes.Equal(tipHash(l), tipHash(long)) {
+		t.Fatalf("Ledger should switch to the published fork, got height %d", l.Size())
+	}
+	if taken := pool.Take(block.MaxBlockBytes, 16); len(taken) != 1 || !bytes.Equal(taken[0].Hash(), transfer.Hash()) {
+		t.Errorf("Displaced transfer should be reinjected into the pool, got %d", len(taken))
+	}
+}
@@ -0,0 +1,78 @@
+package p2p
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	// SeenTTL is how long relayed blocks are remembered.
+	SeenTTL = 10 * time.Minute
+	// SeenSize is the maximum number of remembered blocks.
+	SeenSize = 4096
+)
+
+// SeenCache remembers recently relayed inventory hashes so that each item is forwarded
+// at most once per TTL window. It holds at most size entries, evicting the oldest first.
+type SeenCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+	now     func() time.Time
+}
+
+type seenEntry struct {
+	hash string
+	at   time.Time
+}
+
+// NewSeenCache creates a new cache with the given TTL and maximum number of entries.
+func NewSeenCache(ttl time.Duration, size int) *SeenCache {
+	return &SeenCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Seen reports whether the hash has been seen within the TTL window and marks it as seen otherwise.
+func (c *SeenCache) Seen(hash []byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.expire(now)
+	if _, ok := c.entries[string(hash)]; ok {
+		return true
+	}
+	c.entries[string(hash)] = c.order.PushBack(seenEntry{string(hash), now})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Front())
+	}
+	return false
+}
+
+// Len returns the number of remembered hashes.
+func (c *SeenCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+func (c *SeenCache) expire(now time.Time) {
+	for elem := c.order.Front(); elem != nil; elem = c.order.Front() {
+		if now.Sub(elem.Value.(seenEntry).at) < c.ttl {
+			return
+		}
+		c.remove(elem)
+	}
+}
+
+func (c *SeenCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(seenEntry).hash)
+}
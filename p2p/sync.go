@@ -0,0 +1,301 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger"
+	"github.com/lnsp/txledger/ledger/block"
+	"github.com/lnsp/txledger/mempool"
+)
+
+// MaxMessageBytes is the maximum payload size of a message exchanged with peers.
+const MaxMessageBytes = block.MaxBlockBytes
+
+// SyncBatch is the number of blocks requested from a peer at once while syncing.
+var SyncBatch uint64 = 128
+
+const (
+	// msgHello carries the sender's chain ID and height.
+	msgHello uint64 = iota + 1
+	// msgGetBlocks requests the blocks in the index range [from, to).
+	msgGetBlocks
+	// msgBlock carries a single encoded block.
+	msgBlock
+)
+
+// Sync connects to the peer, downloads the blocks it has above the local tip in batches of SyncBatch
+// and appends each batch through TryAppend. Diverging blocks are kept as forks and the ledger is reorganized afterwards.
+// Peers on a different chain are refused. Transactions displaced by the reorg are dropped.
+func Sync(peerAddr string, l *ledger.Ledger) error {
+	conn, err := net.Dial("tcp", peerAddr)
+	if err != nil {
+		return errors.Wrap(err, "Could not connect to peer")
+	}
+	defer conn.Close()
+	return syncPeer(conn, l)
+}
+
+// ListenAndServe accepts peers on the given address, see Serve.
+func ListenAndServe(addr string, l *ledger.Ledger, pool *mempool.Pool, relay *Broadcaster) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "Could not listen for peers")
+	}
+	defer ln.Close()
+	return Serve(ln, l, pool, relay)
+}
+
+// Serve accepts peers on the listener, serves them blocks of the ledger and appends
+// the blocks they publish. The pool is updated after each appended block and receives the
+// transactions displaced by reorgs. Appended blocks are relayed to the peers of relay.
+// Both pool and relay may be nil. Blocks seen within SeenTTL are ignored.
+func Serve(ln net.Listener, l *ledger.Ledger, pool *mempool.Pool, relay *Broadcaster) error {
+	seen := NewSeenCache(SeenTTL, SeenSize)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return errors.Wrap(err, "Could not accept peer")
+		}
+		go func() {
+			defer conn.Close()
+			handle(conn, l, pool, seen, relay)
+		}()
+	}
+}
+
+// syncPeer runs the client side of the sync protocol on an established connection.
+func syncPeer(conn io.ReadWriter, l *ledger.Ledger) error {
+	height := l.Size()
+	if err := writeMessage(conn, msgHello, encode(l.Chain, height)); err != nil {
+		return errors.Wrap(err, "Could not greet peer")
+	}
+	var chain, peerHeight uint64
+	if err := expect(conn, msgHello, &chain, &peerHeight); err != nil {
+		return errors.Wrap(err, "Could not read peer greeting")
+	}
+	if chain != l.Chain {
+		return errors.Errorf("Peer is on chain %d instead of %d", chain, l.Chain)
+	}
+	if peerHeight <= height {
+		return nil
+	}
+	from, err := commonHeight(conn, l, height)
+	if err != nil {
+		return err
+	}
+	for i, to := from, from; i < peerHeight; i = to {
+		to = i + SyncBatch
+		if to > peerHeight || to < i {
+			to = peerHeight
+		}
+		blocks, err := fetch(conn, i, to)
+		if err != nil {
+			return err
+		}
+		for _, b := range blocks {
+			if err := l.TryAppend(b); err != nil && errors.Cause(err) != ledger.ErrStaleBlock {
+				return errors.Wrapf(err, "Could not append block %d", b.Index)
+			}
+		}
+	}
+	err = l.Reorg()
+	reinject(l, nil)
+	return err
+}
+
+// commonHeight finds the height up to which the local chain matches the peer's, probing
+// the peer's blocks backwards from the local tip in exponentially growing steps.
+func commonHeight(conn io.ReadWriter, l *ledger.Ledger, height uint64) (uint64, error) {
+	for i, step := height, uint64(1); i > 0; step *= 2 {
+		if step > i {
+			step = i
+		}
+		i -= step
+		probe, err := fetch(conn, i, i+1)
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := l.GetBlockByHash(probe[0].Hash()); ok {
+			return i + 1, nil
+		}
+	}
+	if height > 0 {
+		return 0, errors.New("Peer does not share the genesis block")
+	}
+	return 0, nil
+}
+
+// fetch requests the peer's blocks in [from, to).
+func fetch(conn io.ReadWriter, from, to uint64) ([]block.Block, error) {
+	if err := writeMessage(conn, msgGetBlocks, encode(from, to)); err != nil {
+		return nil, errors.Wrap(err, "Could not request blocks")
+	}
+	var blocks []block.Block
+	for i := from; i < to; i++ {
+		payload, err := readPayload(conn, msgBlock)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not read block %d", i)
+		}
+		b, err := block.Block{}.SetBytesFrom(bytes.NewReader(payload))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not decode block %d", i)
+		}
+		if b.Index != i {
+			return nil, errors.Errorf("Peer sent block %d instead of %d", b.Index, i)
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// handle runs the serving side of the sync protocol until the peer disconnects.
+// Published blocks not seen before are appended through TryAppend, followed by a reorg,
+// and relayed unless relay is nil.
+func handle(conn io.ReadWriter, l *ledger.Ledger, pool *mempool.Pool, seen *SeenCache, relay *Broadcaster) error {
+	greeted := false
+	for {
+		kind, payload, err := readMessage(conn)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch {
+		case kind == msgHello:
+			var chain, height uint64
+			if err := decode(payload, &chain, &height); err != nil {
+				return err
+			}
+			if err := writeMessage(conn, msgHello, encode(l.Chain, l.Size())); err != nil {
+				return err
+			}
+			if chain != l.Chain {
+				return errors.Errorf("Peer is on chain %d instead of %d", chain, l.Chain)
+			}
+			greeted = true
+		case !greeted:
+			return errors.New("Peer did not greet")
+		case kind == msgGetBlocks:
+			var from, to uint64
+			if err := decode(payload, &from, &to); err != nil {
+				return err
+			}
+			if pruned, _ := l.Checkpoint(); from < pruned {
+				return errors.Errorf("Blocks below %d are pruned", pruned)
+			}
+			for i := from; i < to; i++ {
+				b, ok := l.GetBlockByIndex(i)
+				if !ok {
+					return errors.Errorf("Block %d is unknown", i)
+				}
+				if err := writeMessage(conn, msgBlock, b.Bytes()); err != nil {
+					return err
+				}
+			}
+		case kind == msgBlock:
+			b, err := block.Block{}.SetBytesFrom(bytes.NewReader(payload))
+			if err != nil {
+				return errors.Wrap(err, "Could not decode published block")
+			}
+			if seen.Seen(b.Hash()) {
+				continue
+			}
+			if err := l.TryAppend(b); err != nil {
+				if errors.Cause(err) == ledger.ErrStaleBlock {
+					continue
+				}
+				return errors.Wrapf(err, "Could not append published block %d", b.Index)
+			}
+			err = l.Reorg()
+			reinject(l, pool)
+			if err != nil {
+				return err
+			}
+			if relay != nil {
+				relay.Publish(b)
+			}
+		default:
+			return errors.Errorf("Unknown message type %d", kind)
+		}
+	}
+}
+
+// reinject updates the pool to the ledger's tip and re-submits the transactions displaced
+// by reorgs. Without a pool the displaced transactions are dropped.
+func reinject(l *ledger.Ledger, pool *mempool.Pool) {
+	displaced := l.TakeDisplaced()
+	if pool == nil {
+		return
+	}
+	pool.Update(l.AddressTree(), l.ExpectedComplexity())
+	pool.Reinject(displaced)
+}
+
+// writeMessage writes the message type and the length-prefixed payload.
+func writeMessage(w io.Writer, kind uint64, payload []byte) error {
+	_, err := w.Write(append(encode(kind, uint64(len(payload))), payload...))
+	return err
+}
+
+// readMessage reads a message written by writeMessage.
+func readMessage(r io.Reader) (uint64, []byte, error) {
+	var kind, size uint64
+	if err := binary.Read(r, binary.LittleEndian, &kind); err != nil {
+		return 0, nil, err
+	}
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return 0, nil, errors.Wrap(err, "Could not read message size")
+	}
+	if size > MaxMessageBytes {
+		return 0, nil, errors.Errorf("Message exceeds %d bytes", MaxMessageBytes)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, errors.Wrap(err, "Could not read message")
+	}
+	return kind, payload, nil
+}
+
+// readPayload reads the next message and requires it to be of the given type.
+func readPayload(r io.Reader, kind uint64) ([]byte, error) {
+	actual, payload, err := readMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	if actual != kind {
+		return nil, errors.Errorf("Expected message type %d, got %d", kind, actual)
+	}
+	return payload, nil
+}
+
+// expect reads a message of the given type and decodes its fields.
+func expect(r io.Reader, kind uint64, fields ...*uint64) error {
+	payload, err := readPayload(r, kind)
+	if err != nil {
+		return err
+	}
+	return decode(payload, fields...)
+}
+
+func encode(fields ...uint64) []byte {
+	buffer := make([]byte, 8*len(fields))
+	for i, field := range fields {
+		binary.LittleEndian.PutUint64(buffer[8*i:], field)
+	}
+	return buffer
+}
+
+func decode(payload []byte, fields ...*uint64) error {
+	if len(payload) != 8*len(fields) {
+		return errors.Errorf("Message should be %d bytes, got %d", 8*len(fields), len(payload))
+	}
+	for i, field := range fields {
+		*field = binary.LittleEndian.Uint64(payload[8*i:])
+	}
+	return nil
+}
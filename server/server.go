@@ -0,0 +1,220 @@
+// Package server exposes a ledger over a JSON HTTP API.
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/lnsp/txledger/ledger"
+	"github.com/lnsp/txledger/ledger/transaction"
+	"github.com/lnsp/txledger/mempool"
+)
+
+// MaxRequestBytes is the maximum size of a submitted transaction body.
+const MaxRequestBytes = 1 << 16
+
+// Server answers queries about a ledger. It only uses the ledger's locked accessors
+// and may serve requests while blocks are appended.
+type Server struct {
+	ledger *ledger.Ledger
+	pool   *mempool.Pool
+	mux    *http.ServeMux
+}
+
+// New creates a server answering queries about the given ledger. Submitted transactions
+// are added to the pool, without a pool the server is read-only.
+func New(l *ledger.Ledger, pool *mempool.Pool) *Server {
+	s := &Server{ledger: l, pool: pool, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/height", method(http.MethodGet, s.height))
+	s.mux.HandleFunc("/supply", method(http.MethodGet, s.supply))
+	s.mux.HandleFunc("/block/", method(http.MethodGet, s.block))
+	s.mux.HandleFunc("/account/", method(http.MethodGet, s.account))
+	s.mux.HandleFunc("/tx/", method(http.MethodGet, s.transaction))
+	s.mux.HandleFunc("/tx", method(http.MethodPost, s.submit))
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "Not found")
+	})
+	return s
+}
+
+// Serve listens on the given address and answers queries about the ledger.
+// Transactions are accepted into the pool unless it is nil.
+func Serve(l *ledger.Ledger, pool *mempool.Pool, addr string) error {
+	return http.ListenAndServe(addr, New(l, pool))
+}
+
+// ServeHTTP dispatches the request to the matching endpoint.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// method rejects requests not using the given method.
+func method(m string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != m {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		h(w, r)
+	}
+}
+
+type heightJSON struct {
+	Height uint64 `json:"height"`
+}
+
+type supplyJSON struct {
+	Supply uint64 `json:"supply"`
+	Burned uint64 `json:"burned"`
+}
+
+type accountJSON struct {
+	Address string `json:"address"`
+	Funds   uint64 `json:"funds"`
+}
+
+type transactionJSON struct {
+	Block       uint64         `json:"block"`
+	Transaction transaction.TX `json:"transaction"`
+}
+
+type submitJSON struct {
+	Hash string `json:"hash"`
+}
+
+type errorJSON struct {
+	Error string `json:"error"`
+}
+
+// height handles GET /height.
+func (s *Server) height(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, heightJSON{Height: s.ledger.Size()})
+}
+
+// supply handles GET /supply.
+func (s *Server) supply(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, supplyJSON{Supply: s.ledger.TotalSupply(), Burned: s.ledger.Burned()})
+}
+
+// block handles GET /block/{index} and GET /block/hash/{hash}.
+func (s *Server) block(w http.ResponseWriter, r *http.Request) {
+	param := strings.TrimPrefix(r.URL.Path, "/block/")
+	if strings.HasPrefix(param, "hash/") {
+		hash, err := hex.DecodeString(strings.TrimPrefix(param, "hash/"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid block hash")
+			return
+		}
+		b, ok := s.ledger.GetBlockByHash(hash)
+		if !ok {
+			writeError(w, http.StatusNotFound, "Block not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, b)
+		return
+	}
+	index, err := strconv.ParseUint(param, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid block index")
+		return
+	}
+	b, ok := s.ledger.GetBlockByIndex(index)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Block not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, b)
+}
+
+// account handles GET /account/{address}.
+func (s *Server) account(w http.ResponseWriter, r *http.Request) {
+	address, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/account/"), "0x"))
+	if err != nil || len(address) != transaction.AddressSize {
+		writeError(w, http.StatusBadRequest, "Invalid address")
+		return
+	}
+	funds, ok := s.ledger.Balance(address)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Account not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, accountJSON{Address: hex.EncodeToString(address), Funds: funds})
+}
+
+// transaction handles GET /tx/{hash}.
+func (s *Server) transaction(w http.ResponseWriter, r *http.Request) {
+	hash, err := hex.DecodeString(strings.TrimPrefix(r.URL.Path, "/tx/"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid transaction hash")
+		return
+	}
+	tx, index, ok := s.ledger.FindTransaction(hash)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Transaction not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, transactionJSON{Block: index, Transaction: tx})
+}
+
+// submit handles POST /tx with a JSON-encoded or hex-encoded binary transaction.
+// The transaction is validated by the pool against its address tree.
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	if s.pool == nil {
+		writeError(w, http.StatusNotFound, "Transaction submission is disabled")
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxRequestBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Could not read transaction")
+		return
+	}
+	tx, err := decodeTransaction(bytes.TrimSpace(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.pool.Add(tx); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, submitJSON{Hash: hex.EncodeToString(tx.Hash())})
+}
+
+// decodeTransaction decodes a JSON object or the hex encoding of the binary format.
+func decodeTransaction(body []byte) (transaction.TX, error) {
+	if bytes.HasPrefix(body, []byte("{")) {
+		var tx transaction.TX
+		if err := json.Unmarshal(body, &tx); err != nil {
+			return tx, errors.Wrap(err, "Malformed transaction")
+		}
+		return tx, nil
+	}
+	raw, err := hex.DecodeString(string(body))
+	if err != nil {
+		return transaction.TX{}, errors.Wrap(err, "Malformed transaction")
+	}
+	tx, err := transaction.New().SetBytesE(raw)
+	if err != nil {
+		return transaction.TX{}, errors.Wrap(err, "Malformed transaction")
+	}
+	return tx, nil
+}
+
+// writeJSON writes v as the JSON response body with the given status.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error body with the given status.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorJSON{Error: msg})
+}
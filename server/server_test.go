@@ -0,0 +1,190 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lnsp/txledger/ledger"
+	"github.com/lnsp/txledger/ledger/account"
+	"github.com/lnsp/txledger/ledger/block"
+	"github.com/lnsp/txledger/ledger/transaction"
+	"github.com/lnsp/txledger/mempool"
+)
+
+func testLedger(t *testing.T) (*ledger.Ledger, *account.Private) {
+	l := ledger.New(1)
+	miner := account.NewPrivate()
+	if err := l.Init(0, miner); err != nil {
+		t.Fatal("Could not init ledger:", err)
+	}
+	return l, miner
+}
+
+func get(t *testing.T, s *Server, path string, v interface{}) int {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET %s should respond with JSON, got %q", path, ct)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("Could not decode response of GET %s: %v", path, err)
+	}
+	return rec.Code
+}
+
+func TestHeight(t *testing.T) {
+	l, _ := testLedger(t)
+	var resp heightJSON
+	if code := get(t, New(l, nil), "/height", &resp); code != http.StatusOK || resp.Height != 1 {
+		t.Errorf("GET /height should return 1, got %d with %+v", code, resp)
+	}
+}
+
+func TestSupply(t *testing.T) {
+	l, _ := testLedger(t)
+	holder := account.NewPrivate()
+	l.Addresses.ReplaceOrInsert(account.AddressTreeItem{Address: holder.Address(), Account: holder, Funds: 20000})
+	var resp supplyJSON
+	if code := get(t, New(l, nil), "/supply", &resp); code != http.StatusOK || resp.Supply != 20000 {
+		t.Errorf("GET /supply should return 20000, got %d with %+v", code, resp)
+	}
+}
+
+func TestBlock(t *testing.T) {
+	l, _ := testLedger(t)
+	s := New(l, nil)
+	genesis, _ := l.GetBlockByIndex(0)
+	for _, path := range []string{"/block/0", "/block/hash/" + hex.EncodeToString(genesis.Hash())} {
+		var b block.Block
+		if code := get(t, s, path, &b); code != http.StatusOK {
+			t.Fatalf("GET %s should succeed, got %d", path, code)
+		}
+		if hex.EncodeToString(b.Hash()) != hex.EncodeToString(genesis.Hash()) {
+			t.Errorf("GET %s should return the genesis block", path)
+		}
+	}
+	cases := map[string]int{
+		"/block/1": http.StatusNotFound,
+		"/block/hash/" + hex.EncodeToString(make([]byte, block.HashSize)): http.StatusNotFound,
+		"/block/abc":      http.StatusBadRequest,
+		"/block/hash/xyz": http.StatusBadRequest,
+		"/unknown":        http.StatusNotFound,
+	}
+	for path, want := range cases {
+		var resp errorJSON
+		if code := get(t, s, path, &resp); code != want || resp.Error == "" {
+			t.Errorf("GET %s should fail with %d, got %d with %+v", path, want, code, resp)
+		}
+	}
+}
+
+func TestAccount(t *testing.T) {
+	l, miner := testLedger(t)
+	s := New(l, nil)
+	funds, _ := l.Balance(miner.Address())
+	var resp accountJSON
+	if code := get(t, s, "/account/"+hex.EncodeToString(miner.Address()), &resp); code != http.StatusOK {
+		t.Fatalf("GET /account should succeed, got %d", code)
+	}
+	if resp.Funds != funds || resp.Address != hex.EncodeToString(miner.Address()) {
+		t.Errorf("GET /account should return funds %d, got %+v", funds, resp)
+	}
+	var missing errorJSON
+	if code := get(t, s, "/account/"+hex.EncodeToString(account.NewPrivate().Address()), &missing); code != http.StatusNotFound {
+		t.Errorf("GET /account of unknown address should fail with 404, got %d", code)
+	}
+	if code := get(t, s, "/account/1234", &missing); code != http.StatusBadRequest {
+		t.Errorf("GET /account of malformed address should fail with 400, got %d", code)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	l, _ := testLedger(t)
+	s := New(l, nil)
+	genesis, _ := l.GetBlockByIndex(0)
+	coinbase := genesis.Data[0]
+	var resp transactionJSON
+	if code := get(t, s, "/tx/"+hex.EncodeToString(coinbase.Hash()), &resp); code != http.StatusOK {
+		t.Fatalf("GET /tx should succeed, got %d", code)
+	}
+	if resp.Block != 0 || hex.EncodeToString(resp.Transaction.Hash()) != hex.EncodeToString(coinbase.Hash()) {
+		t.Errorf("GET /tx should return the genesis coinbase, got %+v", resp)
+	}
+	var missing errorJSON
+	if code := get(t, s, "/tx/"+hex.EncodeToString(make([]byte, 32)), &missing); code != http.StatusNotFound {
+		t.Errorf("GET /tx of unknown hash should fail with 404, got %d", code)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	l, _ := testLedger(t)
+	rec := httptest.NewRecorder()
+	New(l, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/height", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE /height should fail with 405, got %d", rec.Code)
+	}
+}
+
+func post(t *testing.T, s *Server, body string, v interface{}) int {
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tx", strings.NewReader(body)))
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("Could not decode response of POST /tx: %v", err)
+	}
+	return rec.Code
+}
+
+func TestSubmit(t *testing.T) {
+	l, _ := testLedger(t)
+	sender, recipient := account.NewPrivate(), account.NewPrivate()
+	for _, acc := range []*account.Private{sender, recipient} {
+		l.Addresses.ReplaceOrInsert(account.AddressTreeItem{Address: acc.Address(), Account: acc, Funds: 20000})
+	}
+	pool := mempool.New(l.Addresses, 0)
+	s := New(l, pool)
+	fee := transaction.EstimateFee(0, 0)
+
+	valid := transaction.NewTransfer(l.Chain, 10, fee, 1, sender, recipient)
+	encoded, _ := json.Marshal(valid)
+	var accepted submitJSON
+	if code := post(t, s, string(encoded), &accepted); code != http.StatusAccepted {
+		t.Fatalf("POST /tx of a valid transfer should be accepted, got %d", code)
+	}
+	if accepted.Hash != hex.EncodeToString(valid.Hash()) || pool.Len() != 1 {
+		t.Errorf("Accepted transfer should be pooled and return its hash, got %+v", accepted)
+	}
+	binary := transaction.NewTransfer(l.Chain, 10, fee, 2, sender, recipient)
+	if code := post(t, s, hex.EncodeToString(binary.Bytes()), &accepted); code != http.StatusAccepted {
+		t.Fatalf("POST /tx of a hex-encoded transfer should be accepted, got %d", code)
+	}
+
+	forged := transaction.NewTransfer(l.Chain, 10, fee, 3, sender, recipient)
+	forged.Proof[0] ^= 0xff
+	underpaid := transaction.NewTransfer(l.Chain, 10, 1, 3, sender, recipient)
+	cases := map[string]string{
+		"bad signature":    hex.EncodeToString(forged.Bytes()),
+		"insufficient fee": hex.EncodeToString(underpaid.Bytes()),
+		"malformed":        "not a transaction",
+	}
+	for name, body := range cases {
+		var resp errorJSON
+		if code := post(t, s, body, &resp); code != http.StatusBadRequest || resp.Error == "" {
+			t.Errorf("POST /tx with %s should fail with 400, got %d with %+v", name, code, resp)
+		}
+	}
+	if pool.Len() != 2 {
+		t.Errorf("Rejected transactions should not be pooled, got %d pending", pool.Len())
+	}
+}
+
+func TestSubmitReadOnly(t *testing.T) {
+	l, _ := testLedger(t)
+	var resp errorJSON
+	if code := post(t, New(l, nil), "{}", &resp); code != http.StatusNotFound {
+		t.Errorf("POST /tx without a pool should fail with 404, got %d", code)
+	}
+}